@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"git.tideland.biz/goas/logger"
+	"github.com/ctdk/goiardi/config"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+/* The access log gets a line for each request, either like Apache's combined
+ * log format or as a JSON object. */
+type accessLogger struct {
+	m sync.Mutex
+	f *os.File
+	w io.Writer
+}
+
+var accessLog = &accessLogger{}
+
+/* Open the access log, closing the old one. It's opened again when goiardi
+ * reloads its configuration, so it can be rotated. */
+func (a *accessLogger) open(file string) error {
+	var f *os.File
+	var w io.Writer
+	if file == "-" {
+		w = os.Stdout
+	} else if file != "" {
+		var err error
+		f, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.f != nil {
+		a.f.Close()
+	}
+	a.f = f
+	a.w = w
+	return nil
+}
+
+type accessLogEntry struct {
+	Time string `json:"time"`
+	RequestId string `json:"request_id"`
+	RemoteAddr string `json:"remote_addr"`
+	Actor string `json:"actor,omitempty"`
+	Method string `json:"method"`
+	Path string `json:"path"`
+	Proto string `json:"proto"`
+	Status int `json:"status"`
+	Bytes int64 `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Referer string `json:"referer,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+func (a *accessLogger) log(r *http.Request, actor string, sw *statusWriter, start time.Time) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.w == nil {
+		return
+	}
+	path := r.RequestURI
+	if path == "" {
+		path = r.URL.RequestURI()
+	}
+	entry := &accessLogEntry{
+		Time: start.Format(time.RFC3339),
+		RequestId: requestId(r),
+		RemoteAddr: remoteAddr(r),
+		Actor: actor,
+		Method: r.Method,
+		Path: path,
+		Proto: r.Proto,
+		Status: sw.status,
+		Bytes: sw.bytes,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Referer: r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+	var err error
+	if config.Get().AccessLogFormat == "json" {
+		err = json.NewEncoder(a.w).Encode(entry)
+	} else {
+		_, err = io.WriteString(a.w, combinedLine(entry, start))
+	}
+	if err != nil {
+		logger.Errorf("Couldn't write to the access log: %s", err.Error())
+	}
+}
+
+/* Apache's combined log format, with the request id and how long the request
+ * took in milliseconds on the end. */
+func combinedLine(e *accessLogEntry, start time.Time) string {
+	actor := e.Actor
+	if actor == "" {
+		actor = "-"
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = fmt.Sprintf("%d", e.Bytes)
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %s %q %q %s %.3f\n", e.RemoteAddr, actor, start.Format("02/Jan/2006:15:04:05 -0700"), e.Method + " " + e.Path + " " + e.Proto, e.Status, bytes, e.Referer, e.UserAgent, e.RequestId, e.DurationMs)
+}
+
+type requestIdKey struct{}
+
+/* A request id sent by a proxy in front of goiardi is used if it looks sane,
+ * so the proxy's logs and goiardi's can be matched up. */
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+/* Give the request an id, for the X-Request-Id response header and for logging
+ * with anything that goes wrong with it. */
+func withRequestId(r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-Id")
+	if !validRequestId.MatchString(id) {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			logger.Errorf("Couldn't make a request id: %s", err.Error())
+		}
+		id = hex.EncodeToString(b)
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id))
+}
+
+func requestId(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdKey{}).(string)
+	return id
+}
+
+/* Put the request id at the start of log messages about the request. */
+func logPrefix(r *http.Request) string {
+	if id := requestId(r); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
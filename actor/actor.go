@@ -45,7 +45,7 @@ type Actor interface {
 // the admin user.
 func GetReqUser(name string) (Actor, util.Gerror) {
 	/* If UseAuth is turned off, use the automatically created admin user */
-	if !config.Config.UseAuth {
+	if !config.Get().UseAuth {
 		name = "admin"
 	}
 	var c Actor
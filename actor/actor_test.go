@@ -25,7 +25,7 @@ import (
 )
 
 func TestActorClient(t *testing.T) {
-	config.Config.UseAuth = true
+	config.Get().UseAuth = true
 	c, _ := client.New("fooclient")
 	c.Save()
 	c1, err := GetReqUser("fooclient")
@@ -56,7 +56,7 @@ func TestActorClient(t *testing.T) {
 }
 
 func TestActorUser(t *testing.T) {
-	config.Config.UseAuth = true
+	config.Get().UseAuth = true
 	u, err := user.New("foo1user")
 	if err != nil {
 		t.Errorf(err.Error())
@@ -19,11 +19,18 @@
 package main
 
 import (
+	"net"
 	"net/http"
 	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/authentication"
+	"github.com/ctdk/goiardi/ldap_auth"
 	"github.com/ctdk/goiardi/user"
 	"github.com/ctdk/goiardi/config"
+	"git.tideland.biz/goas/logger"
 )
 
 type authenticator struct {
@@ -32,6 +39,8 @@ type authenticator struct {
 type authResponse struct {
 	Name string `json:"name"`
 	Verified bool `json:"verified"`
+	/* Whether the user exists, in goiardi or the LDAP directory. */
+	known bool
 }
 
 func authenticate_user_handler(w http.ResponseWriter, r *http.Request){
@@ -49,8 +58,43 @@ func authenticate_user_handler(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
+	/* Failed logins are tracked for each user and each address they
+	 * come from, so passwords can't be guessed as fast as they can be
+	 * posted. Logins through the web UI all come from its address, so
+	 * they're only tracked by user. */
+	addr := remoteAddr(r)
+	lockAddr := addr
+	if authentication.FromWebUI(r) {
+		lockAddr = ""
+	}
+	if config.Get().UseAuth {
+		if wait, locked := authentication.LoginLockedOut(auth.Name, lockAddr); locked {
+			logger.Warningf("%saudit: refused login for user %s from %s, locked out for another %s", logPrefix(r), auth.Name, addr, wait.String())
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			JsonErrorReport(w, r, "Too many failed logins, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	resp := validateLogin(auth)
 
+	if config.Get().UseAuth {
+		if resp.Verified {
+			authentication.LoginSucceeded(auth.Name)
+			logger.Infof("%saudit: successful login for user %s from %s", logPrefix(r), auth.Name, addr)
+		} else {
+			lockUser := auth.Name
+			if !resp.known {
+				lockUser = ""
+			}
+			locked := authentication.LoginFailed(lockUser, lockAddr)
+			logger.Warningf("%saudit: failed login for user %s from %s", logPrefix(r), auth.Name, addr)
+			if locked {
+				logger.Warningf("%saudit: locking out logins for user %s or from %s after too many failures", logPrefix(r), auth.Name, addr)
+			}
+		}
+	}
+
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(resp); err != nil {
 		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
@@ -62,20 +106,52 @@ func validateLogin(auth *authenticator) authResponse {
 	// Automatically validate if UseAuth is not on
 	var resp authResponse
 	resp.Name = auth.Name
-	if !config.Config.UseAuth {
+	if !config.Get().UseAuth {
 		resp.Verified = true
 		return resp
 	}
+	/* With LDAP, everyone but the configured local users, like the
+	 * default admin, has to log in through the directory. If the user
+	 * isn't there or the directory can't be reached, the login fails
+	 * rather than falling back to the goiardi password. */
+	if config.Get().UseLDAP && !ldap_auth.IsLocalUser(auth.Name) {
+		_, lerr := ldap_auth.Login(auth.Name, auth.Password)
+		switch lerr {
+			case nil:
+				resp.Verified = true
+				resp.known = true
+			case ldap_auth.ErrBadPassword:
+				resp.Verified = false
+				resp.known = true
+			case ldap_auth.ErrUserNotFound:
+				resp.Verified = false
+			default:
+				logger.Errorf("LDAP login for user %s had an error: %s", auth.Name, lerr.Error())
+				resp.Verified = false
+		}
+		return resp
+	}
 	u, err := user.Get(auth.Name)
 	if err != nil {
 		resp.Verified = false
 		return resp
 	} 
+	resp.known = true
 	perr := u.CheckPasswd(auth.Password)
 	if perr != nil {
 		resp.Verified = false
-	} else {
-		resp.Verified = true
+		return resp
+	}
+	resp.Verified = true
+	/* Passwords hashed the old way are re-hashed with bcrypt now that we
+	 * have the password. A failure here shouldn't stop the login. */
+	upgraded, uerr := u.UpgradePasswd(auth.Password)
+	if uerr != nil {
+		logger.Warningf("Could not upgrade the password hash for user %s: %s", u.Username, uerr.Error())
+	} else if upgraded {
+		if serr := u.Save(); serr != nil {
+			logger.Errorf("Could not save the upgraded password hash for user %s: %s", u.Username, serr.Error())
+		}
 	}
 	return resp
 }
@@ -108,3 +184,63 @@ func validateJson(authJson map[string]interface{}) (*authenticator, error) {
 	}
 	return auth, nil
 }
+
+func login_lockout_handler(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+
+	/* Failed logins are listed at /login_lockouts, and found at
+	 * /login_lockouts/user/NAME or /login_lockouts/ip/ADDRESS. */
+	path_array := SplitPath(r.URL.Path)
+	var response interface{}
+	switch {
+		case len(path_array) == 1:
+			if r.Method != "GET" {
+				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			response = authentication.GetLoginFailures()
+		case len(path_array) == 3 && (path_array[1] == "user" || path_array[1] == "ip"):
+			kind, key := path_array[1], path_array[2]
+			failures, found := authentication.GetLoginFailure(kind, key)
+			if !found {
+				JsonErrorReport(w, r, fmt.Sprintf("No failed logins for %s %s", kind, key), http.StatusNotFound)
+				return
+			}
+			switch r.Method {
+				case "GET":
+				case "DELETE":
+					authentication.ClearLoginFailures(kind, key)
+					logger.Infof("%saudit: %s cleared failed logins for %s %s", logPrefix(r), opUser.GetName(), kind, key)
+				default:
+					JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+					return
+			}
+			response = failures
+		default:
+			JsonErrorReport(w, r, "not found", http.StatusNotFound)
+			return
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* The address a request came from, without the port. */
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -15,7 +15,7 @@
  */
 
 // Package authentication contains functions used to authenticate requests from
-// the signed headers.
+// the signed headers or client certificates.
 package authentication
 
 /* Geez, import all the things why don't you. */
@@ -25,6 +25,7 @@ import (
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/metrics"
 	"net/http"
 	"io"
 	"io/ioutil"
@@ -39,31 +40,38 @@ import (
 	"fmt"
 )
 
+var authFailures = metrics.NewCounter("goiardi_auth_failures_total", "Requests that failed authentication, by reason.", "reason")
+
 // Check the signed headers sent by the client against the expected result
-// assembled from the request headers to verify their authorization.
-func CheckHeader(user_id string, r *http.Request) util.Gerror {
+// assembled from the request headers to verify their authorization. Returns
+// the client or user the request was made by.
+func CheckHeader(user_id string, r *http.Request) (actor.Actor, util.Gerror) {
 	user, err := actor.GetReqUser(user_id)
 	if err != nil {
 		gerr := util.Errorf("Failed to authenticate as '%s'. Ensure that your node_name and client key are correct.", user_id)
 		gerr.SetStatus(http.StatusUnauthorized)
-		return gerr
+		authFailures.Inc("unknown_actor")
+		return nil, gerr
 	} 
 	contentHash := r.Header.Get("X-OPS-CONTENT-HASH")
 	if contentHash == "" {
 		gerr := util.Errorf("no content hash provided")
 		gerr.SetStatus(http.StatusBadRequest)
-		return gerr
+		authFailures.Inc("missing_content_hash")
+		return nil, gerr
 	}
 	authTimestamp := r.Header.Get("x-ops-timestamp")
 	if authTimestamp == "" {
 		gerr := util.Errorf("no timestamp header provided")
 		gerr.SetStatus(http.StatusBadRequest)
-		return gerr
+		authFailures.Inc("missing_timestamp")
+		return nil, gerr
 	} else {
 		// check the time stamp w/ allowed slew
-		tok, terr := checkTimeStamp(authTimestamp, config.Config.TimeSlewDur)
+		tok, terr := checkTimeStamp(authTimestamp, config.Get().TimeSlewDur)
 		if !tok {
-			return terr
+			authFailures.Inc("bad_timestamp")
+			return nil, terr
 		}
 	}
 	// Eventually this may be put to some sort of use, but for now just
@@ -74,7 +82,8 @@ func CheckHeader(user_id string, r *http.Request) util.Gerror {
 	var apiVer string
 	if xopssign == "" {
 		gerr := util.Errorf("missing X-Ops-Sign header")
-		return gerr
+		authFailures.Inc("missing_sign")
+		return nil, gerr
 	} else {
 		re := regexp.MustCompile(`version=(\d+\.\d+)`)
 		shaRe := regexp.MustCompile(`algorithm=(\w+)`)
@@ -82,11 +91,13 @@ func CheckHeader(user_id string, r *http.Request) util.Gerror {
 			apiVer = verChk[1]
 			if apiVer != "1.0" && apiVer != "1.1" {
 				gerr := util.Errorf("Bad version number '%s' in X-Ops-Header", apiVer)
-				return gerr
+				authFailures.Inc("bad_version")
+				return nil, gerr
 			}
 		} else {
 			gerr := util.Errorf("malformed version in X-Ops-Header")
-			return gerr
+			authFailures.Inc("bad_version")
+			return nil, gerr
 		}
 
 		// if algorithm is missing, it uses sha1. Of course, no other
@@ -94,24 +105,28 @@ func CheckHeader(user_id string, r *http.Request) util.Gerror {
 		if shaChk := shaRe.FindStringSubmatch(xopssign); shaChk != nil {
 			if shaChk[1] != "sha1" {
 				gerr := util.Errorf("Unsupported hashing algorithm '%s' specified in X-Ops-Header", shaChk[1])
-				return gerr
+				authFailures.Inc("bad_algorithm")
+				return nil, gerr
 			}
 		}
 	}
 
 	chkHash, chkerr := calcBodyHash(r)
 	if chkerr != nil {
-		return chkerr
+		authFailures.Inc("unreadable_body")
+		return nil, chkerr
 	}
 	if chkHash != contentHash {
 		gerr := util.Errorf("Content hash did not match hash of request body")
 		gerr.SetStatus(http.StatusUnauthorized)
-		return gerr
+		authFailures.Inc("content_hash_mismatch")
+		return nil, gerr
 	}
 
 	signedHeaders, sherr  := assembleSignedHeader(r)
 	if sherr != nil {
-		return sherr
+		authFailures.Inc("bad_auth_headers")
+		return nil, sherr
 	}
 	headToCheck := assembleHeaderToCheck(r, chkHash, apiVer)
 
@@ -120,15 +135,17 @@ func CheckHeader(user_id string, r *http.Request) util.Gerror {
 	if berr != nil {
 		gerr := util.Errorf(berr.Error())
 		gerr.SetStatus(http.StatusUnauthorized)
-		return gerr
+		authFailures.Inc("bad_signature")
+		return nil, gerr
 	}
 	if string(decHead) != headToCheck {
 		gerr := util.Errorf("failed to verify authorization")
 		gerr.SetStatus(http.StatusUnauthorized)
-		return gerr
+		authFailures.Inc("bad_signature")
+		return nil, gerr
 	}
 
-	return nil
+	return user, nil
 }
 
 // liberated from net/http/httputil
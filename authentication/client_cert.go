@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package authentication
+
+import (
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/util"
+	"net/http"
+)
+
+/* With ssl-client-ca set, clients can be made to present a certificate signed
+ * by that CA, and the common name of the certificate is the name of the client
+ * or user it's for. */
+
+// Get the common name of the verified client certificate the request was made
+// with. It's empty if there isn't one.
+func CertName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// Check that the request was made with a verified client certificate for the
+// named client or user, and that they exist. Returns the client or user.
+func CheckCert(user_id string, r *http.Request) (actor.Actor, util.Gerror) {
+	name := CertName(r)
+	if name == "" {
+		gerr := util.Errorf("A client certificate is required.")
+		gerr.SetStatus(http.StatusUnauthorized)
+		authFailures.Inc("missing_client_cert")
+		return nil, gerr
+	}
+	if name != user_id {
+		gerr := util.Errorf("The client certificate is for '%s', not '%s'.", name, user_id)
+		gerr.SetStatus(http.StatusUnauthorized)
+		authFailures.Inc("client_cert_mismatch")
+		return nil, gerr
+	}
+	user, err := actor.GetReqUser(user_id)
+	if err != nil {
+		gerr := util.Errorf("Failed to authenticate as '%s'. Ensure that your client certificate is correct.", user_id)
+		gerr.SetStatus(http.StatusUnauthorized)
+		authFailures.Inc("unknown_actor")
+		return nil, gerr
+	}
+	return user, nil
+}
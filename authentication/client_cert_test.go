@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package authentication
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/ctdk/goiardi/client"
+	"github.com/ctdk/goiardi/config"
+	"net/http"
+	"testing"
+)
+
+func certRequest(name string) *http.Request {
+	r, _ := http.NewRequest("GET", "https://localhost/nodes", nil)
+	if name != "" {
+		cert := &x509.Certificate{ Subject: pkix.Name{ CommonName: name } }
+		r.TLS = &tls.ConnectionState{ VerifiedChains: [][]*x509.Certificate{ { cert } } }
+	}
+	return r
+}
+
+func TestCheckCert(t *testing.T) {
+	config.Get().UseAuth = true
+	defer func() { config.Get().UseAuth = false }()
+	c, _ := client.New("cert_client")
+	c.Save()
+	defer c.Delete()
+
+	if n := CertName(certRequest("")); n != "" {
+		t.Errorf("request without a client certificate had the name '%s'", n)
+	}
+	if n := CertName(certRequest("cert_client")); n != "cert_client" {
+		t.Errorf("expected the client certificate's name to be 'cert_client', got '%s'", n)
+	}
+	if a, err := CheckCert("cert_client", certRequest("cert_client")); err != nil {
+		t.Errorf("cert_client's certificate should have been accepted, but: %s", err.Error())
+	} else if a.GetName() != "cert_client" {
+		t.Errorf("expected the actor for cert_client, got %s", a.GetName())
+	}
+	if _, err := CheckCert("cert_client", certRequest("")); err == nil {
+		t.Errorf("a request without a client certificate was accepted")
+	}
+	if _, err := CheckCert("cert_client", certRequest("someone_else")); err == nil {
+		t.Errorf("someone_else's certificate was accepted for cert_client")
+	}
+	if _, err := CheckCert("nobody", certRequest("nobody")); err == nil {
+		t.Errorf("a certificate for a client that doesn't exist was accepted")
+	} else if err.Status() != http.StatusUnauthorized {
+		t.Errorf("expected status %d for an unknown client, got %d", http.StatusUnauthorized, err.Status())
+	}
+}
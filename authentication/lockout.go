@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authentication
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/* Failed password logins are counted for each user and for each address they
+ * come from. Once either has failed too many times in a row, logins for that
+ * user or from that address are refused for a while, and each failure after
+ * that doubles how long, up to maxLockout. A successful login clears the
+ * user's count, but not the address's, so an address can't keep trying other
+ * users' passwords by logging in as itself now and then. Counts are forgotten
+ * once there haven't been any failures for forgetFailures.
+ *
+ * Only users who exist are counted, so posting made up names doesn't fill up
+ * the counts. Logins made through the web UI all come from the web UI's
+ * address, so they aren't counted by address; one person failing to log in
+ * there would lock everyone else out of it. An empty user name or address
+ * isn't counted or checked. */
+
+const (
+	maxLockout = time.Hour
+	forgetFailures = 24 * time.Hour
+)
+
+// Failed logins for a user or an address.
+type LoginFailures struct {
+	// Either "user" or "ip".
+	Kind string `json:"kind"`
+	// The user's name or the address.
+	Key string `json:"key"`
+	Failures int `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	// When logins will be allowed again, if they're being refused now.
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+var loginFailures = struct {
+	sync.Mutex
+	m map[string]*LoginFailures
+	swept time.Time
+}{ m: make(map[string]*LoginFailures) }
+
+/* Lets tests pretend time has passed. */
+var timeNow = time.Now
+
+// Check whether logins as the given user or from the given address are being
+// refused because of too many failed logins. If they are, returns how long
+// until they'll be allowed again.
+func LoginLockedOut(username string, addr string) (time.Duration, bool) {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	now := timeNow()
+	var wait time.Duration
+	for _, f := range []*LoginFailures{ getFailures("user", username), getFailures("ip", addr) } {
+		if f != nil && f.LockedUntil != nil && f.LockedUntil.After(now) {
+			if w := f.LockedUntil.Sub(now); w > wait {
+				wait = w
+			}
+		}
+	}
+	return wait, wait > 0
+}
+
+// Record a failed login as the given user from the given address. Returns true
+// if either is locked out now.
+func LoginFailed(username string, addr string) bool {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	now := timeNow()
+	forgetOldFailures(now)
+	conf := config.Get()
+	userLocked := addFailure("user", username, conf.LoginAttempts, conf.LoginLockoutDur, now)
+	ipLocked := addFailure("ip", addr, conf.LoginIPAttempts, conf.LoginLockoutDur, now)
+	return userLocked || ipLocked
+}
+
+// Record a successful login as the given user, clearing its failed logins.
+func LoginSucceeded(username string) {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	delete(loginFailures.m, failureKey("user", username))
+}
+
+// Get the users and addresses with failed logins, with the ones locked out for
+// longest first.
+func GetLoginFailures() []*LoginFailures {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	forgetOldFailures(timeNow())
+	failures := make([]*LoginFailures, 0, len(loginFailures.m))
+	for _, f := range loginFailures.m {
+		c := *f
+		failures = append(failures, &c)
+	}
+	sort.Sort(byLockedUntil(failures))
+	return failures
+}
+
+// Get the failed logins for a user or an address. kind is "user" or "ip".
+func GetLoginFailure(kind string, key string) (*LoginFailures, bool) {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	f, found := loginFailures.m[failureKey(kind, key)]
+	if !found {
+		return nil, false
+	}
+	c := *f
+	return &c, true
+}
+
+// Clear the failed logins for a user or an address, lifting any lockout.
+// Returns false if there weren't any.
+func ClearLoginFailures(kind string, key string) bool {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	k := failureKey(kind, key)
+	_, found := loginFailures.m[k]
+	delete(loginFailures.m, k)
+	return found
+}
+
+func failureKey(kind string, key string) string {
+	return kind + ":" + key
+}
+
+func getFailures(kind string, key string) *LoginFailures {
+	if key == "" {
+		return nil
+	}
+	return loginFailures.m[failureKey(kind, key)]
+}
+
+// Was the login request made by the web UI, either as chef-webui or for a user
+// through the web UI? The request has to have been authenticated already.
+func FromWebUI(r *http.Request) bool {
+	return r.Header.Get("X-Ops-UserId") == "chef-webui" || r.Header.Get("X-Ops-Request-Source") == "web"
+}
+
+/* Count a failure, locking out the user or address for the lockout time,
+ * doubled for each failure past the allowed count, if it's failed at least as
+ * many times as allowed. An allowed count of 0 or less means never locking it
+ * out. */
+func addFailure(kind string, key string, allowed int, lockout time.Duration, now time.Time) bool {
+	if allowed <= 0 || key == "" {
+		return false
+	}
+	k := failureKey(kind, key)
+	f, found := loginFailures.m[k]
+	if !found {
+		f = &LoginFailures{ Kind: kind, Key: key }
+		loginFailures.m[k] = f
+	}
+	f.Failures++
+	f.LastFailure = now
+	if f.Failures < allowed {
+		return false
+	}
+	for i := allowed; i < f.Failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		lockout = maxLockout
+	}
+	until := now.Add(lockout)
+	f.LockedUntil = &until
+	return true
+}
+
+/* Only look for old failures to forget every so often, since a lot of
+ * addresses might be trying to log in. */
+func forgetOldFailures(now time.Time) {
+	if now.Sub(loginFailures.swept) < time.Minute {
+		return
+	}
+	loginFailures.swept = now
+	for k, f := range loginFailures.m {
+		if now.Sub(f.LastFailure) > forgetFailures && (f.LockedUntil == nil || f.LockedUntil.Before(now)) {
+			delete(loginFailures.m, k)
+		}
+	}
+}
+
+type byLockedUntil []*LoginFailures
+
+func (f byLockedUntil) Len() int { return len(f) }
+func (f byLockedUntil) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byLockedUntil) Less(i, j int) bool {
+	a, b := f[i].LockedUntil, f[j].LockedUntil
+	switch {
+		case a != nil && b != nil && !a.Equal(*b):
+			return a.After(*b)
+		case a != nil && b == nil:
+			return true
+		case a == nil && b != nil:
+			return false
+	}
+	return f[i].LastFailure.After(f[j].LastFailure)
+}
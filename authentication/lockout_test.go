@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authentication
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoginLockout(t *testing.T) {
+	config.Get().LoginAttempts = 3
+	config.Get().LoginIPAttempts = 10
+	config.Get().LoginLockoutDur = time.Minute
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	for i := 0; i < 2; i++ {
+		if LoginFailed("bob", "10.0.0.1") {
+			t.Errorf("bob was locked out after %d failed logins", i + 1)
+		}
+	}
+	if _, locked := LoginLockedOut("bob", "10.0.0.1"); locked {
+		t.Errorf("bob was locked out before too many failed logins")
+	}
+	if !LoginFailed("bob", "10.0.0.1") {
+		t.Errorf("bob wasn't locked out after 3 failed logins")
+	}
+	if wait, locked := LoginLockedOut("bob", "10.0.0.2"); !locked || wait != time.Minute {
+		t.Errorf("bob should have been locked out for a minute from anywhere, got %v %s", locked, wait)
+	}
+	if _, locked := LoginLockedOut("alice", "10.0.0.2"); locked {
+		t.Errorf("alice was locked out because of bob's failed logins")
+	}
+
+	/* Each failure after the lockout doubles it. */
+	LoginFailed("bob", "10.0.0.1")
+	if wait, _ := LoginLockedOut("bob", "10.0.0.1"); wait != 2 * time.Minute {
+		t.Errorf("bob's lockout should have doubled to 2m, got %s", wait)
+	}
+	for i := 0; i < 10; i++ {
+		LoginFailed("bob", "10.0.0.1")
+	}
+	if wait, _ := LoginLockedOut("bob", "10.0.0.3"); wait != maxLockout {
+		t.Errorf("bob's lockout should have stopped at %s, got %s", maxLockout, wait)
+	}
+
+	/* The address has failed too many times now too, for any user. */
+	if _, locked := LoginLockedOut("alice", "10.0.0.1"); !locked {
+		t.Errorf("logins from 10.0.0.1 weren't locked out after too many failures")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, locked := LoginLockedOut("bob", "10.0.0.3"); locked {
+		t.Errorf("bob was still locked out after the lockout ended")
+	}
+	LoginSucceeded("bob")
+	if _, found := GetLoginFailure("user", "bob"); found {
+		t.Errorf("bob's failed logins weren't cleared by logging in")
+	}
+	if _, found := GetLoginFailure("ip", "10.0.0.1"); !found {
+		t.Errorf("10.0.0.1's failed logins were cleared by bob logging in")
+	}
+	if len(GetLoginFailures()) != 1 {
+		t.Errorf("expected failed logins for one address, got %v", GetLoginFailures())
+	}
+	if !ClearLoginFailures("ip", "10.0.0.1") || ClearLoginFailures("ip", "10.0.0.1") {
+		t.Errorf("clearing 10.0.0.1's failed logins didn't work as expected")
+	}
+}
+
+func TestLoginLockoutDisabled(t *testing.T) {
+	config.Get().LoginAttempts = -1
+	config.Get().LoginIPAttempts = -1
+	config.Get().LoginLockoutDur = time.Minute
+	for i := 0; i < 50; i++ {
+		if LoginFailed("carol", "10.0.1.1") {
+			t.Fatalf("carol was locked out with lockouts turned off")
+		}
+	}
+	if _, found := GetLoginFailure("user", "carol"); found {
+		t.Errorf("failed logins were tracked with lockouts turned off")
+	}
+}
+
+func TestLoginLockoutWebUI(t *testing.T) {
+	config.Get().LoginAttempts = 3
+	config.Get().LoginIPAttempts = 10
+	config.Get().LoginLockoutDur = time.Minute
+
+	r := httptest.NewRequest("POST", "/authenticate_user", nil)
+	r.Header.Set("X-Ops-UserId", "chef-webui")
+	if !FromWebUI(r) {
+		t.Errorf("a login signed by chef-webui wasn't from the web UI")
+	}
+	r.Header.Set("X-Ops-UserId", "dave")
+	if FromWebUI(r) {
+		t.Errorf("a login signed by dave was from the web UI")
+	}
+	r.Header.Set("X-Ops-Request-Source", "web")
+	if !FromWebUI(r) {
+		t.Errorf("a login for dave through the web UI wasn't from the web UI")
+	}
+
+	/* Web UI logins aren't counted by address, so lots of users failing
+	 * to log in there don't lock anyone else out. */
+	for i := 0; i < 30; i++ {
+		LoginFailed(fmt.Sprintf("webuser%d", i % 10), "")
+	}
+	if _, locked := LoginLockedOut("erin", ""); locked {
+		t.Errorf("erin was locked out of the web UI by other users' failed logins")
+	}
+	if _, locked := LoginLockedOut("webuser1", ""); !locked {
+		t.Errorf("webuser1 wasn't locked out after failing to log in through the web UI")
+	}
+	if _, found := GetLoginFailure("ip", ""); found {
+		t.Errorf("web UI logins were counted by address")
+	}
+
+	/* Users who don't exist aren't counted. */
+	n := len(GetLoginFailures())
+	for i := 0; i < 5; i++ {
+		LoginFailed("", "")
+	}
+	if len(GetLoginFailures()) != n {
+		t.Errorf("failed logins for unknown users were counted")
+	}
+	for i := 0; i < 10; i++ {
+		ClearLoginFailures("user", fmt.Sprintf("webuser%d", i))
+	}
+}
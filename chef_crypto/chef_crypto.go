@@ -28,9 +28,16 @@ import (
 	"encoding/base64"
 	"math/big"
 	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/hex"
+	"strings"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// The bcrypt cost for new password hashes. Hashes made with a lower cost are
+// upgraded the next time the user logs in.
+const PasswdCost = 12
+
 // Creates a pair of private and public keys for a client.
 func GenerateRSAKeys() (string, string, error){
 	/* Shamelessly borrowed and adapted from some golang-samples */
@@ -134,7 +141,9 @@ func decrypt(pubKey *rsa.PublicKey, data []byte) ([]byte, error) {
 	return out, nil
 }
 
-// SHA512 hash a password string with the provided salt.
+// SHA512 hash a password string with the provided salt. This is how passwords
+// were hashed before bcrypt was used. It's only still here to check passwords
+// against the old hashes until they've been upgraded.
 func HashPasswd(passwd string, salt []byte) (string, error) {
 	if passwd == "" {
 		err := fmt.Errorf("Password is empty")
@@ -145,6 +154,57 @@ func HashPasswd(passwd string, salt []byte) (string, error) {
 	return hashPw, nil
 }
 
+// Hash a password with bcrypt. The hash starts with an identifier for the
+// algorithm, like "$2a$", and includes the cost and its own salt, so it's all
+// that needs storing.
+func CryptPasswd(passwd string) (string, error) {
+	if passwd == "" {
+		err := fmt.Errorf("Password is empty")
+		return "", err
+	}
+	/* bcrypt only uses the first 72 bytes of the password. */
+	if len(passwd) > 72 {
+		err := fmt.Errorf("Password must have no more than 72 bytes")
+		return "", err
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(passwd), PasswdCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Check a password against a stored hash. The hash may be a bcrypt hash from
+// CryptPasswd, or an older SHA512 hash from HashPasswd with the given salt.
+func CheckPasswdHash(passwd string, hash string, salt []byte) bool {
+	if passwd == "" || hash == "" {
+		return false
+	}
+	if isBcrypt(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)) == nil
+	}
+	h, err := HashPasswd(passwd, salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1
+}
+
+// Is the stored password hash an old SHA512 hash, or a bcrypt hash with a lower
+// cost than new hashes get? If so, it should be replaced with a new hash the
+// next time the password's been checked.
+func PasswdHashOutdated(hash string) bool {
+	if !isBcrypt(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < PasswdCost
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2")
+}
+
 // Generate a new salt for hashing a password.
 func GenerateSalt() ([]byte, error) {
 	numbytes := 64
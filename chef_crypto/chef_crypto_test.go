@@ -116,3 +116,41 @@ func TestHashPasswd(t *testing.T){
 		t.Errorf("hashed password was not equal to the expected hash")
 	}
 }
+
+func TestCryptPasswd(t *testing.T){
+	passwd := "abc123"
+	hashedPw, err := CryptPasswd(passwd)
+	if err != nil {
+		t.Fatalf("Error with bcrypt hashed password! %s", err.Error())
+	}
+	if !strings.HasPrefix(hashedPw, "$2") {
+		t.Errorf("bcrypt hashed password %s didn't start with the algorithm identifier", hashedPw)
+	}
+	if !CheckPasswdHash(passwd, hashedPw, nil) {
+		t.Errorf("password did not match its bcrypt hash")
+	}
+	if CheckPasswdHash("abc124", hashedPw, nil) {
+		t.Errorf("wrong password matched the bcrypt hash")
+	}
+	if PasswdHashOutdated(hashedPw) {
+		t.Errorf("new bcrypt hash was considered outdated")
+	}
+	if _, err := CryptPasswd(strings.Repeat("a", 73)); err == nil {
+		t.Errorf("hashed a password too long for bcrypt")
+	}
+}
+
+func TestCheckLegacyPasswd(t *testing.T){
+	passwd := "abc123"
+	salt := []byte{ 1, 2, 4, 5, 3, 5, 2, 1, 10 }
+	legacy, _ := HashPasswd(passwd, salt)
+	if !CheckPasswdHash(passwd, legacy, salt) {
+		t.Errorf("password did not match its SHA512 hash")
+	}
+	if CheckPasswdHash(passwd, legacy, nil) {
+		t.Errorf("password matched its SHA512 hash without the salt")
+	}
+	if !PasswdHashOutdated(legacy) {
+		t.Errorf("SHA512 hash was not considered outdated")
+	}
+}
@@ -50,7 +50,7 @@ type Client struct {
 	ChefType string `json:"chef_type"`
 	Validator bool `json:"validator"`
 	Orgname string `json:"orgname"`
-	pubKey string `json:"public_key"`
+	pubKey string
 	Admin bool `json:"admin"`
 	Certificate string `json:"certificate"`
 }
@@ -87,7 +87,7 @@ type flatClient struct {
 func New(clientname string) (*Client, util.Gerror){
 	var found bool
 	var err util.Gerror
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var cerr error
 		found, cerr = checkForClientMySQL(data_store.Dbh, clientname)
 		if cerr != nil {
@@ -126,7 +126,7 @@ func Get(clientname string) (*Client, util.Gerror){
 	var client *Client
 	var err error
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		client, err = getClientMySQL(clientname)
 		if err != nil {
 			var gerr util.Gerror
@@ -157,7 +157,7 @@ func Get(clientname string) (*Client, util.Gerror){
 // Save the client. If a user with the same name as the client exists, returns
 // an error. Additionally, if running with MySQL it will return any DB error.
 func (c *Client) Save() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := c.saveMySQL()
 		if err != nil {
 			return err
@@ -183,7 +183,7 @@ func (c *Client) Delete() error {
 		return err
 	}
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := c.deleteMySQL()
 		if err != nil {
 			return err
@@ -213,7 +213,7 @@ func (c *Client) ToJson() map[string]interface{} {
 func (c *Client) isLastAdmin() bool {
 	if c.Admin {
 		numAdmins := 0
-		if config.Config.UseMySQL {
+		if config.Get().UseMySQL {
 			numAdmins = numAdminsMySQL()
 		} else {
 			clist := GetList()
@@ -243,7 +243,7 @@ func (c *Client) Rename(new_name string) util.Gerror {
 		return err
 	}
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := c.renameMySQL(new_name)
 		if err != nil {
 			return err
@@ -387,7 +387,7 @@ func ValidatePublicKey(publicKey interface{}) (bool, util.Gerror) {
 // Returns a list of clients.
 func GetList() []string {
 	var client_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		client_list = getListMySQL()
 	} else {
 		ds := data_store.New()
@@ -522,7 +522,7 @@ func (c *Client) CheckPermEdit(client_data map[string]interface{}, perm string)
 
 /* a check to see if we should do perm checks */
 func useAuth() bool {
-	return config.Config.UseAuth
+	return config.Get().UseAuth
 }
 
 func (c *Client) export() *privClient {
@@ -83,7 +83,7 @@ func SplitPath(path string) (split_path []string){
 }
 
 func JsonErrorReport(w http.ResponseWriter, r *http.Request, error_str string, status int){
-	logger.Infof(error_str)
+	logger.Infof("%s%s", logPrefix(r), error_str)
 	json_error := map[string][]string{ "error": []string{ error_str } }
 	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
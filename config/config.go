@@ -32,6 +32,11 @@ import (
 	"strings"
 	"net"
 	"strconv"
+	"math"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"crypto/tls"
 )
 
 /* Master struct for configuration. */
@@ -54,11 +59,32 @@ type Conf struct {
 	UseSSL bool `toml:"use-ssl"`
 	SslCert string `toml:"ssl-cert"`
 	SslKey string `toml:"ssl-key"`
+	SslMinVersion string `toml:"ssl-min-version"`
+	SslMinVersionId uint16
+	SslCiphers []string `toml:"ssl-ciphers"`
+	SslCipherIds []uint16
+	SslDisableHTTP2 bool `toml:"ssl-disable-http2"`
+	SslClientCA string `toml:"ssl-client-ca"`
+	SslClientCerts string `toml:"ssl-client-certs"`
+	SslClientCertAuth string `toml:"ssl-client-cert-auth"`
 	HttpsUrls bool `toml:"https-urls"`
 	DisableWebUI bool `toml:"disable-webui"`
 	UseMySQL bool `toml:"use-mysql"`
 	MySQL MySQLdb `toml:"mysql"`
+	UseLDAP bool `toml:"use-ldap"`
+	LDAP LDAPConf `toml:"ldap"`
 	LocalFstoreDir string `toml:"local-filestore-dir"`
+	ReportRetention int `toml:"report-retention"`
+	LoginAttempts int `toml:"login-attempts"`
+	LoginIPAttempts int `toml:"login-ip-attempts"`
+	LoginLockout string `toml:"login-lockout"`
+	LoginLockoutDur time.Duration
+	RateLimit RateLimitConf `toml:"rate-limit"`
+	MetricsAddr string `toml:"metrics-addr"`
+	ShutdownTimeout string `toml:"shutdown-timeout"`
+	ShutdownTimeoutDur time.Duration
+	AccessLog string `toml:"access-log"`
+	AccessLogFormat string `toml:"access-log-format"`
 }
 var LogLevelNames = map[string]int{ "debug": 4, "info": 3, "warning": 2, "error": 1, "critical": 0 }
 
@@ -73,6 +99,54 @@ type MySQLdb struct {
 	ExtraParams map[string]string `toml:"extra_params"`
 }
 
+// LDAP options, for checking users' passwords against an LDAP directory.
+type LDAPConf struct {
+	Host string
+	Port int
+	// Connect with ldaps.
+	UseTLS bool `toml:"use-tls"`
+	// Connect with plain ldap, then use StartTLS.
+	StartTLS bool `toml:"start-tls"`
+	// A CA certificate file to verify the server's certificate with.
+	CACert string `toml:"ca-cert"`
+	InsecureSkipVerify bool `toml:"insecure-skip-verify"`
+	// The account used to search for users and groups. Searches are
+	// anonymous without it.
+	BindDN string `toml:"bind-dn"`
+	BindPassword string `toml:"bind-password"`
+	BaseDN string `toml:"base-dn"`
+	// The filter to find a user by name, with %s for the name.
+	UserFilter string `toml:"user-filter"`
+	EmailAttr string `toml:"email-attribute"`
+	NameAttr string `toml:"name-attribute"`
+	// Where to search for the groups a user is in, and the filter to find
+	// them by, with %s for the user's DN.
+	GroupBaseDN string `toml:"group-base-dn"`
+	GroupFilter string `toml:"group-filter"`
+	// The DNs of groups whose members are admins.
+	AdminGroups []string `toml:"admin-groups"`
+	// Users who log in with their goiardi password instead of LDAP, like
+	// the default admin. Everyone else has to log in through LDAP.
+	LocalUsers []string `toml:"local-users"`
+}
+
+// Rate limit options. Each actor gets a token bucket holding up to the burst
+// number of tokens, refilled at the rate per second, and each request takes
+// its cost in tokens out. A rate of 0 means no limit.
+type RateLimitConf struct {
+	// The limits for clients and non-admin users.
+	Rate float64 `toml:"rate"`
+	Burst int `toml:"burst"`
+	AdminRate float64 `toml:"admin-rate"`
+	AdminBurst int `toml:"admin-burst"`
+	ValidatorRate float64 `toml:"validator-rate"`
+	ValidatorBurst int `toml:"validator-burst"`
+	// How many tokens requests cost, keyed by the first part of the path
+	// ("/search"), by method ("GET"), or by both ("PUT /nodes"). Requests
+	// cost 1 token if nothing matches.
+	Costs map[string]int `toml:"costs"`
+}
+
 /* Struct for command line options. */
 type Options struct {
 	Version bool `short:"v" long:"version" description:"Print version info."`
@@ -91,10 +165,28 @@ type Options struct {
 	UseSSL bool `long:"use-ssl" description:"Use SSL for connections. If --port is set to 433, this will automatically be turned on. If it is set to 80, it will automatically be turned off. Default: off. Requires --ssl-cert and --ssl-key."`
 	SslCert string `long:"ssl-cert" description:"SSL certificate file. If a relative path, will be set relative to --conf-root."`
 	SslKey string `long:"ssl-key" description:"SSL key file. If a relative path, will be set relative to --conf-root."`
+	SslMinVersion string `long:"ssl-min-version" description:"Oldest TLS version to accept, one of 1.0, 1.1, 1.2, or 1.3. Default: 1.2."`
+	SslCiphers []string `long:"ssl-cipher" description:"A cipher suite to allow with TLS 1.2 and older, like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. May be given more than once. Default: Go's secure cipher suites."`
+	SslDisableHTTP2 bool `long:"ssl-disable-http2" description:"Don't use HTTP/2 for SSL connections."`
+	SslClientCA string `long:"ssl-client-ca" description:"CA certificate file to verify client certificates with. If set, clients have to present a certificate it signed, unless --ssl-client-certs is optional. If a relative path, will be set relative to --conf-root."`
+	SslClientCerts string `long:"ssl-client-certs" description:"Whether clients have to present a certificate when --ssl-client-ca is set, either require or optional. Default: require."`
+	SslClientCertAuth string `long:"ssl-client-cert-auth" description:"Use the common name of client certificates to authenticate requests as the client or user of that name, either as an alternative to signing the request headers, or required as well as signing them. Requires --ssl-client-ca."`
 	HttpsUrls bool `long:"https-urls" description:"Use 'https://' in URLs to server resources if goiardi is not using SSL for its connections. Useful when goiardi is sitting behind a reverse proxy that uses SSL, but is communicating with the proxy over HTTP."`
 	DisableWebUI bool `long:"disable-webui" description:"If enabled, disables connections and logins to goiardi over the webui interface."`
 	UseMySQL bool `long:"use-mysql" description:"Use a MySQL database for data storage. Configure database options in the config file."`
+	UseLDAP bool `long:"use-ldap" description:"Check users' passwords against an LDAP directory. Configure LDAP options in the config file."`
 	LocalFstoreDir string `long:"local-filestore-dir" description:"Directory to save uploaded files in. Optional when running in in-memory mode, *mandatory* for SQL mode."`
+	ReportRetention int `long:"report-retention" description:"Number of days to keep chef-client run reports. Set to -1 to keep them forever. Default: 90."`
+	LoginAttempts int `long:"login-attempts" description:"Number of failed logins in a row allowed for a user before their logins are refused for a while. Set to -1 to never refuse them. Default: 5."`
+	LoginIPAttempts int `long:"login-ip-attempts" description:"Number of failed logins in a row allowed from one address before logins from it are refused for a while. Set to -1 to never refuse them. Default: 20."`
+	LoginLockout string `long:"login-lockout" description:"How long logins are refused after too many failures, doubling with each further failure up to an hour. Formatted like 5m, 150s, etc. Default: 1m."`
+	RateLimit float64 `long:"rate-limit" description:"Requests per second allowed for each client and non-admin user. Default: no limit."`
+	AdminRateLimit float64 `long:"admin-rate-limit" description:"Requests per second allowed for each admin. Default: no limit."`
+	ValidatorRateLimit float64 `long:"validator-rate-limit" description:"Requests per second allowed for the validator client. Default: no limit."`
+	MetricsAddr string `long:"metrics-addr" description:"Also serve Prometheus metrics at /metrics on this address, like 127.0.0.1:9100, without authentication."`
+	ShutdownTimeout string `long:"shutdown-timeout" description:"How long to wait for requests to finish when shutting down. Formatted like 5m, 150s, etc. Default: 30s."`
+	AccessLog string `long:"access-log" description:"Log each request to file X. Use - for stdout."`
+	AccessLogFormat string `long:"access-log-format" description:"Format of the access log, either combined (like Apache's) or json, for one JSON object per line. Default: combined."`
 }
 
 // The goiardi version.
@@ -110,243 +202,536 @@ const ChefVersion = "11.0.11"
 
 func InitConfig() *Conf { return &Conf{ } }
 
-// Conf struct with the options specified on the command line or in the config
-// file.
-var Config = InitConfig()
+/* The current configuration. Reloading replaces it all at once, so anything
+ * that reads more than one setting should call Get once and use what it
+ * returns, so it sees all of either the old or the new config. */
+var current atomic.Pointer[Conf]
+
+func init() {
+	current.Store(InitConfig())
+}
+
+// Get the Conf struct with the options specified on the command line or in
+// the config file.
+func Get() *Conf {
+	return current.Load()
+}
 
-// Read and apply arguments from the command line.
+/* Returned by parseConfig when goiardi should exit without an error. */
+var errExit = errors.New("exit")
+
+// Read and apply arguments from the command line and the config file. Exits if
+// they're bad, or goiardi was only asked for its help or version.
 func ParseConfigOptions() error {
+	conf, err := parseConfig()
+	if err != nil {
+		if err == errExit {
+			os.Exit(0)
+		}
+		log.Println(err)
+		os.Exit(1)
+	}
+	if err = setupLogging(conf); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	current.Store(conf)
+	return nil
+}
+
+/* The settings that can't be changed without restarting goiardi, by their
+ * config file names and their fields in Conf. */
+var restartSettings = []struct{
+	name string
+	field string
+}{
+	{ "ipaddress", "Ipaddress" },
+	{ "port", "Port" },
+	{ "index-file", "IndexFile" },
+	{ "data-file", "DataStoreFile" },
+	{ "freeze-interval", "FreezeInterval" },
+	{ "use-mysql", "UseMySQL" },
+	{ "mysql", "MySQL" },
+	{ "local-filestore-dir", "LocalFstoreDir" },
+	{ "report-retention", "ReportRetention" },
+	{ "use-auth", "UseAuth" },
+	{ "use-ssl", "UseSSL" },
+	{ "metrics-addr", "MetricsAddr" },
+}
+
+// Read the command line options and config file again, and replace the
+// running config with them. Settings that can only change when goiardi
+// restarts keep their old values, and their names are returned. If the new
+// options are bad, or check returns an error for them, the running config is
+// left alone and the error returned. check may be nil.
+func Reload(check func(*Conf) error) ([]string, error) {
+	conf, err := parseConfig()
+	if err != nil {
+		if err == errExit {
+			err = fmt.Errorf("goiardi was started with --help or --version")
+		}
+		return nil, err
+	}
+	old := Get()
+	restart := make([]string, 0)
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(conf).Elem()
+	for _, rs := range restartSettings {
+		of, nf := ov.FieldByName(rs.field), nv.FieldByName(rs.field)
+		if !reflect.DeepEqual(of.Interface(), nf.Interface()) {
+			restart = append(restart, rs.name)
+			nf.Set(of)
+		}
+	}
+	/* Freezing data goes along with the index and data files. */
+	conf.FreezeData = old.FreezeData
+	if check != nil {
+		if err = check(conf); err != nil {
+			return nil, err
+		}
+	}
+	if err = setupLogging(conf); err != nil {
+		return nil, err
+	}
+
+	current.Store(conf)
+	return restart, nil
+}
+
+var sslVersions = map[string]uint16{ "1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13 }
+
+/* The TLS version, cipher suite, and client certificate options. */
+func parseSslOptions(conf *Conf, opts *Options) error {
+	if opts.SslMinVersion != "" {
+		conf.SslMinVersion = opts.SslMinVersion
+	}
+	if conf.SslMinVersion == "" {
+		conf.SslMinVersion = "1.2"
+	}
+	v, ok := sslVersions[conf.SslMinVersion]
+	if !ok {
+		return fmt.Errorf("ssl-min-version must be 1.0, 1.1, 1.2, or 1.3, not %s", conf.SslMinVersion)
+	}
+	conf.SslMinVersionId = v
+
+	if len(opts.SslCiphers) > 0 {
+		conf.SslCiphers = opts.SslCiphers
+	}
+	/* Only the cipher suites Go considers secure can be picked. */
+	suites := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	conf.SslCipherIds = nil
+	for _, c := range conf.SslCiphers {
+		id, ok := suites[c]
+		if !ok {
+			return fmt.Errorf("%s is not a known secure cipher suite", c)
+		}
+		conf.SslCipherIds = append(conf.SslCipherIds, id)
+	}
+
+	if opts.SslDisableHTTP2 {
+		conf.SslDisableHTTP2 = opts.SslDisableHTTP2
+	}
+
+	if opts.SslClientCA != "" {
+		conf.SslClientCA = opts.SslClientCA
+	}
+	if conf.SslClientCA != "" && !path.IsAbs(conf.SslClientCA) {
+		conf.SslClientCA = path.Join(conf.ConfRoot, conf.SslClientCA)
+	}
+	if opts.SslClientCerts != "" {
+		conf.SslClientCerts = opts.SslClientCerts
+	}
+	switch conf.SslClientCerts {
+		case "":
+			conf.SslClientCerts = "require"
+		case "require", "optional":
+			;
+		default:
+			return fmt.Errorf("ssl-client-certs must be require or optional, not %s", conf.SslClientCerts)
+	}
+	if opts.SslClientCertAuth != "" {
+		conf.SslClientCertAuth = opts.SslClientCertAuth
+	}
+	switch conf.SslClientCertAuth {
+		case "":
+			;
+		case "alternative", "required":
+			if !conf.UseSSL || conf.SslClientCA == "" {
+				return fmt.Errorf("ssl-client-cert-auth requires use-ssl and ssl-client-ca to be set.")
+			}
+		default:
+			return fmt.Errorf("ssl-client-cert-auth must be alternative or required, not %s", conf.SslClientCertAuth)
+	}
+	return nil
+}
+
+/* The log file goiardi's writing to, if it's not logging to stderr. */
+var logFile *os.File
+
+/* Set the log level, and open the log file. The log file is opened again
+ * even if it hasn't changed, so it can be rotated. */
+func setupLogging(conf *Conf) error {
+	if conf.LogFile != "" {
+		lfp, lerr := os.OpenFile(conf.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if lerr != nil {
+			return lerr
+		}
+		log.SetOutput(lfp)
+		if logFile != nil {
+			logFile.Close()
+		}
+		logFile = lfp
+	} else if logFile != nil {
+		log.SetOutput(os.Stderr)
+		logFile.Close()
+		logFile = nil
+	}
+	logger.SetLevel(logger.LogLevel(conf.DebugLevel))
+	debug_level := map[int]string { 0: "debug", 1: "info", 2: "warning", 3: "error", 4: "critical" }
+	log.Printf("Logging at %s level", debug_level[conf.DebugLevel])
+	logger.SetLogger(logger.NewGoLogger())
+	return nil
+}
+
+/* Read the command line options and the config file into a new Conf, checking
+ * that they make sense. Returns errExit if goiardi should just exit, because
+ * it was asked for its help or version. */
+func parseConfig() (*Conf, error) {
+	conf := InitConfig()
 	var opts = &Options{ }
 	_, err := flags.Parse(opts)
 
 	if err != nil {
-		if err.(*flags.Error).Type == flags.ErrHelp {
-			os.Exit(0)
-		} else {
-			log.Println(err)
-			os.Exit(1)
+		if ferr, ok := err.(*flags.Error); ok && ferr.Type == flags.ErrHelp {
+			return nil, errExit
 		}
+		return nil, err
 	}
 
 	if opts.Version {
 		fmt.Printf("goiardi version %s (aiming for compatibility with Chef Server version %s).\n", Version, ChefVersion)
-		os.Exit(0)
+		return nil, errExit
 	}
 
 	/* Load the config file. Command-line options have precedence over
 	 * config file options. */
 	if opts.ConfFile != "" {
-		if _, err := toml.DecodeFile(opts.ConfFile, Config); err != nil {
-			panic(err)
-			os.Exit(1)
+		if _, err := toml.DecodeFile(opts.ConfFile, conf); err != nil {
+			return nil, err
 		}
-		Config.ConfFile = opts.ConfFile
-		Config.FreezeData = false
+		conf.ConfFile = opts.ConfFile
+		conf.FreezeData = false
 	}
 	
 	if opts.Hostname != "" {
-		Config.Hostname = opts.Hostname
+		conf.Hostname = opts.Hostname
 	} else {
-		if Config.Hostname == "" {
-			Config.Hostname, err = os.Hostname()
+		if conf.Hostname == "" {
+			conf.Hostname, err = os.Hostname()
 			if err != nil {
 				log.Println(err)
-				Config.Hostname = "localhost"
+				conf.Hostname = "localhost"
 			}
 		}
 	}
 
 	if opts.DataStoreFile != "" {
-		Config.DataStoreFile = opts.DataStoreFile
+		conf.DataStoreFile = opts.DataStoreFile
 	}
 
 	if opts.IndexFile != "" {
-		Config.IndexFile = opts.IndexFile
+		conf.IndexFile = opts.IndexFile
 	}
 
 	// Use MySQL?
 	if opts.UseMySQL {
-		Config.UseMySQL = opts.UseMySQL
+		conf.UseMySQL = opts.UseMySQL
 	}
 
-	if Config.DataStoreFile != "" && Config.UseMySQL {
-		err := fmt.Errorf("The MySQL and data store options may not be specified together.")
-		log.Println(err)
-		os.Exit(1)
+	if conf.DataStoreFile != "" && conf.UseMySQL {
+		return nil, fmt.Errorf("The MySQL and data store options may not be specified together.")
 	}
 
-	if !((Config.DataStoreFile == "" && Config.IndexFile == "") || ((Config.DataStoreFile != "" || Config.UseMySQL) && Config.IndexFile != "")) {
-		err := fmt.Errorf("-i and -D must either both be specified, or not specified.")
-		log.Println(err)
-		os.Exit(1)
+	if !((conf.DataStoreFile == "" && conf.IndexFile == "") || ((conf.DataStoreFile != "" || conf.UseMySQL) && conf.IndexFile != "")) {
+		return nil, fmt.Errorf("-i and -D must either both be specified, or not specified.")
 	}
 
-	if Config.UseMySQL && Config.IndexFile == "" {
-		err := fmt.Errorf("An index file must be specified with -i or --index-file (or the 'index-file' config file option) when running with a MySQL backend.")
-		log.Println(err)
-		os.Exit(1)
+	if conf.UseMySQL && conf.IndexFile == "" {
+		return nil, fmt.Errorf("An index file must be specified with -i or --index-file (or the 'index-file' config file option) when running with a MySQL backend.")
 	}
 
-	if Config.IndexFile != "" && (Config.DataStoreFile != "" || Config.UseMySQL) {
-		Config.FreezeData = true
+	if conf.IndexFile != "" && (conf.DataStoreFile != "" || conf.UseMySQL) {
+		conf.FreezeData = true
 	}
 
 	if opts.LogFile != "" {
-		Config.LogFile = opts.LogFile
-	}
-	if Config.LogFile != "" {
-		lfp, lerr := os.Create(Config.LogFile)
-		if lerr != nil {
-			log.Println(err)
-			os.Exit(1)
-		}
-		log.SetOutput(lfp)
+		conf.LogFile = opts.LogFile
 	}
 	if dlev := len(opts.Verbose); dlev != 0 {
-		Config.DebugLevel = dlev
+		conf.DebugLevel = dlev
 	}
-	if Config.LogLevel != "" {
-		if lev, ok := LogLevelNames[strings.ToLower(Config.LogLevel)]; ok && Config.DebugLevel == 0 {
-			Config.DebugLevel = lev
+	if conf.LogLevel != "" {
+		if lev, ok := LogLevelNames[strings.ToLower(conf.LogLevel)]; ok && conf.DebugLevel == 0 {
+			conf.DebugLevel = lev
 		} 
 	}
-	if Config.DebugLevel > 4 {
-		Config.DebugLevel = 4
+	if conf.DebugLevel > 4 {
+		conf.DebugLevel = 4
 	}
 
-	Config.DebugLevel = int(logger.LevelCritical) - Config.DebugLevel 
-	logger.SetLevel(logger.LogLevel(Config.DebugLevel))
-	debug_level := map[int]string { 0: "debug", 1: "info", 2: "warning", 3: "error", 4: "critical" }
-	log.Printf("Logging at %s level", debug_level[Config.DebugLevel])
-	logger.SetLogger(logger.NewGoLogger())
+	conf.DebugLevel = int(logger.LevelCritical) - conf.DebugLevel 
 
 	/* Database options */
 	
 	// Don't bother setting a default mysql port if mysql isn't used
-	if Config.UseMySQL {
-		if Config.MySQL.Port == "" {
-			Config.MySQL.Port = "3306"
+	if conf.UseMySQL {
+		if conf.MySQL.Port == "" {
+			conf.MySQL.Port = "3306"
+		}
+	}
+
+	if opts.UseLDAP {
+		conf.UseLDAP = opts.UseLDAP
+	}
+	if conf.UseLDAP {
+		if conf.LDAP.Host == "" || conf.LDAP.BaseDN == "" {
+			return nil, fmt.Errorf("LDAP mode requires setting the host and base-dn LDAP options.")
+		}
+		if conf.LDAP.Port == 0 {
+			if conf.LDAP.UseTLS {
+				conf.LDAP.Port = 636
+			} else {
+				conf.LDAP.Port = 389
+			}
+		}
+		if conf.LDAP.UserFilter == "" {
+			conf.LDAP.UserFilter = "(uid=%s)"
+		}
+		if conf.LDAP.EmailAttr == "" {
+			conf.LDAP.EmailAttr = "mail"
+		}
+		if conf.LDAP.NameAttr == "" {
+			conf.LDAP.NameAttr = "cn"
+		}
+		if conf.LDAP.GroupBaseDN == "" {
+			conf.LDAP.GroupBaseDN = conf.LDAP.BaseDN
+		}
+		if conf.LDAP.GroupFilter == "" {
+			conf.LDAP.GroupFilter = "(|(member=%s)(uniqueMember=%s))"
+		}
+		if conf.LDAP.LocalUsers == nil {
+			conf.LDAP.LocalUsers = []string{ "admin" }
 		}
 	}
 
 	if opts.LocalFstoreDir != "" {
-		Config.LocalFstoreDir = opts.LocalFstoreDir
+		conf.LocalFstoreDir = opts.LocalFstoreDir
 	}
-	if Config.LocalFstoreDir == "" && Config.UseMySQL {
-		logger.Criticalf("local-filestore-dir must be set when running goiardi in SQL mode")
-		os.Exit(1)
+	if conf.LocalFstoreDir == "" && conf.UseMySQL {
+		return nil, fmt.Errorf("local-filestore-dir must be set when running goiardi in SQL mode")
 	}
 
-	if !Config.FreezeData && (opts.FreezeInterval != 0 || Config.FreezeInterval != 0) {
+	if !conf.FreezeData && (opts.FreezeInterval != 0 || conf.FreezeInterval != 0) {
 		logger.Warningf("FYI, setting the freeze data interval's not especially useful without setting the index and data files.")
 	}
 	if opts.FreezeInterval != 0 {
-		Config.FreezeInterval = opts.FreezeInterval
+		conf.FreezeInterval = opts.FreezeInterval
+	}
+	if conf.FreezeInterval == 0 {
+		conf.FreezeInterval = 300
+	}
+
+	if opts.ReportRetention != 0 {
+		conf.ReportRetention = opts.ReportRetention
 	}
-	if Config.FreezeInterval == 0 {
-		Config.FreezeInterval = 300
+	if conf.ReportRetention == 0 {
+		conf.ReportRetention = 90
+	}
+
+	if opts.LoginAttempts != 0 {
+		conf.LoginAttempts = opts.LoginAttempts
+	}
+	if conf.LoginAttempts == 0 {
+		conf.LoginAttempts = 5
+	}
+	if opts.LoginIPAttempts != 0 {
+		conf.LoginIPAttempts = opts.LoginIPAttempts
+	}
+	if conf.LoginIPAttempts == 0 {
+		conf.LoginIPAttempts = 20
+	}
+	if opts.LoginLockout != "" {
+		conf.LoginLockout = opts.LoginLockout
+	}
+	if conf.LoginLockout != "" {
+		d, derr := time.ParseDuration(conf.LoginLockout)
+		if derr != nil || d <= 0 {
+			return nil, fmt.Errorf("Error parsing login-lockout: %s", conf.LoginLockout)
+		}
+		conf.LoginLockoutDur = d
+	} else {
+		conf.LoginLockoutDur = time.Minute
+	}
+
+	if opts.RateLimit != 0 {
+		conf.RateLimit.Rate = opts.RateLimit
+	}
+	if opts.AdminRateLimit != 0 {
+		conf.RateLimit.AdminRate = opts.AdminRateLimit
+	}
+	if opts.ValidatorRateLimit != 0 {
+		conf.RateLimit.ValidatorRate = opts.ValidatorRateLimit
+	}
+	/* Unless they're set, let a burst of ten seconds' worth of requests
+	 * through. */
+	conf.RateLimit.Burst = defaultBurst(conf.RateLimit.Rate, conf.RateLimit.Burst)
+	conf.RateLimit.AdminBurst = defaultBurst(conf.RateLimit.AdminRate, conf.RateLimit.AdminBurst)
+	conf.RateLimit.ValidatorBurst = defaultBurst(conf.RateLimit.ValidatorRate, conf.RateLimit.ValidatorBurst)
+	if conf.RateLimit.Costs == nil {
+		conf.RateLimit.Costs = map[string]int{ "/search": 5 }
+	}
+
+	if opts.MetricsAddr != "" {
+		conf.MetricsAddr = opts.MetricsAddr
+	}
+
+	if opts.ShutdownTimeout != "" {
+		conf.ShutdownTimeout = opts.ShutdownTimeout
+	}
+	if conf.ShutdownTimeout != "" {
+		d, derr := time.ParseDuration(conf.ShutdownTimeout)
+		if derr != nil {
+			return nil, fmt.Errorf("Error parsing shutdown-timeout: %s", conf.ShutdownTimeout)
+		}
+		conf.ShutdownTimeoutDur = d
+	} else {
+		conf.ShutdownTimeoutDur = 30 * time.Second
+	}
+
+	if opts.AccessLog != "" {
+		conf.AccessLog = opts.AccessLog
+	}
+	if opts.AccessLogFormat != "" {
+		conf.AccessLogFormat = opts.AccessLogFormat
+	}
+	switch conf.AccessLogFormat {
+		case "":
+			conf.AccessLogFormat = "combined"
+		case "combined", "json":
+			;
+		default:
+			return nil, fmt.Errorf("access-log-format must be combined or json, not %s", conf.AccessLogFormat)
 	}
 
 	/* Root directory for certs and the like */
 	if opts.ConfRoot != "" {
-		Config.ConfRoot = opts.ConfRoot
+		conf.ConfRoot = opts.ConfRoot
 	} 
 
-	if Config.ConfRoot == "" {
-		if Config.ConfFile != "" {
-			Config.ConfRoot = path.Dir(Config.ConfFile)
+	if conf.ConfRoot == "" {
+		if conf.ConfFile != "" {
+			conf.ConfRoot = path.Dir(conf.ConfFile)
 		} else {
-			Config.ConfRoot = "."
+			conf.ConfRoot = "."
 		}
 	}
 
-	Config.Ipaddress = opts.Ipaddress
+	conf.Ipaddress = opts.Ipaddress
 	if opts.Port != 0 {
-		Config.Port = opts.Port
+		conf.Port = opts.Port
 	}
-	if Config.Port == 0 {
-		Config.Port = 4545
+	if conf.Port == 0 {
+		conf.Port = 4545
 	}
 
 	if opts.UseSSL {
-		Config.UseSSL = opts.UseSSL
+		conf.UseSSL = opts.UseSSL
 	}
 	if opts.SslCert != "" {
-		Config.SslCert = opts.SslCert
+		conf.SslCert = opts.SslCert
 	}
 	if opts.SslKey != "" {
-		Config.SslKey = opts.SslKey
+		conf.SslKey = opts.SslKey
 	}
 	if opts.HttpsUrls {
-		Config.HttpsUrls = opts.HttpsUrls
+		conf.HttpsUrls = opts.HttpsUrls
 	}
 	// SSL setup
-	if Config.Port == 80 {
-		Config.UseSSL = false
-	} else if Config.Port == 443 {
-		Config.UseSSL = true
-	}
-	if Config.UseSSL {
-		if Config.SslCert == "" || Config.SslKey == "" {
-			logger.Criticalf("SSL mode requires specifying both a certificate and a key file.")
-			os.Exit(1)
+	if conf.Port == 80 {
+		conf.UseSSL = false
+	} else if conf.Port == 443 {
+		conf.UseSSL = true
+	}
+	if conf.UseSSL {
+		if conf.SslCert == "" || conf.SslKey == "" {
+			return nil, fmt.Errorf("SSL mode requires specifying both a certificate and a key file.")
 		}
 		/* If the SSL cert and key are not absolute files, join them
 		 * with the conf root */
-		if !path.IsAbs(Config.SslCert) {
-			Config.SslCert = path.Join(Config.ConfRoot, Config.SslCert)
+		if !path.IsAbs(conf.SslCert) {
+			conf.SslCert = path.Join(conf.ConfRoot, conf.SslCert)
 		}
-		if !path.IsAbs(Config.SslKey) {
-			Config.SslKey = path.Join(Config.ConfRoot, Config.SslKey)
+		if !path.IsAbs(conf.SslKey) {
+			conf.SslKey = path.Join(conf.ConfRoot, conf.SslKey)
 		}
 	}
+	if err := parseSslOptions(conf, opts); err != nil {
+		return nil, err
+	}
+	if conf.LDAP.CACert != "" && !path.IsAbs(conf.LDAP.CACert) {
+		conf.LDAP.CACert = path.Join(conf.ConfRoot, conf.LDAP.CACert)
+	}
 
 
 
 	if opts.TimeSlew != "" {
-		Config.TimeSlew = opts.TimeSlew
+		conf.TimeSlew = opts.TimeSlew
 	}
-	if Config.TimeSlew != "" {
-		d, derr := time.ParseDuration(Config.TimeSlew)
+	if conf.TimeSlew != "" {
+		d, derr := time.ParseDuration(conf.TimeSlew)
 		if derr != nil {
-			logger.Criticalf("Error parsing time-slew: %s", derr.Error())
-			os.Exit(1)
+			return nil, fmt.Errorf("Error parsing time-slew: %s", derr.Error())
 		}
-		Config.TimeSlewDur = d
+		conf.TimeSlewDur = d
 	} else {
-		Config.TimeSlewDur, _ = time.ParseDuration("15m")
+		conf.TimeSlewDur, _ = time.ParseDuration("15m")
 	}
 
 	if opts.UseAuth {
-		Config.UseAuth = opts.UseAuth
+		conf.UseAuth = opts.UseAuth
 	} 
 
 	if opts.DisableWebUI {
-		Config.DisableWebUI = opts.DisableWebUI
+		conf.DisableWebUI = opts.DisableWebUI
 	}
 
-	return nil
+	return conf, nil
 }
 
 // The address and port goiardi is configured to listen on.
 func ListenAddr() string {
-	listen_addr := net.JoinHostPort(Config.Ipaddress, strconv.Itoa(Config.Port))
+	conf := Get()
+	listen_addr := net.JoinHostPort(conf.Ipaddress, strconv.Itoa(conf.Port))
 	return listen_addr
 }
 
 // The hostname and port goiardi is configured to use.
 func ServerHostname() string {
-	if !(Config.Port == 80 || Config.Port == 443) {
-		return net.JoinHostPort(Config.Hostname, strconv.Itoa(Config.Port))
+	conf := Get()
+	if !(conf.Port == 80 || conf.Port == 443) {
+		return net.JoinHostPort(conf.Hostname, strconv.Itoa(conf.Port))
 	} else {
-		return Config.Hostname
+		return conf.Hostname
 	}
 }
 
 // The base URL
 func ServerBaseURL() string {
 	var urlScheme string
-	if Config.UseSSL || Config.HttpsUrls {
+	if conf := Get(); conf.UseSSL || conf.HttpsUrls {
 		urlScheme = "https"
 	} else {
 		urlScheme = "http"
@@ -354,3 +739,10 @@ func ServerBaseURL() string {
 	url := fmt.Sprintf("%s://%s", urlScheme, ServerHostname())
 	return url
 }
+
+func defaultBurst(rate float64, burst int) int {
+	if burst > 0 || rate <= 0 {
+		return burst
+	}
+	return int(math.Ceil(rate * 10))
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/ctdk/goiardi/config"
 	"github.com/ctdk/goiardi/data_store"
 	"github.com/ctdk/goiardi/filestore"
+	"github.com/ctdk/goiardi/indexer"
 	"github.com/ctdk/goiardi/util"
 	"fmt"
 	"strings"
@@ -73,6 +74,17 @@ type CookbookVersion struct {
 	cookbook_id int32
 }
 
+/* The parts of a cookbook version that are indexed for search. */
+type flatCookbookVersion struct {
+	CookbookName string `json:"cookbook_name"`
+	Name string `json:"name"`
+	Version string `json:"version"`
+	ChefType string `json:"chef_type"`
+	JsonClass string `json:"json_class"`
+	IsFrozen bool `json:"frozen"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
 /* Cookbook methods and functions */
 
 func (c *Cookbook) GetName() string {
@@ -90,7 +102,7 @@ func New(name string) (*Cookbook, util.Gerror){
 		err := util.Errorf("Invalid cookbook name '%s' using regex: 'Malformed cookbook name. Must only contain A-Z, a-z, 0-9, _ or -'.", name)
 		return nil, err
 	}
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var cerr error
 		found, cerr = checkForCookbookMySQL(data_store.Dbh, name)
 		if cerr != nil {
@@ -115,7 +127,7 @@ func New(name string) (*Cookbook, util.Gerror){
 
 // The number of versions this cookbook has.
 func (c *Cookbook)NumVersions() int {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if c.numVersions == nil {
 			c.numVersions = c.numVersionsMySQL()
 		}
@@ -127,7 +139,7 @@ func (c *Cookbook)NumVersions() int {
 
 // Return all the cookbooks that have been uploaded to this server.
 func AllCookbooks() (cookbooks []*Cookbook) {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		cookbooks = allCookbooksMySQL()
 	} else {
 		cookbook_list := GetList()
@@ -147,7 +159,7 @@ func AllCookbooks() (cookbooks []*Cookbook) {
 func Get(name string) (*Cookbook, util.Gerror){
 	var cookbook *Cookbook
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		cookbook, err = getCookbookMySQL(name)
 		if err != nil {
@@ -179,7 +191,7 @@ func Get(name string) (*Cookbook, util.Gerror){
 
 // Save a cookbook to the in-memory data store or database.
 func (c *Cookbook) Save() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return c.saveCookbookMySQL()
 	} else {
 		ds := data_store.New()
@@ -189,18 +201,26 @@ func (c *Cookbook) Save() error {
 }
 
 func (c *Cookbook) Delete() error {
-	if config.Config.UseMySQL {
-		return c.deleteCookbookMySQL()
+	/* With MySQL c.Versions isn't loaded, so get the versions to remove
+	 * from the index before they're deleted from the database. */
+	versions := c.sortedVersions()
+	if config.Get().UseMySQL {
+		if err := c.deleteCookbookMySQL(); err != nil {
+			return err
+		}
 	} else {
 		ds := data_store.New()
 		ds.Delete("cookbook", c.Name)
 	}
+	for _, cbv := range versions {
+		indexer.DeleteItemFromCollection("cookbook", cbv.Name)
+	}
 	return nil
 }
 
 // Get a list of all cookbooks on this server.
 func GetList() []string {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return getCookbookListMySQL()
 	} 
 	ds := data_store.New()
@@ -208,9 +228,14 @@ func GetList() []string {
 	return cb_list
 }
 
+// Get all of the cookbook's versions, newest first.
+func (c *Cookbook) AllVersions() []*CookbookVersion {
+	return c.sortedVersions()
+}
+
 /* Returns a sorted list of all the versions of this cookbook */
 func (c *Cookbook)sortedVersions() ([]*CookbookVersion){
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return c.sortedCookbookVersionsMySQL()
 	} 
 	sorted := make([]*CookbookVersion, len(c.Versions))
@@ -597,7 +622,7 @@ func (c *Cookbook)GetVersion(cbVersion string) (*CookbookVersion, util.Gerror) {
 	var cbv *CookbookVersion
 	var found bool
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		// Ridiculously cacheable, but let's get it working first. This
 		// applies all over the place w/ the SQL bits.
 		if cbv, found = c.Versions[cbVersion]; !found {
@@ -633,8 +658,8 @@ func extractVerNums(cbVersion string) (maj, min, patch int64, err util.Gerror) {
 		return 0, 0, 0, err
 	}
 	nums := strings.Split(cbVersion, ".")
-	if len(nums) < 2 && len(nums) > 3 {
-		err = util.Errorf("incorrect number of numbers in version string '%s'", len(nums))
+	if len(nums) < 2 || len(nums) > 3 {
+		err = util.Errorf("incorrect number of numbers in version string '%s'", cbVersion)
 		return 0, 0, 0, err
 	}
 	var vt int64
@@ -708,7 +733,7 @@ func (c *Cookbook)DeleteVersion(cb_version string) util.Gerror {
 
 	file_hashes := cbv.fileHashes()
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := cbv.deleteCookbookVersionMySQL()
 		if err != nil {
 			return nil
@@ -717,6 +742,7 @@ func (c *Cookbook)DeleteVersion(cb_version string) util.Gerror {
 	c.numVersions = nil
 
 	delete(c.Versions, cb_version)
+	indexer.DeleteItemFromCollection("cookbook", cbv.Name)
 	c.deleteHashes(file_hashes)
 	
 	c.Save()
@@ -851,7 +877,7 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 	cbv.Metadata = cbv_data["metadata"].(map[string]interface{})
 
 	/* If we're using SQL, update this version in the DB. */
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := cbv.updateCookbookVersionMySQL(); err != nil {
 			return err
 		}
@@ -863,6 +889,7 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 		cbook, _ := Get(cbv.CookbookName)
 		cbook.deleteHashes(file_hashes)
 	}
+	indexer.IndexObj(cbv)
 	
 	return nil
 }
@@ -1024,6 +1051,50 @@ func (cbv *CookbookVersion) RecipeList() ([]string, util.Gerror) {
 	return recipes, nil
 }
 
+// Get a cookbook version by its full name, like "apache2-1.2.3", which is how
+// cookbook versions are identified in the search index.
+func GetVersionByName(name string) (*CookbookVersion, util.Gerror) {
+	i := strings.LastIndex(name, "-")
+	if i == -1 {
+		err := util.Errorf("Cannot find a cookbook version named %s", name)
+		err.SetStatus(http.StatusNotFound)
+		return nil, err
+	}
+	cb, err := Get(name[:i])
+	if err != nil {
+		return nil, err
+	}
+	return cb.GetVersion(name[i+1:])
+}
+
+/* Functions to support indexing */
+
+func (cbv *CookbookVersion) DocId() string {
+	return cbv.Name
+}
+
+func (cbv *CookbookVersion) Index() string {
+	return "cookbook"
+}
+
+// Flatten a cookbook version for indexing. The metadata is indexed both under
+// "metadata_" and at the top level, like node attributes, so cookbook versions
+// can be searched with "maintainer:..." or "dependencies:apache2".
+func (cbv *CookbookVersion) Flatten() []string {
+	flatten := util.FlattenObj(cbv.flatExport())
+	for k, v := range util.DeepMerge("", cbv.Metadata) {
+		if _, found := flatten[k]; !found {
+			flatten[k] = v
+		}
+	}
+	indexified := util.Indexify(flatten)
+	return indexified
+}
+
+func (cbv *CookbookVersion) flatExport() *flatCookbookVersion {
+	return &flatCookbookVersion{ CookbookName: cbv.CookbookName, Name: cbv.Name, Version: cbv.Version, ChefType: cbv.ChefType, JsonClass: cbv.JsonClass, IsFrozen: cbv.IsFrozen, Metadata: cbv.Metadata }
+}
+
 /* Version string functions to implement sorting */
 
 func (v VersionStrings) Len() int {
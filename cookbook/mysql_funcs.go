@@ -276,43 +276,43 @@ func (cbv *CookbookVersion)fillCookbookVersionFromSQL(row data_store.ResRow) err
 
 	/* TODO: experiment some more with getting this done with
 	 * pointers. */
-	err = data_store.DecodeBlob(metb, &cbv.Metadata)
+	err = data_store.DecodeFromJSON(metb, &cbv.Metadata)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(defb, &cbv.Definitions)
+	err = data_store.DecodeFromJSON(defb, &cbv.Definitions)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(libb, &cbv.Libraries)
+	err = data_store.DecodeFromJSON(libb, &cbv.Libraries)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(attb, &cbv.Attributes)
+	err = data_store.DecodeFromJSON(attb, &cbv.Attributes)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(recb, &cbv.Recipes)
+	err = data_store.DecodeFromJSON(recb, &cbv.Recipes)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(prob, &cbv.Providers)
+	err = data_store.DecodeFromJSON(prob, &cbv.Providers)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(temb, &cbv.Templates)
+	err = data_store.DecodeFromJSON(temb, &cbv.Templates)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(resb, &cbv.Resources)
+	err = data_store.DecodeFromJSON(resb, &cbv.Resources)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(roob, &cbv.RootFiles)
+	err = data_store.DecodeFromJSON(roob, &cbv.RootFiles)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(filb, &cbv.Files)
+	err = data_store.DecodeFromJSON(filb, &cbv.Files)
 	if err != nil {
 		return err
 	}
@@ -364,61 +364,61 @@ func (cbv *CookbookVersion)deleteCookbookVersionMySQL() util.Gerror {
 
 func (cbv *CookbookVersion) updateCookbookVersionMySQL() util.Gerror {
 	// Preparing the complex data structures to be saved 
-	defb, deferr := data_store.EncodeBlob(cbv.Definitions)
+	defb, deferr := data_store.EncodeToJSON(cbv.Definitions)
 	if deferr != nil {
 		gerr := util.Errorf(deferr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	libb, liberr := data_store.EncodeBlob(cbv.Libraries)
+	libb, liberr := data_store.EncodeToJSON(cbv.Libraries)
 	if liberr != nil {
 		gerr := util.Errorf(liberr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	attb, atterr := data_store.EncodeBlob(cbv.Attributes)
+	attb, atterr := data_store.EncodeToJSON(cbv.Attributes)
 	if atterr != nil {
 		gerr := util.Errorf(atterr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	recb, recerr := data_store.EncodeBlob(cbv.Recipes)
+	recb, recerr := data_store.EncodeToJSON(cbv.Recipes)
 	if recerr != nil {
 		gerr := util.Errorf(recerr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	prob, proerr := data_store.EncodeBlob(cbv.Providers)
+	prob, proerr := data_store.EncodeToJSON(cbv.Providers)
 	if proerr != nil {
 		gerr := util.Errorf(proerr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	resb, reserr := data_store.EncodeBlob(cbv.Resources)
+	resb, reserr := data_store.EncodeToJSON(cbv.Resources)
 	if reserr != nil {
 		gerr := util.Errorf(reserr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	temb, temerr := data_store.EncodeBlob(cbv.Templates)
+	temb, temerr := data_store.EncodeToJSON(cbv.Templates)
 	if temerr != nil {
 		gerr := util.Errorf(temerr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	roob, rooerr := data_store.EncodeBlob(cbv.RootFiles)
+	roob, rooerr := data_store.EncodeToJSON(cbv.RootFiles)
 	if rooerr != nil {
 		gerr := util.Errorf(rooerr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	filb, filerr := data_store.EncodeBlob(cbv.Files)
+	filb, filerr := data_store.EncodeToJSON(cbv.Files)
 	if filerr != nil {
 		gerr := util.Errorf(filerr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	metb, meterr := data_store.EncodeBlob(cbv.Metadata)
+	metb, meterr := data_store.EncodeToJSON(cbv.Metadata)
 	if meterr != nil {
 		gerr := util.Errorf(meterr.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
@@ -63,7 +63,7 @@ func New(name string) (*DataBag, util.Gerror){
 		return nil, err
 	}
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var cerr error
 		found, cerr = checkForDataBagMySQL(data_store.Dbh, name)
 		if cerr != nil {
@@ -93,7 +93,7 @@ func New(name string) (*DataBag, util.Gerror){
 func Get(db_name string) (*DataBag, util.Gerror){
 	var data_bag *DataBag
 	var err error
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		data_bag, err = getDataBagMySQL(db_name)
 		if err != nil {
 			var gerr util.Gerror
@@ -126,7 +126,7 @@ func Get(db_name string) (*DataBag, util.Gerror){
 }
 
 func (db *DataBag) Save() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return db.saveMySQL()
 	} else {
 		ds := data_store.New()
@@ -136,7 +136,7 @@ func (db *DataBag) Save() error {
 }
 
 func (db *DataBag) Delete() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := db.deleteMySQL()
 		if err != nil {
 			return err
@@ -156,7 +156,7 @@ func (db *DataBag) Delete() error {
 // Returns a list of data bags on the server.
 func GetList() []string {
 	var db_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		db_list = getListMySQL()
 	} else {
 		ds := data_store.New()
@@ -200,7 +200,7 @@ func (db *DataBag) NewDBItem (raw_dbag_item map[string]interface{}) (*DataBagIte
 	}
 	dbi_full_name := fmt.Sprintf("data_bag_item_%s_%s", db.Name, dbi_id)
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		d, err := db.getDBItemMySQL(dbi_id)
 		if d != nil || (err != nil && err != sql.ErrNoRows) {
 			if err != nil {
@@ -253,7 +253,7 @@ func (db *DataBag) UpdateDBItem(dbi_id string, raw_dbag_item map[string]interfac
 		return nil, err
 	}
 	db_item.RawData = raw_dbag_item
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err = db_item.updateDBItemMySQL()
 		if err != nil {
 			return nil, err
@@ -270,7 +270,7 @@ func (db *DataBag) UpdateDBItem(dbi_id string, raw_dbag_item map[string]interfac
 }
 
 func (db *DataBag) DeleteDBItem(db_item_name string) error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		dbi, err := db.GetDBItem(db_item_name)
 		if err != nil {
 			return err
@@ -291,7 +291,7 @@ func (db *DataBag) DeleteDBItem(db_item_name string) error {
 }
 
 func (db *DataBag) GetDBItem(db_item_name string) (*DataBagItem, error) {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		dbi, err := db.getDBItemMySQL(db_item_name)
 		if err == sql.ErrNoRows {
 			err = fmt.Errorf("data bag item %s in %s not found", db_item_name, db.Name)
@@ -308,7 +308,7 @@ func (db *DataBag) GetDBItem(db_item_name string) (*DataBagItem, error) {
 }
 
 func (db *DataBag) AllDBItems() (map[string]*DataBagItem, error) {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return db.allDBItemsMySQL()
 	} else {
 		return db.DataBagItems, nil
@@ -316,7 +316,7 @@ func (db *DataBag) AllDBItems() (map[string]*DataBagItem, error) {
 }
 
 func (db *DataBag) ListDBItems() []string {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return db.listDBItemsMySQL()
 	} else {
 		dbis := make([]string, len(db.DataBagItems))
@@ -330,7 +330,7 @@ func (db *DataBag) ListDBItems() []string {
 }
 
 func (db *DataBag) NumDBItems() int {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return db.numDBItemsMySQL()
 	} else {
 		return len(db.DataBagItems)
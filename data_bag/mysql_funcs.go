@@ -60,7 +60,7 @@ func (dbi *DataBagItem) fillDBItemFromMySQL(row data_store.ResRow) error {
 	}
 	dbi.ChefType = "data_bag_item"
 	dbi.JsonClass = "Chef::DataBagItem"
-	err = data_store.DecodeBlob(rawb, &dbi.RawData)
+	err = data_store.DecodeFromJSON(rawb, &dbi.RawData)
 	if err != nil {
 		return err
 	}
@@ -84,7 +84,7 @@ func (db *DataBag) getDBItemMySQL(db_item_name string) (*DataBagItem, error) {
 }
 
 func (db *DataBag) newDBItemMySQL(dbi_id string, raw_dbag_item map[string]interface{}) (*DataBagItem, error){
-	rawb, rawerr := data_store.EncodeBlob(&raw_dbag_item)
+	rawb, rawerr := data_store.EncodeToJSON(&raw_dbag_item)
 	if rawerr != nil {
 		return nil, rawerr
 	}
@@ -128,7 +128,7 @@ func (db *DataBag) newDBItemMySQL(dbi_id string, raw_dbag_item map[string]interf
 }
 
 func (dbi *DataBagItem) updateDBItemMySQL() error {
-	rawb, rawerr := data_store.EncodeBlob(&dbi.RawData)
+	rawb, rawerr := data_store.EncodeToJSON(&dbi.RawData)
 	if rawerr != nil {
 		return rawerr
 	}
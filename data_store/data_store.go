@@ -133,6 +133,14 @@ func (ds *DataStore) GetList(key_type string) []string{
 	return j
 }
 
+// Return how many objects of the given type are in the data store, without
+// building the list of them.
+func (ds *DataStore) Count(key_type string) int {
+	ds.m.RLock()
+	defer ds.m.RUnlock()
+	return len(ds.obj_list[key_type])
+}
+
 // Freeze and save the data store to disk.
 func (ds *DataStore) Save(dsFile string) error {
 	if dsFile == "" {
@@ -236,7 +244,7 @@ func (ds *DataStore) Load(dsFile string) error {
 }
 
 // When restoring an object from either the in-memory data store after it has
-// been saved to disk, or loading an object from the database with gob encoded
+// been saved to disk, or loading an object from the database with JSON encoded
 // data structures, empty slices are encoded as "null" when they're sent out as
 // JSON to the client. This makes the client very unhappy, so those empty slices
 // need to be recreated again. Annoying, but it's how it goes.
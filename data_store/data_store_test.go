@@ -88,6 +88,23 @@ func TestGetList(t *testing.T){
 	}
 }
 
+func TestCount(t *testing.T){
+	ds := New()
+	baz := makeDsObj()
+	ds.Set("foocount", "baz", baz)
+	ds.Set("foocount", "moo", baz)
+	if c := ds.Count("foocount"); c != 2 {
+		t.Errorf("Count returned %d, expected 2", c)
+	}
+	ds.Delete("foocount", "moo")
+	if c := ds.Count("foocount"); c != 1 {
+		t.Errorf("Count returned %d after a delete, expected 1", c)
+	}
+	if c := ds.Count("nothing_here"); c != 0 {
+		t.Errorf("Count returned %d for an empty type, expected 0", c)
+	}
+}
+
 var dsTmpDir = dsTmpGen()
 
 func dsTmpGen() string {
@@ -142,6 +159,30 @@ func TestSaveAndLoadData(t *testing.T) {
 	}
 }
 
+func TestEncodeDecodeJSON(t *testing.T) {
+	rl := []string{ "recipe[foo]", "role[bar]" }
+	j, err := EncodeToJSON(&rl)
+	if err != nil {
+		t.Errorf("EncodeToJSON() gave an error: %s", err)
+	}
+	if j != `["recipe[foo]","role[bar]"]` {
+		t.Errorf("EncodeToJSON() returned unexpected JSON: %s", j)
+	}
+	var rl2 []string
+	err = DecodeFromJSON([]byte(j), &rl2)
+	if err != nil {
+		t.Errorf("DecodeFromJSON() gave an error: %s", err)
+	}
+	if len(rl2) != 2 || rl2[0] != rl[0] || rl2[1] != rl[1] {
+		t.Errorf("DecodeFromJSON() returned %v, expected %v", rl2, rl)
+	}
+	var attr map[string]interface{}
+	err = DecodeFromJSON(nil, &attr)
+	if err != nil || attr != nil {
+		t.Errorf("DecodeFromJSON() on NULL data should have left the object alone, got %v :: %v", attr, err)
+	}
+}
+
 // clean up
 
 func TestCleanup(t *testing.T) {
@@ -23,8 +23,7 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"strings"
 	"fmt"
-	"bytes"
-	"encoding/gob"
+	"encoding/json"
 )
 
 // The database handle.
@@ -70,37 +69,39 @@ func ConnectDB(dbEngine string, params interface{}) (*sql.DB, error) {
 	}
 }
 
-// Encode a slice or map of goiardi object data to save in the database. Pass 
-// the object to be encoded in like data_store.EncodeBlob(&foo.Thing).
-func EncodeBlob(obj interface{}) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := gob.NewEncoder(buf)
-	var err error
-	defer func() {
-		if x := recover(); x != nil {
-			err = fmt.Errorf("Something went wrong encoding an object for storing in the database with Gob")
-		}
-	}()
-	err = enc.Encode(obj)
+// Encode a slice or map of goiardi object data as JSON to save in the 
+// database. Pass the object to be encoded in like 
+// data_store.EncodeToJSON(&foo.Thing). The JSON is returned as a string rather
+// than a byte slice so the MySQL driver sends it as text, which MySQL's JSON
+// columns require.
+func EncodeToJSON(obj interface{}) (string, error) {
+	b, err := json.Marshal(obj)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return buf.Bytes(), nil
+	return string(b), nil
 }
 
-// Decode the data encoded with EncodeBlob that was stored in the database so it
-// can be loaded back into a goiardi object. The 'obj' in the arguments *must*
-// be the address of the object receiving the blob of data (e.g.
-// data_store.DecodeBlob(data, &obj).
-func DecodeBlob(data []byte, obj interface{}) (error) {
-	// hmmm
-	dbuf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(dbuf)
-	err := dec.Decode(obj)
+// Decode the JSON encoded with EncodeToJSON that was stored in the database so
+// it can be loaded back into a goiardi object. The 'obj' in the arguments 
+// *must* be the address of the object receiving the data (e.g.
+// data_store.DecodeFromJSON(data, &obj). A NULL column leaves obj untouched.
+func DecodeFromJSON(data []byte, obj interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// Count the rows in the given table. Unlike the GetList functions, errors are
+// returned to the caller rather than being fatal.
+func CountRows(dbhandle Dbhandle, table string) (int, error) {
+	var count int
+	err := dbhandle.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return count, nil
 }
 
 // Check for one object of the given type identified by the given name. For this
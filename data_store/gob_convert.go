@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+type gobColumn struct {
+	name string
+	proto func() interface{}
+}
+
+func newSlice() interface{} { return new([]string) }
+func newMap() interface{} { return new(map[string]interface{}) }
+func newStrMap() interface{} { return new(map[string]string) }
+func newSliceMap() interface{} { return new(map[string][]string) }
+func newMapSlice() interface{} { return new([]map[string]interface{}) }
+
+// The columns that held gob encoded data before the json_columns schema
+// change, along with the type of data that was encoded into each one.
+var gobColumns = map[string][]gobColumn{
+	"nodes": []gobColumn{ {"run_list", newSlice}, {"automatic_attr", newMap}, {"normal_attr", newMap}, {"default_attr", newMap}, {"override_attr", newMap} },
+	"roles": []gobColumn{ {"run_list", newSlice}, {"env_run_lists", newSliceMap}, {"default_attr", newMap}, {"override_attr", newMap} },
+	"environments": []gobColumn{ {"default_attr", newMap}, {"override_attr", newMap}, {"cookbook_vers", newStrMap} },
+	"data_bag_items": []gobColumn{ {"raw_data", newMap} },
+	"sandboxes": []gobColumn{ {"checksums", newSlice} },
+	"cookbook_versions": []gobColumn{ {"metadata", newMap}, {"definitions", newMapSlice}, {"libraries", newMapSlice}, {"attributes", newMapSlice}, {"recipes", newMapSlice}, {"providers", newMapSlice}, {"resources", newMapSlice}, {"templates", newMapSlice}, {"root_files", newMapSlice}, {"files", newMapSlice} },
+}
+
+// ConvertGobColumns converts any rows still holding gob encoded data in the
+// "<column>_gob" columns left behind by the json_columns schema change into
+// JSON, and clears the old gob data out. Tables that no longer have the old
+// columns (fresh installs, or after the drop_gob_columns change has been
+// deployed) are skipped, so this is safe to run every time goiardi starts up.
+// The types stored in the gob data must already be registered with
+// gob.Register.
+func ConvertGobColumns(dbhandle Dbhandle) (int, error) {
+	converted := 0
+	for table, cols := range gobColumns {
+		for _, col := range cols {
+			gobCol := fmt.Sprintf("%s_gob", col.name)
+			exists, err := columnExists(dbhandle, table, gobCol)
+			if err != nil {
+				return converted, err
+			}
+			if !exists {
+				continue
+			}
+			n, err := convertGobColumn(dbhandle, table, col, gobCol)
+			converted += n
+			if err != nil {
+				return converted, err
+			}
+		}
+	}
+	return converted, nil
+}
+
+func columnExists(dbhandle Dbhandle, table string, column string) (bool, error) {
+	var c int
+	err := dbhandle.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", table, column).Scan(&c)
+	if err != nil {
+		return false, err
+	}
+	return c != 0, nil
+}
+
+func convertGobColumn(dbhandle Dbhandle, table string, col gobColumn, gobCol string) (int, error) {
+	selStatement := fmt.Sprintf("SELECT id, %s FROM %s WHERE %s IS NOT NULL", gobCol, table, gobCol)
+	rows, err := dbhandle.Query(selStatement)
+	if err != nil {
+		return 0, err
+	}
+	converted := make(map[int32]string)
+	for rows.Next() {
+		var id int32
+		var data []byte
+		if err = rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		obj := col.proto()
+		dec := gob.NewDecoder(bytes.NewBuffer(data))
+		if err = dec.Decode(obj); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not decode gob data in %s.%s for id %d: %s", table, gobCol, id, err.Error())
+		}
+		j, err := EncodeToJSON(obj)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		converted[id] = j
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updStatement := fmt.Sprintf("UPDATE %s SET %s = ?, %s = NULL WHERE id = ?", table, col.name, gobCol)
+	n := 0
+	for id, j := range converted {
+		if _, err = dbhandle.Exec(updStatement, j, id); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
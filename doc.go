@@ -31,8 +31,9 @@ goiardi.
 
 Many go tests are present as well in different goiardi subdirectories.
 
-Goiardi currently has five dependencies: go-flags, go-cache, go-trie, toml, and
-the mysql driver from go-sql-driver. 
+Goiardi currently has seven dependencies: go-flags, go-cache, go-trie, toml, the
+mysql driver from go-sql-driver, bcrypt from the go crypto packages, and
+go-ldap.
 
 To install them, run:
 
@@ -41,6 +42,8 @@ To install them, run:
    go get github.com/ctdk/go-trie/gtrie
    go get github.com/BurntSushi/toml
    go get github.com/go-sql-driver/mysql
+   go get golang.org/x/crypto/bcrypt
+   go get github.com/go-ldap/ldap/v3
 
 from your $GOROOT.
 
@@ -117,6 +120,24 @@ Currently available command line and config file options:
                           relative to --conf-root.
        --ssl-key=         SSL key file. If a relative path, will be set relative
                           to --conf-root.
+       --ssl-min-version= Oldest TLS version to accept, one of 1.0, 1.1, 1.2,
+                          or 1.3. Default: 1.2.
+       --ssl-cipher=      A cipher suite to allow with TLS 1.2 and older, like
+                          TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. May be given
+                          more than once. Default: Go's secure cipher suites.
+       --ssl-disable-http2 Don't use HTTP/2 for SSL connections.
+       --ssl-client-ca=   CA certificate file to verify client certificates
+                          with. If set, clients have to present a certificate
+                          it signed, unless --ssl-client-certs is optional. If
+                          a relative path, will be set relative to --conf-root.
+       --ssl-client-certs= Whether clients have to present a certificate when
+                          --ssl-client-ca is set, either require or optional.
+                          Default: require.
+       --ssl-client-cert-auth= Use the common name of client certificates to
+                          authenticate requests as the client or user of that
+                          name, either as an alternative to signing the
+                          request headers, or required as well as signing
+                          them. Requires --ssl-client-ca.
        --https-urls       Use 'https://' in URLs to server resources if goiardi
                           is not using SSL for its connections. Useful when
                           goiardi is sitting behind a reverse proxy that uses
@@ -128,6 +149,36 @@ Currently available command line and config file options:
        --local-filestore-dir= Directory to save uploaded files in. Optional when
                           running in in-memory mode, *mandatory* for SQL
                           mode.
+       --report-retention= Number of days to keep chef-client run reports.
+                          Set to -1 to keep them forever. Default: 90.
+       --login-attempts=  Number of failed logins in a row allowed for a user
+                          before their logins are refused for a while. Set to
+                          -1 to never refuse them. Default: 5.
+       --login-ip-attempts= Number of failed logins in a row allowed from one
+                          address before logins from it are refused for a
+                          while. Set to -1 to never refuse them. Default: 20.
+       --login-lockout=   How long logins are refused after too many
+                          failures, doubling with each further failure up to
+                          an hour. Formatted like 5m, 150s, etc. Default: 1m.
+       --use-ldap         Authenticate users logging in through
+                          /authenticate_user against the LDAP directory
+                          set up in the config file's [ldap] section.
+       --rate-limit=      Requests per second allowed for each client and
+                          non-admin user. Default: no limit.
+       --admin-rate-limit= Requests per second allowed for each admin.
+                          Default: no limit.
+       --validator-rate-limit= Requests per second allowed for the validator
+                          client. Default: no limit.
+       --metrics-addr=    Also serve Prometheus metrics at /metrics on this
+                          address, like 127.0.0.1:9100, without
+                          authentication.
+       --shutdown-timeout= How long to wait for requests to finish when
+                          shutting down. Formatted like 5m, 150s, etc.
+                          Default: 30s.
+       --access-log=      Log each request to file X. Use - for stdout.
+       --access-log-format= Format of the access log, either combined (like
+                          Apache's) or json, for one JSON object per line.
+                          Default: combined.
 
    Options specified on the command line override options in the config file.
 
@@ -175,6 +226,16 @@ If you really really don't want to install sqitch, apply each SQL patch in
 sql-files/mysql-bundle by hand in the same order they're listed in the
 sqitch.plan file.
 
+Goiardi stores run lists, attributes, and other object data in MySQL JSON
+columns, so MySQL 5.7.8 or later is required. Earlier versions of goiardi
+stored this data as gob encoded blobs. Deploying the bundle to a database
+created by goiardi 0.5.0 keeps the old data in "_gob" columns, and goiardi
+converts it to JSON when it starts up. Once goiardi has been started, drop the
+old columns by deploying the separate drop_gob_columns.plan
+(`sqitch --plan-file drop_gob_columns.plan deploy db:mysql://root@<password>/goiardi`).
+It refuses to drop them while any rows still have gob encoded data. New
+databases can deploy it right away.
+
 The above values are for illustration, of course; nothing requires goiardi's
 database to be named "goiardi". Just make sure the right database is specified in
 the config file.
@@ -200,6 +261,195 @@ given below:
 		[mysql.extra_params]
 			tls = "false"
 
+Reporting
+
+Goiardi implements the Chef reporting API, so chef-client will send it a report
+of each run (including the resources updated during the run) as long as
+reporting is enabled on the client. Reports for a node are listed at
+/reports/nodes/NODE/runs and reports for all nodes at /reports/org/runs. Both
+take the optional query parameters "from" and "until" (seconds since the
+epoch), "rows" (default 10), and "status" ("started", "success", or "failure").
+A single run's report, with its resources, is at /reports/org/runs/RUN_ID.
+Reports older than the --report-retention option (90 days by default) are
+purged hourly. The reports also update the node run status history described
+below.
+
+Node Run Status
+
+Goiardi keeps a history of chef-client runs for each node, up to the last 50
+runs. A run is recorded when a node's own client saves the node, which
+chef-client does at the end of a successful run. A report handler can also
+record runs by POSTing to /nodes/NAME/status with a body like
+`{"status": "started", "run_list": ["recipe[foo]"]}`. The status is one of
+"started", "success", or "failure", and "start_time" and "end_time" may be
+given as RFC 3339 timestamps. An optional "run_id" ties the reports for a run
+together. A "success" or "failure" status finishes the last
+run if it was started but never finished. GET /nodes/NAME/status returns the
+node's recorded runs, oldest first.
+
+GET /status/stale_nodes?minutes=N lists the nodes whose last successful run
+ended more than N minutes ago (default 60), along with when that run ended.
+Nodes that have never had a successful run are included too.
+
+Run List Expansion
+
+Goiardi can expand the roles in a run list the same way chef-client does.
+GET /nodes/NAME/expanded_run_list expands a node's run list with the run lists
+for the node's environment, and POSTing `{"run_list": [...]}` to
+/environments/NAME/run_list_expand expands any run list for that environment.
+The response has the recipes in the order they would be run, each recipe along
+with the roles that included it, the roles that were applied, and any loops of
+roles that include each other.
+
+Saved Searches
+
+Searches that define groups of objects, like `role:web AND
+chef_environment:prod`, can be saved by name so every tool uses the same
+query. Admins create them by POSTing `{"name": "prod_web", "index": "node",
+"query": "role:web AND chef_environment:prod"}` to /saved_searches, and update
+or delete them at /saved_searches/NAME. GET /search/_saved/NAME runs the saved
+search, taking the same "rows", "start", "sort", and "score" parameters as a
+regular search, and partial searches work by POSTing to it too. With
+`"cache_members": true` the ids of the matching objects are kept in memory and
+only searched for again after objects in the saved search's index change.
+
+Login Lockouts
+
+Failed logins through /authenticate_user are counted for each user and for
+each address they come from. After --login-attempts failures in a row for a
+user, or --login-ip-attempts from an address, logins for that user or from that
+address are refused with a 429 status and a Retry-After header for
+--login-lockout. Each further failure doubles the lockout, up to an hour. A
+successful login clears the user's count. Only users who exist are counted,
+and logins through the web UI aren't counted by address, since they all come
+from the web UI's address. Admins can list the users and
+addresses with failed logins at /login_lockouts, and look at or clear
+(DELETE) one at /login_lockouts/user/NAME or /login_lockouts/ip/ADDRESS.
+Successful, failed, and refused logins are logged with an "audit:" prefix.
+
+LDAP
+
+With --use-ldap, users logging in through /authenticate_user are checked
+against an LDAP directory instead of their goiardi passwords. Goiardi binds
+with the bind-dn and bind-password from the config file's [ldap] section (or
+anonymously if there's no bind-dn), searches under base-dn with user-filter for
+the user's entry, and binds as that entry with the password given. The first
+time a user logs in this way they're created in goiardi, and each login
+updates their name and email from the directory. If admin-groups is set, the
+user is made an admin if they're a member of one of those groups, and stops
+being one if they aren't. Only the users listed in local-users, which
+defaults to the admin user goiardi creates, log in with their goiardi
+passwords; everyone else has to be in the directory, and their login fails if
+the directory can't be reached. Set use-tls to connect with ldaps, or start-tls to use StartTLS, and ca-cert to
+verify the server's certificate. See etc/goiardi.conf-sample for all the
+options.
+
+Rate Limits
+
+Goiardi can limit how many requests each client and user makes, so that a
+chef-client stuck in a loop or a script working through every node can't tie
+it up. Each actor gets a bucket of tokens, refilled at the rate set with
+--rate-limit, --admin-rate-limit, or --validator-rate-limit (depending on
+whether it's an admin or the validator), which holds up to ten seconds' worth
+unless the burst sizes are set in the config file's [rate-limit] section. Each
+request takes tokens out of the bucket; searches cost 5 and everything else
+costs 1, but the costs can be set for each endpoint and method in the
+[rate-limit.costs] section. Requests made without enough tokens left get a 429
+status with a Retry-After header, and are logged. Requests made through the
+web UI count against the limit of the user using it, not the chef-webui client.
+Requests that aren't authenticated, including every request when use-auth is
+off, count against their address's limit, as does each request that fails
+authentication, and an address that's used up its limit of failures has its
+requests refused before they're checked.
+
+Metrics
+
+Goiardi keeps metrics in the Prometheus text format at /metrics: HTTP request
+counts and latencies for each route and status, authentication failures by
+reason, search latencies and result counts, the number of documents in each
+index collection and of each type of object, how long saving the data store
+and index took and how often it failed, requests refused by rate limits, and
+MySQL connection pool statistics in MySQL mode. On goiardi's own address
+/metrics is only available to admins, which Prometheus can't sign requests as,
+so use --metrics-addr to serve the metrics on another address too. That
+address doesn't require authentication, so it should only be reachable by
+whatever is collecting the metrics.
+
+Access Logs
+
+With --access-log set, goiardi logs each request to that file: who made it,
+the status, the size of the response, and how long it took. The default
+combined format is Apache's combined log format with the request id and the
+time taken in milliseconds added to the end of each line, and with
+--access-log-format=json each request is logged as a JSON object on its own
+line instead. The user or client's name is logged as it was sent, so it's
+there even for requests that failed to authenticate.
+
+Every response has an X-Request-Id header with an id for the request, which is
+also put at the start of the log messages about it, so a request that went
+wrong can be found in the logs. If a proxy in front of goiardi sends its own
+X-Request-Id header, that id is used instead. The access log is opened again
+on SIGHUP, so it can be rotated.
+
+SSL Settings and Client Certificates
+
+With --use-ssl goiardi only accepts TLS 1.2 and newer by default; use
+--ssl-min-version to change that. --ssl-cipher limits the cipher suites used
+with TLS 1.2 and older to the ones given, out of the ones Go considers secure.
+TLS 1.3's cipher suites can't be changed. Clients that support it use HTTP/2,
+unless --ssl-disable-http2 is set.
+
+With --ssl-client-ca set, clients have to present a certificate signed by that
+CA to connect at all, or, with --ssl-client-certs=optional, the certificate is
+only checked if they present one. That includes anything checking /_status.
+The common name of a client certificate can also be used to authenticate
+requests as the client or user of that name, with --ssl-client-cert-auth:
+
+  * alternative: requests with a client certificate don't have to be signed,
+    as long as X-Ops-UserId is missing or is the same name as the certificate.
+    Requests without one are authenticated by their signed headers as usual.
+    This is handy for automation that's already given certificates.
+  * required: requests have to be signed as usual, and also have to have a
+    client certificate for the same client or user that signed them.
+
+Status
+
+GET /_status reports whether goiardi is ready and healthy, for load balancers
+and the like, and doesn't need authentication. goiardi starts listening before
+it's loaded its data store and index and created the default clients and
+users; until it's done, /_status and every other request get a 503 status.
+Once it's ready, /_status checks that the MySQL database answers in MySQL
+mode and that files can be made in the local filestore directory if there is
+one, giving a 503 status if either check fails. It also has the state of the
+index, when the data store and index were last saved to disk, and version
+information.
+
+Shutting Down
+
+On SIGINT or SIGTERM goiardi stops accepting connections and waits up to
+--shutdown-timeout for the requests it's working on to finish. Then, if it's
+saving its data to disk, it saves the data store and index one last time
+before exiting. Saves never happen while a request is changing anything, so
+the index always matches the data store it's saved with. If requests changing
+data are still running when the timeout's up, that last save is skipped and
+anything changed since the previous save is lost. A second SIGINT or SIGTERM
+makes goiardi exit right away.
+
+Reloading the Configuration
+
+On SIGHUP goiardi reads its command line options and config file again. If
+they're valid, the new configuration replaces the old one all at once;
+otherwise the error is logged and goiardi keeps running with the old one. The
+log level, log file, time slew, webui, rate limit, login lockout, and LDAP
+settings take effect right away, and the SSL certificate, key, and client CA
+are loaded again, so a renewed certificate can be put in place without
+restarting. If they can't be loaded, the whole reload is refused. New SSL connections get the new SSL settings. The log file and
+access log are opened again too, so they can be rotated. Changes to the
+address and port, the data and index files, the freeze interval, MySQL, the local
+filestore directory, report retention, use-auth, use-ssl, and metrics-addr
+need goiardi to be restarted. They're logged, and listed under
+"restart_needed" in /_status, and the old settings are kept until then.
+
 Tested Platforms
 
 Goiardi has been built and run with the native 6g compiler on Mac OS X (10.7, 
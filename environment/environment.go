@@ -47,7 +47,7 @@ type ChefEnvironment struct {
 // exists or you try to create an environment named "_default".
 func New(name string) (*ChefEnvironment, util.Gerror){
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var eerr error
 		found, eerr = checkForEnvironmentMySQL(data_store.Dbh, name)
 		if eerr != nil {
@@ -210,7 +210,7 @@ func Get(env_name string) (*ChefEnvironment, util.Gerror){
 	}
 	var env *ChefEnvironment
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		env, err = getEnvironmentMySQL(env_name)
 		if err != nil {
@@ -245,7 +245,7 @@ func Get(env_name string) (*ChefEnvironment, util.Gerror){
 // Creates the default environment on startup.
 func MakeDefaultEnvironment() {
 	var de *ChefEnvironment
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		// The default environment is pre-created in the db schema when
 		// it's loaded. Re-indexing the default environment doesn't
 		// hurt anything though, so just get the usual default env and
@@ -285,7 +285,7 @@ func (e *ChefEnvironment) Save() util.Gerror {
 		err.SetStatus(http.StatusMethodNotAllowed)
 		return err
 	}
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := e.saveEnvironmentMySQL()
 		if err != nil {
 			return err
@@ -305,7 +305,7 @@ func (e *ChefEnvironment) Delete() error {
 		err := fmt.Errorf("The '_default' environment cannot be modified.")
 		return err
 	}
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := e.deleteEnvironmentMySQL(); err != nil {
 			return nil
 		}
@@ -320,7 +320,7 @@ func (e *ChefEnvironment) Delete() error {
 // Get a list of all environments on this server.
 func GetList() []string {
 	var env_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		env_list = getEnvironmentList()
 	} else {
 		ds := data_store.New()
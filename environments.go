@@ -224,7 +224,8 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 		env_name := path_array[1]
 		op := path_array[2]
 
-		if op == "cookbook_versions" && r.Method != "POST" || op != "cookbook_versions" && r.Method != "GET" {
+		post_op := op == "cookbook_versions" || op == "run_list_expand"
+		if post_op && r.Method != "POST" || !post_op && r.Method != "GET" {
 			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
 			return
 		}
@@ -262,7 +263,15 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 					JsonErrorReport(w, r, "POSTed JSON badly formed.", http.StatusMethodNotAllowed)
 					return
 				}
-				deps, err := cookbook.DependsCookbooks(cb_ver["run_list"].([]string), env.CookbookVersions)
+				/* Expand any roles in the run list with this
+				 * environment's run lists before working out
+				 * the dependencies. */
+				run_list, err := role.ExpandRunList(cb_ver["run_list"].([]string), env_name)
+				if err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusPreconditionFailed)
+					return
+				}
+				deps, err := cookbook.DependsCookbooks(run_list, env.CookbookVersions)
 				if err != nil {
 					JsonErrorReport(w, r, err.Error(), http.StatusPreconditionFailed)
 					return
@@ -273,6 +282,27 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
 				}
 				return
+			case "run_list_expand":
+				rl_data, jerr := ParseObjJson(r.Body)
+				if jerr != nil {
+					JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+					return
+				}
+				run_list, ok := rl_data["run_list"].([]string)
+				if !ok {
+					JsonErrorReport(w, r, "Field 'run_list' missing", http.StatusBadRequest)
+					return
+				}
+				exp, gerr := role.Expand(run_list, env_name)
+				if gerr != nil {
+					JsonErrorReport(w, r, gerr.Error(), gerr.Status())
+					return
+				}
+				enc := json.NewEncoder(w)
+				if err := enc.Encode(&exp); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				}
+				return
 			case "cookbooks":
 				env_response = env.AllCookbookHash(num_results)
 			case "nodes":
@@ -332,13 +362,7 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
 				return
 			}
-			var run_list []string
-			if env_name == "_default" {
-				run_list = role.RunList
-			} else {
-				run_list = role.EnvRunLists[env_name]
-			}
-			env_response["run_list"] = run_list
+			env_response["run_list"] = role.RunListForEnv(env_name)
 		} else if op == "cookbooks" {
 			cb, err := cookbook.Get(op_name)
 			if err != nil {
@@ -22,7 +22,7 @@
 // uploading and downloading. All access to the files is through the checksum,
 // rather than the file name.
 //
-// If config.Config.LocalFstoreDir is != "", the content of the files will be
+// If config.Get().LocalFstoreDir is != "", the content of the files will be
 // stored in that directory.
 package filestore
 
@@ -84,7 +84,7 @@ func New(chksum string, data io.ReadCloser, data_length int64) (*FileStore, erro
 func Get(chksum string) (*FileStore, error){
 	var filestore *FileStore
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		filestore, err = getMySQL(chksum)
 		if err != nil {
@@ -108,9 +108,9 @@ func Get(chksum string) (*FileStore, error){
 		err := fmt.Errorf("File with checksum %s not found", chksum)
 		return nil, err
 	}
-	if config.Config.LocalFstoreDir != "" {
+	if config.Get().LocalFstoreDir != "" {
 		/* File data is stored on disk */
-		chkPath := path.Join(config.Config.LocalFstoreDir, chksum)
+		chkPath := path.Join(config.Get().LocalFstoreDir, chksum)
 		
 		fp, err := os.Open(chkPath)
 		if err != nil {
@@ -135,7 +135,7 @@ func Get(chksum string) (*FileStore, error){
 }
 
 func (f *FileStore) Save() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := f.saveMySQL()
 		if err != nil {
 			return err
@@ -144,8 +144,8 @@ func (f *FileStore) Save() error {
 		ds := data_store.New()
 		ds.Set("filestore", f.Chksum, f)
 	}
-	if config.Config.LocalFstoreDir != "" {
-		fp, err := os.Create(path.Join(config.Config.LocalFstoreDir, f.Chksum))
+	if config.Get().LocalFstoreDir != "" {
+		fp, err := os.Create(path.Join(config.Get().LocalFstoreDir, f.Chksum))
 		if err != nil {
 			return err
 		}
@@ -160,7 +160,7 @@ func (f *FileStore) Save() error {
 }
 
 func (f *FileStore) Delete() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := f.deleteMySQL()
 		if err != nil {
 			return err
@@ -170,8 +170,8 @@ func (f *FileStore) Delete() error {
 		ds.Delete("filestore", f.Chksum)
 	}
 
-	if config.Config.LocalFstoreDir != "" {
-		err := os.Remove(path.Join(config.Config.LocalFstoreDir, f.Chksum))
+	if config.Get().LocalFstoreDir != "" {
+		err := os.Remove(path.Join(config.Get().LocalFstoreDir, f.Chksum))
 		if err != nil {
 			return err
 		}
@@ -182,7 +182,7 @@ func (f *FileStore) Delete() error {
 // Get a list of files that have been uploaded.
 func GetList() []string {
 	var file_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		file_list = getListMySQL()
 	} else {
 		ds := data_store.New()
@@ -193,7 +193,7 @@ func GetList() []string {
 
 // Delete all the checksum hashes given from the filestore.
 func DeleteHashes(file_hashes []string) {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		deleteHashesMySQL(file_hashes)
 	} else {
 		for _, ff := range file_hashes {
@@ -211,9 +211,9 @@ func DeleteHashes(file_hashes []string) {
 			}
 		}
 	}
-	if config.Config.LocalFstoreDir != "" {
+	if config.Get().LocalFstoreDir != "" {
 		for _, fh := range file_hashes {
-			err := os.Remove(path.Join(config.Config.LocalFstoreDir, fh))
+			err := os.Remove(path.Join(config.Get().LocalFstoreDir, fh))
 			if err != nil {
 				logger.Errorf(err.Error())
 			}
@@ -33,6 +33,9 @@ import (
 	"github.com/ctdk/goiardi/data_bag"
 	"github.com/ctdk/goiardi/filestore"
 	"github.com/ctdk/goiardi/node"
+	"github.com/ctdk/goiardi/rate_limit"
+	"github.com/ctdk/goiardi/report"
+	"github.com/ctdk/goiardi/saved_search"
 	"github.com/ctdk/goiardi/role"
 	"github.com/ctdk/goiardi/sandbox"
 	"fmt"
@@ -40,9 +43,14 @@ import (
 	"os/signal"
 	"syscall"
 	"encoding/gob"
+	"crypto/tls"
+	"crypto/x509"
 	"time"
 	"github.com/ctdk/goiardi/authentication"
+	"github.com/ctdk/goiardi/util"
 	"strings"
+	"strconv"
+	"math"
 	"git.tideland.biz/goas/logger"
 )
 
@@ -50,11 +58,15 @@ type InterceptHandler struct {} // Doesn't need to do anything, just sit there.
 
 func main(){
 	config.ParseConfigOptions()
+	if err := accessLog.open(config.Get().AccessLog); err != nil {
+		logger.Criticalf("Couldn't open the access log: %s", err.Error())
+		os.Exit(1)
+	}
 
 	/* Here goes nothing, db... */
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var derr error
-		data_store.Dbh, derr = data_store.ConnectDB("mysql", config.Config.MySQL)
+		data_store.Dbh, derr = data_store.ConnectDB("mysql", config.Get().MySQL)
 		if derr != nil {
 			logger.Criticalf(derr.Error())
 			os.Exit(1)
@@ -62,29 +74,21 @@ func main(){
 	}
 
 	gobRegister()
-	ds := data_store.New()
-	if config.Config.FreezeData {
-		if config.Config.DataStoreFile != "" {
-			uerr := ds.Load(config.Config.DataStoreFile)
-			if uerr != nil {
-				logger.Criticalf(uerr.Error())
-				os.Exit(1)
-			}
-		}
-		ierr := indexer.LoadIndex(config.Config.IndexFile)
-		if ierr != nil {
-			logger.Criticalf(ierr.Error())
+	if config.Get().UseMySQL {
+		/* Convert any data left in gob encoded columns by the json_columns
+		 * schema change. */
+		conv, cerr := data_store.ConvertGobColumns(data_store.Dbh)
+		if cerr != nil {
+			logger.Criticalf(cerr.Error())
 			os.Exit(1)
 		}
+		if conv != 0 {
+			logger.Infof("Converted %d gob encoded database columns to JSON", conv)
+		}
 	}
-	setSaveTicker()
-
-	/* Create default clients and users. Currently chef-validator,
-	 * chef-webui, and admin. */
-	createDefaultActors()
-	handleSignals()
 
 	/* Register the various handlers, found in their own source files. */
+	http.HandleFunc("/_status", status_handler)
 	http.HandleFunc("/authenticate_user", authenticate_user_handler)
 	http.HandleFunc("/clients", list_handler)
 	http.HandleFunc("/clients/", client_handler)
@@ -94,16 +98,23 @@ func main(){
 	http.HandleFunc("/data/", data_handler)
 	http.HandleFunc("/environments", environment_handler)
 	http.HandleFunc("/environments/", environment_handler)
+	http.HandleFunc("/login_lockouts", login_lockout_handler)
+	http.HandleFunc("/login_lockouts/", login_lockout_handler)
+	http.HandleFunc("/metrics", metrics_handler)
 	http.HandleFunc("/nodes", list_handler)
 	http.HandleFunc("/nodes/", node_handler)
 	http.HandleFunc("/principals/", principal_handler)
+	http.HandleFunc("/reports/", report_handler)
 	http.HandleFunc("/roles", list_handler)
 	http.HandleFunc("/roles/", role_handler)
 	http.HandleFunc("/sandboxes", sandbox_handler)
 	http.HandleFunc("/sandboxes/", sandbox_handler)
+	http.HandleFunc("/saved_searches", saved_search_handler)
+	http.HandleFunc("/saved_searches/", saved_search_handler)
 	http.HandleFunc("/search", search_handler)
 	http.HandleFunc("/search/", search_handler)
 	http.HandleFunc("/search/reindex", reindexHandler)
+	http.HandleFunc("/status/stale_nodes", stale_nodes_handler)
 	http.HandleFunc("/users", list_handler)
 	http.HandleFunc("/users/", user_handler)
 	http.HandleFunc("/file_store/", file_store_handler)
@@ -111,17 +122,64 @@ func main(){
 	/* TODO: figure out how to handle the root & not found pages */
 	http.HandleFunc("/", root_handler)
 
-	listen_addr := config.ListenAddr()
+	server := &http.Server{ Addr: config.ListenAddr(), Handler: &InterceptHandler{} }
+	servers := []*http.Server{ server }
+	registerMetrics()
+	if config.Get().MetricsAddr != "" {
+		servers = append(servers, serveMetrics(config.Get().MetricsAddr))
+	}
+	handleSignals(servers)
+
+	/* Load everything while listening for requests, so /_status can say
+	 * goiardi's still starting up. */
+	go startUp()
+
 	var err error
-	if config.Config.UseSSL {
-		err = http.ListenAndServeTLS(listen_addr, config.Config.SslCert, config.Config.SslKey, &InterceptHandler{})
+	if conf := config.Get(); conf.UseSSL {
+		if err = serverCert.load(conf.SslCert, conf.SslKey, conf.SslClientCA); err != nil {
+			logger.Criticalf(err.Error())
+			os.Exit(1)
+		}
+		server.TLSConfig = tlsConfig()
+		err = server.ListenAndServeTLS("", "")
 	} else {
-		err = http.ListenAndServe(listen_addr, &InterceptHandler{})
+		err = server.ListenAndServe()
 	}
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		logger.Criticalf("ListenAndServe: %s", err.Error())
 		os.Exit(1)
 	}
+	/* The server's been closed because goiardi's shutting down. Wait for
+	 * that to finish. */
+	<-shutdownDone
+}
+
+func startUp() {
+	ds := data_store.New()
+	if config.Get().FreezeData {
+		setIndexState(indexLoading)
+		if config.Get().DataStoreFile != "" {
+			uerr := ds.Load(config.Get().DataStoreFile)
+			if uerr != nil {
+				logger.Criticalf(uerr.Error())
+				os.Exit(1)
+			}
+		}
+		ierr := indexer.LoadIndex(config.Get().IndexFile)
+		if ierr != nil {
+			logger.Criticalf(ierr.Error())
+			os.Exit(1)
+		}
+	}
+	setIndexState(indexLoaded)
+	setSaveTicker()
+	setPurgeReportsTicker()
+
+	/* Create default clients and users. Currently chef-validator,
+	 * chef-webui, and admin. */
+	createDefaultActors()
+	setReady()
+	logger.Infof("goiardi is ready")
 }
 
 func root_handler(w http.ResponseWriter, r *http.Request){
@@ -134,9 +192,15 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 	 * worked for GETs, but since it was breaking POSTs and screwing with 
 	 * GETs with query params, we just clean up the path and move on. */
 
+	r = withRequestId(r)
+	w.Header().Set("X-Request-Id", requestId(r))
+	/* Use the same config for the whole request, even if it's reloaded
+	 * partway through. */
+	conf := config.Get()
+
 	/* log the URL */
 	// TODO: set this to verbosity level 4 or so
-	logger.Debugf("Serving %s -- %s\n", r.URL.Path, r.Method)
+	logger.Debugf("%sServing %s -- %s\n", logPrefix(r), r.URL.Path, r.Method)
 
 	if r.Method != "CONNECT" { 
 		if p := cleanPath(r.URL.Path); p != r.URL.Path{
@@ -144,6 +208,13 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 		}
 	}
 
+	start := time.Now()
+	sw := &statusWriter{ ResponseWriter: w, status: http.StatusOK }
+	w = sw
+	defer observeRequest(r, requestRoute(r), sw, start)
+	user_id := r.Header.Get("X-OPS-USERID")
+	defer func() { accessLog.log(r, user_id, sw, start) }()
+
 	/* Make configurable, I guess, but Chef wants it to be 1000000 */
 	if r.ContentLength > 1000000 {
 		http.Error(w, "Content-length too long!", http.StatusRequestEntityTooLarge)
@@ -156,44 +227,154 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 	api_info := fmt.Sprintf("flavor=osc;version:%s;goiardi=%s", config.ChefVersion, config.Version)
 	w.Header().Set("X-Ops-API-Info", api_info)
 
-	user_id := r.Header.Get("X-OPS-USERID")
+	if r.URL.Path == "/_status" {
+		http.DefaultServeMux.ServeHTTP(w, r)
+		return
+	}
+	if !isReady() {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "5")
+		JsonErrorReport(w, r, "goiardi is starting up, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	/* The user a web UI request is for, which is only trusted once the
+	 * request's been authenticated as chef-webui. */
+	var web_user string
+	var web_actor actor.Actor
 	if rs := r.Header.Get("X-Ops-Request-Source"); rs == "web" {
 		/* If use-auth is on and disable-webui is on, and this is a
 		 * webui connection, it needs to fail. */
-		if config.Config.DisableWebUI {
+		if conf.DisableWebUI {
 			w.Header().Set("Content-Type", "application/json")
-			logger.Warningf("Attempting to log in through webui, but webui is disabled")
+			logger.Warningf("%sAttempting to log in through webui, but webui is disabled", logPrefix(r))
 			JsonErrorReport(w, r, "invalid action", http.StatusUnauthorized)
 			return
 		}
 
 		/* Check that the user in question with the web request exists.
 		 * If not, fail. */
-		if _, uherr := actor.GetReqUser(user_id); uherr != nil {
+		var uherr util.Gerror
+		if web_actor, uherr = actor.GetReqUser(user_id); uherr != nil {
 			w.Header().Set("Content-Type", "application/json")
-			logger.Warningf("Attempting to use invalid user %s through X-Ops-Request-Source = web", user_id)
+			logger.Warningf("%sAttempting to use invalid user %s through X-Ops-Request-Source = web", logPrefix(r), user_id)
 			JsonErrorReport(w, r, "invalid action", http.StatusUnauthorized)
 			return
 		}
+		web_user = user_id
 		user_id = "chef-webui"
 	}
+
+	/* Requests are rate limited as the actor they were authenticated as,
+	 * or by address if they weren't authenticated, so nobody can use up
+	 * someone else's limit by sending requests in their name. Web UI
+	 * requests are limited as the user they're for. */
+	rl := &conf.RateLimit
+	rate_id := "ip:" + remoteAddr(r)
+	var rate_actor actor.Actor
+
 	/* Only perform the authorization check if that's configured. Bomb with
 	 * an error if the check of the headers, timestamps, etc. fails. */
 	/* No clue why /principals doesn't require authorization. Hrmph. */
-	if config.Config.UseAuth && !strings.HasPrefix(r.URL.Path, "/file_store") && !(strings.HasPrefix(r.URL.Path, "/principals") && r.Method == "GET") {
-		herr := authentication.CheckHeader(user_id, r)
+	if conf.UseAuth && !strings.HasPrefix(r.URL.Path, "/file_store") && !(strings.HasPrefix(r.URL.Path, "/principals") && r.Method == "GET") {
+		/* Checking a signature is expensive, so addresses sending
+		 * requests that fail authentication are limited too. */
+		fail_id := "authfail:" + remoteAddr(r)
+		if wait, ok := checkAuthFailures(rl, fail_id, r); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			JsonErrorReport(w, r, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		var a actor.Actor
+		var herr util.Gerror
+		user_id, a, herr = checkAuth(conf, user_id, r)
 		if herr != nil {
+			rate_limit.Allow(rl, fail_id, rate_limit.Default, 1)
 			w.Header().Set("Content-Type", "application/json")
-			logger.Errorf("Authorization failure: %s\n", herr.Error())
+			logger.Errorf("%sAuthorization failure: %s\n", logPrefix(r), herr.Error())
 			//http.Error(w, herr.Error(), herr.Status())
 			JsonErrorReport(w, r, herr.Error(), herr.Status())
 			return
 		}
+		if web_user != "" {
+			rate_id, rate_actor = web_user, web_actor
+		} else {
+			rate_id, rate_actor = user_id, a
+		}
+	}
+
+	if wait, ok := rateLimit(rl, rate_id, rate_actor, r); !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		JsonErrorReport(w, r, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	/* Saving the data store and index waits for requests that change
+	 * things to finish. */
+	if changesData(r) {
+		dataLock.RLock()
+		defer dataLock.RUnlock()
 	}
 
 	http.DefaultServeMux.ServeHTTP(w, r)
 }
 
+/* Requests are authenticated by their signed headers, or by the name in their
+ * client certificate as well or instead, depending on ssl-client-cert-auth.
+ * Requests authenticated by their certificate alone don't need to send
+ * X-Ops-UserId, so it's set for the handlers. */
+func checkAuth(conf *config.Conf, user_id string, r *http.Request) (string, actor.Actor, util.Gerror) {
+	switch conf.SslClientCertAuth {
+		case "alternative":
+			if cn := authentication.CertName(r); cn != "" && (user_id == "" || user_id == cn) {
+				r.Header.Set("X-Ops-UserId", cn)
+				a, err := authentication.CheckCert(cn, r)
+				return cn, a, err
+			}
+		case "required":
+			if _, err := authentication.CheckCert(user_id, r); err != nil {
+				return user_id, nil, err
+			}
+	}
+	a, err := authentication.CheckHeader(user_id, r)
+	return user_id, a, err
+}
+
+/* Requests are limited as the actor given, by its kind, or by address as a
+ * default actor if there isn't one. */
+func rateLimit(rl *config.RateLimitConf, key string, a actor.Actor, r *http.Request) (time.Duration, bool) {
+	if !rate_limit.Enabled(rl) {
+		return 0, true
+	}
+	kind := rate_limit.Default
+	if a != nil {
+		if a.IsValidator() {
+			kind = rate_limit.Validator
+		} else if a.IsAdmin() {
+			kind = rate_limit.Admin
+		}
+	}
+	wait, ok := rate_limit.Allow(rl, key, kind, rate_limit.Cost(rl, r.Method, r.URL.Path))
+	if !ok {
+		logger.Warningf("%sRate limit exceeded for %s (%s): %s %s", logPrefix(r), key, kind, r.Method, r.URL.Path)
+	}
+	return wait, ok
+}
+
+/* Has the address used up its limit of requests failing authentication? */
+func checkAuthFailures(rl *config.RateLimitConf, key string, r *http.Request) (time.Duration, bool) {
+	if !rate_limit.Enabled(rl) {
+		return 0, true
+	}
+	wait, ok := rate_limit.Check(rl, key, rate_limit.Default)
+	if !ok {
+		logger.Warningf("%sToo many requests failing authentication from %s: %s %s", logPrefix(r), key, r.Method, r.URL.Path)
+	}
+	return wait, ok
+}
+
 func cleanPath(p string) string {
 	/* Borrowing cleanPath from net/http */
 	if p == "" {
@@ -223,8 +404,8 @@ func createDefaultActors() {
 				logger.Criticalf(err.Error())
 				os.Exit(1)
 			}
-			if config.Config.UseAuth {
-				if fp, ferr := os.Create(fmt.Sprintf("%s/%s.pem", config.Config.ConfRoot, webui.Name)); ferr == nil {
+			if config.Get().UseAuth {
+				if fp, ferr := os.Create(fmt.Sprintf("%s/%s.pem", config.Get().ConfRoot, webui.Name)); ferr == nil {
 					fp.Chmod(0600)
 					fp.WriteString(pem)
 					fp.Close()
@@ -249,8 +430,8 @@ func createDefaultActors() {
 				logger.Criticalf(err.Error())
 				os.Exit(1)
 			}
-			if config.Config.UseAuth {
-				if fp, ferr := os.Create(fmt.Sprintf("%s/%s.pem", config.Config.ConfRoot, validator.Name)); ferr == nil {
+			if config.Get().UseAuth {
+				if fp, ferr := os.Create(fmt.Sprintf("%s/%s.pem", config.Get().ConfRoot, validator.Name)); ferr == nil {
 					fp.Chmod(0600)
 					fp.WriteString(pem)
 					fp.Close()
@@ -274,8 +455,8 @@ func createDefaultActors() {
 				logger.Criticalf(err.Error())
 				os.Exit(1)
 			}
-			if config.Config.UseAuth {
-				if fp, ferr := os.Create(fmt.Sprintf("%s/%s.pem", config.Config.ConfRoot, admin.Name)); ferr == nil {
+			if config.Get().UseAuth {
+				if fp, ferr := os.Create(fmt.Sprintf("%s/%s.pem", config.Get().ConfRoot, admin.Name)); ferr == nil {
 					fp.Chmod(0600)
 					fp.WriteString(pem)
 					fp.Close()
@@ -293,7 +474,7 @@ func createDefaultActors() {
 	return
 }
 
-func handleSignals() {
+func handleSignals(servers []*http.Server) {
 	c := make(chan os.Signal, 1)
 	// SIGTERM is not exactly portable, but Go has a fake signal for it
 	// with Windows so it being there should theoretically not break it
@@ -302,32 +483,62 @@ func handleSignals() {
 
 	// if we receive a SIGINT or SIGTERM, do cleanup here.
 	go func(){
+		shuttingDown := false
 		for sig := range c {
 			if sig == os.Interrupt || sig == syscall.SIGTERM{
-				logger.Infof("cleaning up...")
-				if config.Config.FreezeData {
-					if config.Config.DataStoreFile != "" {
-						ds := data_store.New()
-						if err := ds.Save(config.Config.DataStoreFile); err != nil {
-							logger.Errorf(err.Error())
-						}
-					}
-					if err := indexer.SaveIndex(config.Config.IndexFile); err != nil {
-						logger.Errorf(err.Error())
-					}
-				}
-				if config.Config.UseMySQL {
-					data_store.Dbh.Close()
+				/* A second signal means not waiting for the
+				 * graceful shutdown to finish. */
+				if shuttingDown {
+					logger.Warningf("Exiting without finishing shutting down")
+					os.Exit(1)
 				}
-				os.Exit(0)
+				shuttingDown = true
+				go shutdown(servers)
 			} else if sig == syscall.SIGHUP {
 				logger.Infof("Reloading configuration...")
-				config.ParseConfigOptions()
+				reloadConfig()
 			}
 		}
 	}()
 }
 
+/* Reload the config, and apply the settings that can change while goiardi's
+ * running that aren't just read from the config as they're needed. */
+func reloadConfig() {
+	/* The certificate and client CA are read while the new config's being
+	 * checked, so if they're bad the whole reload is refused, and put in
+	 * use once the new config is. */
+	var cert *tls.Certificate
+	var clientCAs *x509.CertPool
+	restart, err := config.Reload(func(conf *config.Conf) error {
+		if !conf.UseSSL {
+			return nil
+		}
+		var cerr error
+		cert, clientCAs, cerr = readCerts(conf.SslCert, conf.SslKey, conf.SslClientCA)
+		if cerr != nil {
+			return fmt.Errorf("Couldn't load the SSL certificate or client CA: %s", cerr.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("Not reloading the configuration: %s", err.Error())
+		return
+	}
+	if len(restart) > 0 {
+		logger.Warningf("Changing %s needs goiardi to be restarted, so the old settings are still being used.", strings.Join(restart, ", "))
+	}
+	setRestartNeeded(restart)
+	conf := config.Get()
+	if cert != nil {
+		serverCert.set(cert, clientCAs)
+	}
+	if err := accessLog.open(conf.AccessLog); err != nil {
+		logger.Errorf("Couldn't open the access log: %s", err.Error())
+	}
+	logger.Infof("Configuration reloaded.")
+}
+
 func gobRegister() {
 	e := new(environment.ChefEnvironment)
 	gob.Register(e)
@@ -365,25 +576,74 @@ func gobRegister() {
 	gob.Register(cc)
 	uu := new(user.User)
 	gob.Register(uu)
+	ns := new(node.StatusHistory)
+	gob.Register(ns)
+	rp := new(report.Report)
+	gob.Register(rp)
+	svs := new(saved_search.SavedSearch)
+	gob.Register(svs)
 }
 
 func setSaveTicker() {
-	ds := data_store.New()
-	ticker := time.NewTicker(time.Second * time.Duration(config.Config.FreezeInterval))
+	ticker := time.NewTicker(time.Second * time.Duration(config.Get().FreezeInterval))
 	go func(){
 		for _ = range ticker.C {
 			logger.Infof("Automatically saving data store...")
-			if config.Config.FreezeData {
-				if config.Config.DataStoreFile != "" {
-					uerr := ds.Save(config.Config.DataStoreFile)
-					if uerr != nil {
-						logger.Errorf(uerr.Error())
-					}
-				}
-				ierr := indexer.SaveIndex(config.Config.IndexFile)
-				if ierr != nil {
-					logger.Errorf(ierr.Error())
-				}
+			if config.Get().FreezeData {
+				saveData()
+			}
+		}
+	}()
+}
+
+/* Freeze the data store and the index to disk together, while no requests
+ * are changing anything, so the index matches the data store. */
+func saveData() {
+	dataLock.Lock()
+	defer dataLock.Unlock()
+	indexer.WaitForIndexing()
+	writeData()
+}
+
+/* Write the data store and the index to disk, timing how long each takes. */
+func writeData() {
+	conf := config.Get()
+	if conf.DataStoreFile != "" {
+		ds := data_store.New()
+		t := time.Now()
+		if err := ds.Save(conf.DataStoreFile); err != nil {
+			logger.Errorf(err.Error())
+			saveFailures.Inc("data_store")
+		} else {
+			saveDuration.Observe(time.Since(t).Seconds(), "data_store")
+			setSaved("data_store", time.Now())
+		}
+	}
+	t := time.Now()
+	if err := indexer.SaveIndex(conf.IndexFile); err != nil {
+		logger.Errorf(err.Error())
+		saveFailures.Inc("index")
+	} else {
+		saveDuration.Observe(time.Since(t).Seconds(), "index")
+		setSaved("index", time.Now())
+	}
+}
+
+/* Remove chef-client run reports older than the retention period once an
+ * hour. */
+func setPurgeReportsTicker() {
+	if config.Get().ReportRetention < 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	go func(){
+		for _ = range ticker.C {
+			before := time.Now().Add(-time.Duration(config.Get().ReportRetention) * 24 * time.Hour)
+			del, err := report.DeleteBefore(before)
+			if err != nil {
+				logger.Errorf(err.Error())
+			} else if del != 0 {
+				logger.Infof("Purged %d old reports", del)
 			}
 		}
 	}()
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/indexer"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+/* goiardi starts listening before it's loaded the data store and index and
+ * made the default clients and users, so /_status can tell load balancers and
+ * the like that it isn't ready yet. Every other request gets a 503 until it
+ * is. */
+
+const (
+	indexWaiting = "waiting"
+	indexLoading = "loading"
+	indexLoaded = "loaded"
+)
+
+var serverState = struct {
+	sync.RWMutex
+	started time.Time
+	ready bool
+	indexState string
+	lastSave map[string]time.Time
+	restartNeeded []string
+}{ started: time.Now(), indexState: indexWaiting, lastSave: make(map[string]time.Time), restartNeeded: []string{} }
+
+func isReady() bool {
+	serverState.RLock()
+	defer serverState.RUnlock()
+	return serverState.ready
+}
+
+func setReady() {
+	serverState.Lock()
+	defer serverState.Unlock()
+	serverState.ready = true
+}
+
+func setIndexState(state string) {
+	serverState.Lock()
+	defer serverState.Unlock()
+	serverState.indexState = state
+}
+
+/* Record when the data store or index was last saved to disk. */
+func setSaved(what string, t time.Time) {
+	serverState.Lock()
+	defer serverState.Unlock()
+	serverState.lastSave[what] = t
+}
+
+/* Record the settings that were changed by reloading the config, but need a
+ * restart to take effect. */
+func setRestartNeeded(settings []string) {
+	serverState.Lock()
+	defer serverState.Unlock()
+	serverState.restartNeeded = settings
+}
+
+type statusCheck struct {
+	OK bool `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func newStatusCheck(err error) *statusCheck {
+	if err != nil {
+		return &statusCheck{ OK: false, Error: err.Error() }
+	}
+	return &statusCheck{ OK: true }
+}
+
+func status_handler(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status, healthy := serverStatus()
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&status); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+/* Gathers goiardi's status, and whether it's ready and all its checks
+ * passed. */
+func serverStatus() (map[string]interface{}, bool) {
+	serverState.RLock()
+	ready := serverState.ready
+	started := serverState.started
+	index := map[string]interface{}{ "state": serverState.indexState, "persisted": config.Get().FreezeData }
+	lastSave := make(map[string]interface{}, 2)
+	for _, k := range []string{ "data_store", "index" } {
+		if t, found := serverState.lastSave[k]; found {
+			lastSave[k] = t
+		} else {
+			lastSave[k] = nil
+		}
+	}
+	restartNeeded := serverState.restartNeeded
+	serverState.RUnlock()
+
+	checks := make(map[string]*statusCheck)
+	if config.Get().UseMySQL {
+		checks["database"] = newStatusCheck(data_store.Dbh.Ping())
+	}
+	if config.Get().LocalFstoreDir != "" {
+		checks["filestore"] = newStatusCheck(checkWritable(config.Get().LocalFstoreDir))
+	}
+	if ready {
+		index["collections"] = len(indexer.Endpoints())
+	}
+
+	healthy := ready
+	for _, c := range checks {
+		healthy = healthy && c.OK
+	}
+	status := "ok"
+	if !ready {
+		status = "starting"
+	} else if !healthy {
+		status = "unhealthy"
+	}
+	mode := "in-memory"
+	if config.Get().UseMySQL {
+		mode = "mysql"
+	}
+	return map[string]interface{}{
+		"status": status,
+		"ready": ready,
+		"mode": mode,
+		"checks": checks,
+		"index": index,
+		"last_save": lastSave,
+		"restart_needed": restartNeeded,
+		"started_at": started,
+		"version": config.Version,
+		"chef_version": config.ChefVersion,
+		"go_version": runtime.Version(),
+	}, healthy
+}
+
+/* Check that files can be made in the directory by making one. */
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".goiardi-status")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package indexer
+
+import (
+	"sort"
+	"strings"
+)
+
+/* Fuzzy and proximity searches can't use the posting lists directly, so they
+ * check each term in the field's term dictionary instead. That's still much
+ * less work than checking every document, since most terms are shared by
+ * many documents. */
+
+func (ic *IdxCollection) termDictFor(field string) *termDict {
+	if field == "" {
+		return ic.text
+	}
+	return ic.fields[field]
+}
+
+func (ic *IdxCollection) searchFuzzy(field string, term string, fuzz float64) map[string]*IdxDoc {
+	ic.m.RLock()
+	defer ic.m.RUnlock()
+	var matches map[string]bool
+	if td := ic.termDictFor(field); td != nil {
+		matches = td.searchFuzzy(term, fuzz)
+	}
+	return ic.results(matches, false)
+}
+
+func (ic *IdxCollection) searchPhrase(field string, phrase string, slop int) map[string]*IdxDoc {
+	ic.m.RLock()
+	defer ic.m.RUnlock()
+	var matches map[string]bool
+	if td := ic.termDictFor(field); td != nil {
+		matches = td.searchPhrase(strings.Fields(phrase), slop)
+	}
+	return ic.results(matches, false)
+}
+
+func (td *termDict) searchFuzzy(term string, fuzz float64) map[string]bool {
+	matches := make(map[string]bool)
+	q := []rune(term)
+	for t := range td.postings {
+		r := []rune(t)
+		maxEdits := allowedEdits(len(q), len(r), fuzz)
+		if len(q) - len(r) > maxEdits || len(r) - len(q) > maxEdits {
+			continue
+		}
+		if levenshtein(q, r, maxEdits) <= maxEdits {
+			for d := range td.postings[t] {
+				matches[d] = true
+			}
+		}
+	}
+	return matches
+}
+
+func (td *termDict) searchPhrase(words []string, slop int) map[string]bool {
+	matches := make(map[string]bool)
+	if len(words) == 0 {
+		return matches
+	}
+	for t := range td.postings {
+		if d, ok := phraseDistance(strings.Fields(t), words); ok && d <= slop {
+			for doc := range td.postings[t] {
+				matches[doc] = true
+			}
+		}
+	}
+	return matches
+}
+
+/* A fuzz factor between 0 and 1 is a minimum similarity like older versions
+ * of Lucene use, where the similarity is 1 minus the edit distance divided by
+ * the length of the shorter term. 1 or more is the maximum edit distance, like
+ * newer versions of Lucene, and 0 only matches the term exactly. */
+func allowedEdits(qlen int, tlen int, fuzz float64) int {
+	if fuzz >= 1 {
+		return int(fuzz)
+	}
+	if fuzz <= 0 {
+		return 0
+	}
+	shortest := qlen
+	if tlen < shortest {
+		shortest = tlen
+	}
+	return int((1 - fuzz) * float64(shortest) + 1e-9)
+}
+
+/* The Levenshtein distance between two strings. Gives up and returns max + 1
+ * once the distance is certain to be larger than max. */
+func levenshtein(a []rune, b []rune, max int) int {
+	prev := make([]int, len(b) + 1)
+	cur := make([]int, len(b) + 1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j] + 1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1] + 1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+type wordOffset struct {
+	offset int
+	word int
+}
+
+type byOffset []wordOffset
+
+func (w byOffset) Len() int { return len(w) }
+func (w byOffset) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+func (w byOffset) Less(i, j int) bool { return w[i].offset < w[j].offset }
+
+/* How far the words of a value are from containing the phrase, measured the
+ * way Lucene does for sloppy phrase queries: each phrase word's position in
+ * the value, less its position in the phrase, should be the same for all of
+ * them if the phrase is there exactly. The distance is how far apart the
+ * closest set of those offsets are. Returns false if any of the phrase's
+ * words aren't in the value at all. */
+func phraseDistance(tokens []string, words []string) (int, bool) {
+	offsets := make([]wordOffset, 0, len(words))
+	for i, w := range words {
+		found := false
+		for p, t := range tokens {
+			if t == w {
+				offsets = append(offsets, wordOffset{ offset: p - i, word: i })
+				found = true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	sort.Sort(byOffset(offsets))
+
+	/* Find the smallest window of offsets with every phrase word in it. */
+	counts := make([]int, len(words))
+	have := 0
+	best := -1
+	lo := 0
+	for hi := range offsets {
+		if counts[offsets[hi].word] == 0 {
+			have++
+		}
+		counts[offsets[hi].word]++
+		for have == len(words) {
+			if d := offsets[hi].offset - offsets[lo].offset; best == -1 || d < best {
+				best = d
+			}
+			counts[offsets[lo].word]--
+			if counts[offsets[lo].word] == 0 {
+				have--
+			}
+			lo++
+		}
+	}
+	return best, true
+}
@@ -21,8 +21,8 @@ package indexer
 
 import (
 	"github.com/ctdk/go-trie/gtrie"
-	"git.tideland.biz/goas/logger"
 	"sync"
+	"sync/atomic"
 	"strings"
 	"sort"
 	"fmt"
@@ -49,17 +49,53 @@ type Index struct {
 	idxmap map[string]*IdxCollection
 }
 
-// Holds a map of documents.
+// Holds a map of documents, and an inverted index of the terms in them.
 type IdxCollection struct {
 	m sync.RWMutex
 	docs map[string]*IdxDoc
+	fields map[string]*termDict
+	text *termDict
+	gen uint64
 }
 
-// The indexed documents that are actually searched.
+// The indexed documents. The document's terms are kept so they can be removed
+// from the collection's inverted index when the document is updated or
+// deleted.
 type IdxDoc struct {
-	m sync.RWMutex
-	trie *gtrie.Node
-	docText string
+	terms []string
+	lens map[string]int
+}
+
+// Statistics about a field in an index collection, for scoring search results.
+type FieldStats struct {
+	// The number of documents in the collection.
+	NumDocs int
+	// The number of documents with the field.
+	FieldDocs int
+	// The average number of terms in the field, among the documents that
+	// have it.
+	AvgLen float64
+}
+
+/* A term dictionary, mapping each term to a posting list of the ids of the
+ * documents that contain it. The terms are also kept in a sorted slice, so
+ * prefix, wildcard, and range searches only need to look at the part of the
+ * dictionary that can match. Keeping the slice sorted as each term is added
+ * would make building the index quadratic, so new terms are put aside and
+ * merged in the next time the sorted terms are needed, and removed terms are
+ * dropped then too. Searches only hold the collection's read lock, so sorting
+ * has its own lock. */
+type termDict struct {
+	postings map[string]map[string]bool
+	sortLock sync.Mutex
+	terms []string
+	added []string
+	removed bool
+	nums []numTerm
+	addedNums []numTerm
+	removedNums bool
+	docCount int
+	totalLen int
 }
 
 /* Index methods */
@@ -75,9 +111,11 @@ func CreateNewCollection(idxName string) {
 // Delete a collection from the index. Useful only for data bags.
 func DeleteCollection(idxName string) error {
 	/* Don't try and delete built-in indexes */
-	if idxName == "node" || idxName == "client" || idxName == "environment" || idxName == "role" {
-		err := fmt.Errorf("%s is a default search index, cannot be deleted.", idxName)
-		return err
+	for _, d := range defaultCollections {
+		if idxName == d {
+			err := fmt.Errorf("%s is a default search index, cannot be deleted.", idxName)
+			return err
+		}
 	}
 	indexMap.deleteCollection(idxName)
 	return nil
@@ -96,8 +134,7 @@ func (i *Index) createCollection(idxName string) {
 	 * the index collection had a new index collection created under it,
 	 * so only make a new one if it doesn't exist. */
 	if _, ok := i.idxmap[idxName]; !ok {
-		i.idxmap[idxName] = new(IdxCollection)
-		i.idxmap[idxName].docs = make(map[string]*IdxDoc)
+		i.idxmap[idxName] = newIdxCollection()
 	}
 }
 
@@ -107,40 +144,50 @@ func (i *Index) deleteCollection(idxName string) {
 	delete(i.idxmap, idxName)
 }
 
+func (i *Index) collection(idxName string) (*IdxCollection, bool) {
+	i.m.RLock()
+	defer i.m.RUnlock()
+	idc, found := i.idxmap[idxName]
+	return idc, found
+}
+
 func (i *Index) saveIndex(object Indexable) {
 	/* Have to check to see if data bag indexes exist */
-	if _, found := i.idxmap[object.Index()]; !found {
+	idc, found := i.collection(object.Index())
+	if !found {
 		i.createCollection(object.Index())
+		idc, _ = i.collection(object.Index())
 	}
-	i.idxmap[object.Index()].addDoc(object)
+	idc.addDoc(object)
 }
 
 func (i *Index) deleteItem(idxName string, doc string) error {
-	if _, found := i.idxmap[idxName]; !found {
+	idc, found := i.collection(idxName)
+	if !found {
 		err := fmt.Errorf("Index collection %s not found", idxName)
 		return err
 	}
-	i.idxmap[idxName].delDoc(doc)
+	idc.delDoc(doc)
 	return nil
 }
 
 func (i *Index) search(idx string, term string, notop bool) (map[string]*IdxDoc, error){
-	if idc, found := i.idxmap[idx]; !found {
+	if idc, found := i.collection(idx); !found {
 		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
 		return nil, err
 	} else {
 		// Special case - if term is '*:*', just return all of the
 		// keys
 		if term == "*:*" {
-			return idc.docs, nil
-		} 
+			return idc.allDocs(), nil
+		}
 		results, err := idc.searchCollection(term, notop)
 		return results, err
 	}
 }
 
 func (i *Index) searchText(idx string, term string, notop bool) (map[string]*IdxDoc, error) {
-	if idc, found := i.idxmap[idx]; !found {
+	if idc, found := i.collection(idx); !found {
 		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
 		return nil, err
 	} else {
@@ -150,7 +197,7 @@ func (i *Index) searchText(idx string, term string, notop bool) (map[string]*Idx
 }
 
 func (i *Index) searchRange(idx string, field string, start string, end string, inclusive bool) (map[string]*IdxDoc, error){
-	if idc, found := i.idxmap[idx]; !found {
+	if idc, found := i.collection(idx); !found {
 		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
 		return nil, err
 	} else {
@@ -159,6 +206,42 @@ func (i *Index) searchRange(idx string, field string, start string, end string,
 	}
 }
 
+func (i *Index) searchFuzzy(idx string, field string, term string, fuzz float64) (map[string]*IdxDoc, error) {
+	if idc, found := i.collection(idx); !found {
+		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
+		return nil, err
+	} else {
+		return idc.searchFuzzy(field, term, fuzz), nil
+	}
+}
+
+func (i *Index) searchPhrase(idx string, field string, phrase string, slop int) (map[string]*IdxDoc, error) {
+	if idc, found := i.collection(idx); !found {
+		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
+		return nil, err
+	} else {
+		return idc.searchPhrase(field, phrase, slop), nil
+	}
+}
+
+func (i *Index) fieldStats(idx string, field string) (*FieldStats, error) {
+	if idc, found := i.collection(idx); !found {
+		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
+		return nil, err
+	} else {
+		return idc.fieldStats(field), nil
+	}
+}
+
+func (i *Index) generation(idx string) (uint64, error) {
+	if idc, found := i.collection(idx); !found {
+		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
+		return 0, err
+	} else {
+		return idc.generation(), nil
+	}
+}
+
 func (i *Index) endpoints() []string {
 	i.m.RLock()
 	defer i.m.RUnlock()
@@ -174,216 +257,409 @@ func (i *Index) endpoints() []string {
 	return endpoints
 }
 
+func (i *Index) collectionSizes() map[string]int {
+	i.m.RLock()
+	defer i.m.RUnlock()
+	sizes := make(map[string]int, len(i.idxmap))
+	for k, ic := range i.idxmap {
+		ic.m.RLock()
+		sizes[k] = len(ic.docs)
+		ic.m.RUnlock()
+	}
+	return sizes
+}
+
 /* IdxCollection methods */
 
+func newIdxCollection() *IdxCollection {
+	ic := new(IdxCollection)
+	ic.docs = make(map[string]*IdxDoc)
+	ic.fields = make(map[string]*termDict)
+	ic.text = newTermDict()
+	ic.gen = nextGeneration()
+	return ic
+}
+
 func (ic *IdxCollection) addDoc(object Indexable) {
-	if _, found := ic.docs[object.DocId()]; !found {
-		ic.m.Lock()
-		ic.docs[object.DocId()] = new(IdxDoc)
-		ic.m.Unlock()
+	terms := object.Flatten()
+	docId := object.DocId()
+	ic.m.Lock()
+	defer ic.m.Unlock()
+	if idoc, found := ic.docs[docId]; found {
+		ic.unindexDoc(docId, idoc)
 	}
-	ic.docs[object.DocId()].update(object)
+	/* Search results hold on to the documents, so an updated document
+	 * replaces the old one rather than changing it. */
+	idoc := &IdxDoc{ terms: terms }
+	ic.docs[docId] = idoc
+	ic.indexDoc(docId, idoc)
+	ic.gen = nextGeneration()
 }
 
 func (ic *IdxCollection) delDoc(doc string) {
 	ic.m.Lock()
 	defer ic.m.Unlock()
-	
-	delete(ic.docs, doc)
+
+	if idoc, found := ic.docs[doc]; found {
+		ic.unindexDoc(doc, idoc)
+		delete(ic.docs, doc)
+		ic.gen = nextGeneration()
+	}
 }
 
-/* Search for an exact key/value match */
-func (ic *IdxCollection) searchCollection(term string, notop bool) (map[string]*IdxDoc, error) {
-	results := make(map[string]*IdxDoc)
+func (ic *IdxCollection) generation() uint64 {
 	ic.m.RLock()
 	defer ic.m.RUnlock()
-	for k, v := range ic.docs {
-		m, err := v.Examine(term)
-		if err != nil {
-			return nil, err
+	return ic.gen
+}
+
+/* Each change to an index collection gives it a new generation number, so
+ * anything holding on to search results can tell when they might be out of
+ * date. The numbers are shared by all the collections, so a collection that's
+ * been deleted and made again, or loaded from disk, can't be mistaken for the
+ * old one. */
+var lastGeneration uint64
+
+func nextGeneration() uint64 {
+	return atomic.AddUint64(&lastGeneration, 1)
+}
+
+/* Add a document's terms to the inverted index. Each flattened term is
+ * "field:value", and goes in that field's term dictionary. The text dictionary
+ * gets whatever follows each colon in the term, which is what a search without
+ * a field matches against. How many terms the document has in each field is
+ * counted too, for scoring search results. */
+func (ic *IdxCollection) indexDoc(docId string, idoc *IdxDoc) {
+	idoc.lens = make(map[string]int)
+	for _, t := range idoc.terms {
+		z := strings.SplitN(t, ":", 2)
+		if len(z) != 2 {
+			continue
+		}
+		td, found := ic.fields[z[0]]
+		if !found {
+			td = newTermDict()
+			ic.fields[z[0]] = td
 		}
-		if (m && !notop) || (!m && notop) {
-			results[k] = v
+		td.add(z[1], docId)
+		idoc.lens[z[0]]++
+		for _, txt := range textTerms(t) {
+			ic.text.add(txt, docId)
+			idoc.lens[""]++
 		}
 	}
-	return results, nil
+	for f, n := range idoc.lens {
+		td := ic.termDictFor(f)
+		td.docCount++
+		td.totalLen += n
+	}
 }
 
-func (ic *IdxCollection) searchTextCollection(term string, notop bool) (map[string]*IdxDoc, error) {
-	results := make(map[string]*IdxDoc)
-	ic.m.RLock()
-	defer ic.m.RUnlock()
-	for k, v := range ic.docs {
-		m, err := v.TextSearch(term)
-		if err != nil {
-			return nil, err
+func (ic *IdxCollection) unindexDoc(docId string, idoc *IdxDoc) {
+	for f, n := range idoc.lens {
+		if td := ic.termDictFor(f); td != nil {
+			td.docCount--
+			td.totalLen -= n
+		}
+	}
+	for _, t := range idoc.terms {
+		z := strings.SplitN(t, ":", 2)
+		if len(z) != 2 {
+			continue
+		}
+		if td, found := ic.fields[z[0]]; found {
+			td.remove(z[1], docId)
+			if td.empty() {
+				delete(ic.fields, z[0])
+			}
 		}
-		if (m && !notop) || (!m && notop) {
-			results[k] = v
+		for _, txt := range textTerms(t) {
+			ic.text.remove(txt, docId)
 		}
 	}
-	return results, nil
 }
 
-func (ic *IdxCollection) searchRange(field string, start string, end string, inclusive bool) (map[string]*IdxDoc, error) {
-	results := make(map[string]*IdxDoc)
+func (ic *IdxCollection) reindexDocs() {
+	ic.fields = make(map[string]*termDict)
+	ic.text = newTermDict()
+	for k, v := range ic.docs {
+		ic.indexDoc(k, v)
+	}
+}
+
+func (ic *IdxCollection) allDocs() map[string]*IdxDoc {
 	ic.m.RLock()
 	defer ic.m.RUnlock()
-	
+	results := make(map[string]*IdxDoc, len(ic.docs))
 	for k, v := range ic.docs {
-		m, err := v.RangeSearch(field, start, end, inclusive)
-		if err != nil {
-			return nil, err
-		}
-		if m {
-			results[k] = v
-		}
+		results[k] = v
 	}
-	return results, nil
+	return results
 }
 
-/* IdxDoc methods */
-func (idoc *IdxDoc) update(object Indexable) {
-	idoc.m.Lock()
-	defer idoc.m.Unlock()
-	flattened := object.Flatten()
-	flatText := strings.Join(flattened, "\n")
-	/* recover from horrific trie errors that seem to happen with really
-	 * big values. :-/ */
-	defer func() {
-		if e:= recover(); e != nil {
-			logger.Errorf("There was a problem creating the trie: %s", fmt.Sprintln(e))
+func (ic *IdxCollection) fieldStats(field string) *FieldStats {
+	ic.m.RLock()
+	defer ic.m.RUnlock()
+	stats := &FieldStats{ NumDocs: len(ic.docs) }
+	if td := ic.termDictFor(field); td != nil && td.docCount > 0 {
+		stats.FieldDocs = td.docCount
+		stats.AvgLen = float64(td.totalLen) / float64(td.docCount)
+	}
+	return stats
+}
+
+/* Turn the matching doc ids from the inverted index into the results map, or
+ * the documents that didn't match if notop is set. Must be called with the
+ * collection locked. */
+func (ic *IdxCollection) results(matches map[string]bool, notop bool) map[string]*IdxDoc {
+	var results map[string]*IdxDoc
+	if notop {
+		results = make(map[string]*IdxDoc, len(ic.docs))
+		for k, v := range ic.docs {
+			if !matches[k] {
+				results[k] = v
+			}
 		}
-	}()
-	trie, err := gtrie.Create(flattened)
-	if err != nil {
-		logger.Errorf(err.Error())
 	} else {
-		idoc.trie = trie
-		idoc.docText = flatText
+		results = make(map[string]*IdxDoc, len(matches))
+		for k := range matches {
+			results[k] = ic.docs[k]
+		}
 	}
+	return results
 }
 
-// Searches a document, determining if it needs to do a search for an exact term
-// or a regexp search.
-func (idoc *IdxDoc) Examine(term string) (bool, error) {
-	idoc.m.RLock()
-	defer idoc.m.RUnlock()
-	
-	r := regexp.MustCompile(`\*|\?`)
-	if r.MatchString(term) {
-		m, err := idoc.regexSearch(term)
-		return m, err
-	} else {
-		m := idoc.exactSearch(term)
-		return m, nil
+/* Search for an exact key/value match, or a wildcard match if the value has a
+ * '*' or '?' in it. */
+func (ic *IdxCollection) searchCollection(term string, notop bool) (map[string]*IdxDoc, error) {
+	z := strings.SplitN(term, ":", 2)
+	if len(z) != 2 {
+		err := fmt.Errorf("Search term %s has no field", term)
+		return nil, err
+	}
+	ic.m.RLock()
+	defer ic.m.RUnlock()
+	var matches map[string]bool
+	if td, found := ic.fields[z[0]]; found {
+		var err error
+		matches, err = td.search(z[1])
+		if err != nil {
+			return nil, err
+		}
 	}
+	return ic.results(matches, notop), nil
 }
 
-// Perform a text search of an index document.
-func (idoc *IdxDoc) TextSearch(term string) (bool, error) {
-	if term[0] == '*' || term[0] == '?' {
+func (ic *IdxCollection) searchTextCollection(term string, notop bool) (map[string]*IdxDoc, error) {
+	if term == "" || term[0] == '*' || term[0] == '?' {
 		err := fmt.Errorf("Can't start a term with a wildcard character")
-		return false, err
+		return nil, err
 	}
-	term = strings.Replace(term, "*", ".*", -1)
-	term = strings.Replace(term, "?", ".?", -1)
-	re := fmt.Sprintf("(?m):%s$", term)
-	reComp, err := regexp.Compile(re)
+	ic.m.RLock()
+	defer ic.m.RUnlock()
+	matches, err := ic.text.search(term)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	idoc.m.RLock()
-	defer idoc.m.RUnlock()
-	m := reComp.MatchString(idoc.docText)
-	return m, nil
+	return ic.results(matches, notop), nil
 }
 
-// Searches for a range of values.
-func (idoc *IdxDoc) RangeSearch(field string, start string, end string, inclusive bool) (bool, error) {
+func (ic *IdxCollection) searchRange(field string, start string, end string, inclusive bool) (map[string]*IdxDoc, error) {
 	// The parser should catch a lot of possible errors, happily
 
 	// "*" is permitted as a range that indicates anything bigger or smaller
 	// than the other range, depending
-	wildStart := false
-	wildEnd := false
-	if start == "*" {
-		wildStart = true
+	if start == "*" && end == "*" {
+		err := fmt.Errorf("you can't have both start and end be wild in a range search, sadly")
+		return nil, err
 	}
-	if end == "*" {
-		wildEnd = true
+	ic.m.RLock()
+	defer ic.m.RUnlock()
+	var matches map[string]bool
+	if td, found := ic.fields[field]; found {
+		matches = td.searchRange(start, end, inclusive)
+	}
+	return ic.results(matches, false), nil
+}
+
+/* Every flattened term is searched by the part after each colon in it, so a
+ * value with escaped colons like "recipe\:\:default" can be found with a text
+ * search for "default" as well. */
+func textTerms(term string) []string {
+	txt := make([]string, 0, 1)
+	for i := strings.Index(term, ":"); i != -1; {
+		txt = append(txt, term[i+1:])
+		n := strings.Index(term[i+1:], ":")
+		if n == -1 {
+			break
+		}
+		i += n + 1
 	}
-	if wildStart && wildEnd {
-		err := fmt.Errorf("you can't have both start and end be wild in a range search, sadly")
-		return false, err
-	}
-	idoc.m.RLock()
-	defer idoc.m.RUnlock()
-	key := fmt.Sprintf("%s:", field)
-	if n, _ := idoc.trie.HasPrefix(key); n != nil {
-		kids := n.ChildKeys()
-		for _, child := range kids {
-			if inclusive {
-				if wildStart {
-					if child <= end {
-						return true, nil
-					}
-				} else if wildEnd {
-					if child >= start {
-						return true, nil
-					}
-				} else {
-					if child >= start && child <= end {
-						return true, nil
-					}
-				}
-			} else {
-				if wildStart {
-					if child < end {
-						return true, nil
-					}
-				} else if wildEnd {
-					if child > start {
-						return true, nil
-					}
-				} else {
-					if child > start && child < end {
-						return true, nil
-					}
-				}
-			}
+	return txt
+}
+
+/* termDict methods */
+
+func newTermDict() *termDict {
+	td := new(termDict)
+	td.postings = make(map[string]map[string]bool)
+	td.terms = make([]string, 0)
+	td.added = make([]string, 0)
+	td.nums = make([]numTerm, 0)
+	td.addedNums = make([]numTerm, 0)
+	return td
+}
+
+func (td *termDict) add(term string, docId string) {
+	p, found := td.postings[term]
+	if !found {
+		p = make(map[string]bool)
+		td.postings[term] = p
+		td.added = append(td.added, term)
+		if nt, ok := parseNumTerm(term); ok {
+			td.addedNums = append(td.addedNums, nt)
 		}
 	}
-	return false, nil
+	p[docId] = true
+}
+
+func (td *termDict) remove(term string, docId string) {
+	p, found := td.postings[term]
+	if !found {
+		return
+	}
+	delete(p, docId)
+	if len(p) == 0 {
+		delete(td.postings, term)
+		td.removed = true
+		td.removedNums = true
+	}
 }
 
-func (idoc *IdxDoc) exactSearch(term string) bool {
-	return idoc.trie.Accepts(term)
+func (td *termDict) empty() bool {
+	return len(td.postings) == 0
 }
 
-func (idoc *IdxDoc) regexSearch(reTerm string) (bool, error) {
-	z := strings.SplitN(reTerm, ":", 2)
-	key := fmt.Sprintf("%s:", z[0])
-	re := z[1]
-	/* Must add . before any * or ? in the regexp first. Taking the easy way
-	 * out and using strings.Replace. */
-	re = strings.Replace(re, "*", ".*", -1)
-	re = strings.Replace(re, "?", ".?", -1)
-	reComp, err := regexp.Compile(re)
+/* The dictionary's terms, sorted. Terms added since they were last sorted are
+ * sorted by themselves and merged in, leaving out the ones that have been
+ * removed, so this is linear in the size of the dictionary if only a few terms
+ * have changed. */
+func (td *termDict) sortedTerms() []string {
+	td.sortLock.Lock()
+	defer td.sortLock.Unlock()
+	if len(td.added) == 0 && !td.removed {
+		return td.terms
+	}
+	sort.Strings(td.added)
+	merged := make([]string, 0, len(td.postings))
+	i, j := 0, 0
+	for i < len(td.terms) || j < len(td.added) {
+		var t string
+		if j == len(td.added) || (i < len(td.terms) && td.terms[i] <= td.added[j]) {
+			t = td.terms[i]
+			i++
+		} else {
+			t = td.added[j]
+			j++
+		}
+		/* A term can be in both if it was removed and added again. */
+		if _, found := td.postings[t]; found && (len(merged) == 0 || merged[len(merged) - 1] != t) {
+			merged = append(merged, t)
+		}
+	}
+	td.terms = merged
+	td.added = make([]string, 0)
+	td.removed = false
+	return td.terms
+}
+
+/* Find the documents with the given term, or with a term matching it if it
+ * has wildcards. */
+func (td *termDict) search(term string) (map[string]bool, error) {
+	if !strings.ContainsAny(term, "*?") {
+		return td.postings[term], nil
+	}
+	prefix, reComp, err := wildcardRegexp(term)
 	if err != nil {
-		return false, err
-	}
-	/* What would be better would be to fetch all of the parts of the key
-	 * before the regexp part starts. Hmmm. */
-	if n, _ := idoc.trie.HasPrefix(key); n != nil {
-		kids := n.ChildKeys()
-		for _, c := range kids {
-			if reComp.MatchString(c) {
-				return true, nil
+		return nil, err
+	}
+	matches := make(map[string]bool)
+	terms := td.sortedTerms()
+	/* Only the terms starting with the part of the search term before the
+	 * first wildcard need to be checked. */
+	for i := sort.SearchStrings(terms, prefix); i < len(terms) && strings.HasPrefix(terms[i], prefix); i++ {
+		if reComp == nil || reComp.MatchString(terms[i]) {
+			for d := range td.postings[terms[i]] {
+				matches[d] = true
+			}
+		}
+	}
+	return matches, nil
+}
+
+/* Find the documents with terms between start and end. If the bounds are both
+ * numbers (or dates), terms are compared by their numeric values; otherwise
+ * they're compared as strings. */
+func (td *termDict) searchRange(start string, end string, inclusive bool) map[string]bool {
+	if matches, ok := td.searchNumRange(start, end, inclusive); ok {
+		return matches
+	}
+	terms := td.sortedTerms()
+	lo := 0
+	hi := len(terms)
+	if start != "*" {
+		lo = sort.SearchStrings(terms, start)
+		if !inclusive && lo < hi && terms[lo] == start {
+			lo++
+		}
+	}
+	if end != "*" {
+		hi = sort.SearchStrings(terms, end)
+		if inclusive && hi < len(terms) && terms[hi] == end {
+			hi++
+		}
+	}
+	matches := make(map[string]bool)
+	for i := lo; i < hi; i++ {
+		for d := range td.postings[terms[i]] {
+			matches[d] = true
+		}
+	}
+	return matches
+}
+
+/* Make a regexp out of a search term with wildcards. '*' matches any number of
+ * characters, and '?' matches exactly one. Everything else is matched
+ * literally, and the whole term has to match. Also returns the literal prefix
+ * before the first wildcard. If the only wildcard is a trailing '*', the
+ * prefix is enough by itself and the regexp is nil. */
+func wildcardRegexp(term string) (string, *regexp.Regexp, error) {
+	w := strings.IndexAny(term, "*?")
+	prefix := term[:w]
+	if w == len(term) - 1 && term[w] == '*' {
+		return prefix, nil, nil
+	}
+	var re bytes.Buffer
+	re.WriteString("(?s)^")
+	lit := 0
+	for i, c := range term {
+		if c == '*' || c == '?' {
+			re.WriteString(regexp.QuoteMeta(term[lit:i]))
+			if c == '*' {
+				re.WriteString(".*")
+			} else {
+				re.WriteString(".")
 			}
+			lit = i + 1
 		}
 	}
-	return false, nil
+	re.WriteString(regexp.QuoteMeta(term[lit:]))
+	re.WriteString("$")
+	reComp, err := regexp.Compile(re.String())
+	if err != nil {
+		return "", nil, err
+	}
+	return prefix, reComp, nil
 }
 
 var indexMap = initializeIndex()
@@ -396,19 +672,35 @@ func initializeIndex() *Index {
 	return im
 }
 
+/* The index collections that always exist, as opposed to the data bag
+ * collections. */
+var defaultCollections = []string{ "client", "cookbook", "environment", "node", "role", "user" }
+
 func (i *Index) makeDefaultCollections() {
-	defaults := [...]string{ "client", "environment", "node", "role" }
 	i.m.Lock()
 	i.idxmap = make(map[string]*IdxCollection)
 	i.m.Unlock()
-	for _, d := range defaults {
+	for _, d := range defaultCollections {
 		i.createCollection(d)
 	}
 }
 
+/* Objects are indexed in the background, but saving the index has to be able
+ * to wait for them. */
+var indexing sync.WaitGroup
+
 //Process and add an object to the index.
 func IndexObj(object Indexable) {
-	go indexMap.saveIndex(object)
+	indexing.Add(1)
+	go func() {
+		defer indexing.Done()
+		indexMap.saveIndex(object)
+	}()
+}
+
+// Wait for any objects being indexed in the background to be finished.
+func WaitForIndexing() {
+	indexing.Wait()
 }
 
 //Search for a string in the given index. Returns a slice of names of matching
@@ -430,12 +722,53 @@ func SearchRange(idxName string, field string, start string, end string, inclusi
 	return res, err
 }
 
+// Perform a fuzzy search on the given index, for terms within an edit distance
+// of the search term. A fuzz factor below 1 is the minimum similarity between
+// the terms, 1 or more is the maximum number of edits, and 0 only matches the
+// term exactly. An empty field searches the text of the objects.
+func SearchFuzzy(idxName string, field string, term string, fuzz float64) (map[string]*IdxDoc, error) {
+	res, err := indexMap.searchFuzzy(idxName, field, term, fuzz)
+	return res, err
+}
+
+// Perform a proximity search on the given index, for values with the words of
+// the phrase no more than slop positions out of place. An empty field searches
+// the text of the objects.
+func SearchPhrase(idxName string, field string, phrase string, slop int) (map[string]*IdxDoc, error) {
+	res, err := indexMap.searchPhrase(idxName, field, phrase, slop)
+	return res, err
+}
+
+// Get statistics about a field in the given index, for scoring search results.
+// An empty field gives statistics about the text of the objects.
+func GetFieldStats(idxName string, field string) (*FieldStats, error) {
+	stats, err := indexMap.fieldStats(idxName, field)
+	return stats, err
+}
+
+// The number of terms the document has in the given field. An empty field
+// gives the number of terms in the document's text.
+func (i *IdxDoc) FieldLen(field string) int {
+	return i.lens[field]
+}
+
+// The generation of the given index collection, which changes every time an
+// object is added to or removed from it.
+func Generation(idxName string) (uint64, error) {
+	return indexMap.generation(idxName)
+}
+
 // Return a list of currently indexed endpoints
 func Endpoints() []string {
 	endpoints := indexMap.endpoints()
 	return endpoints
 }
 
+// The number of documents in each index collection.
+func CollectionSizes() map[string]int {
+	return indexMap.collectionSizes()
+}
+
 // Save the index files to disk.
 func SaveIndex(idxFile string) error {
 	return indexMap.save(idxFile)
@@ -481,19 +814,21 @@ func (i *IdxCollection) GobEncode() ([]byte, error){
 func (i *IdxCollection) GobDecode(buf []byte) error {
 	r := bytes.NewBuffer(buf)
 	decoder := gob.NewDecoder(r)
-	return decoder.Decode(&i.docs)
+	err := decoder.Decode(&i.docs)
+	if err != nil {
+		return err
+	}
+	/* The inverted index isn't saved with the documents, since it can be
+	 * rebuilt from their terms. */
+	i.reindexDocs()
+	i.gen = nextGeneration()
+	return nil
 }
 
 func (i *IdxDoc) GobEncode() ([]byte, error){
 	w := new(bytes.Buffer)
 	encoder := gob.NewEncoder(w)
-	i.m.RLock()
-	defer i.m.RUnlock()
-	err := encoder.Encode(i.trie)
-	if err != nil {
-		return nil, err
-	}
-	err = encoder.Encode(i.docText)
+	err := encoder.Encode(i.terms)
 	if err != nil {
 		return nil, err
 	}
@@ -503,11 +838,24 @@ func (i *IdxDoc) GobEncode() ([]byte, error){
 func (i *IdxDoc) GobDecode(buf []byte) error {
 	r := bytes.NewBuffer(buf)
 	decoder := gob.NewDecoder(r)
-	err := decoder.Decode(&i.trie)
-	if err != nil {
+	err := decoder.Decode(&i.terms)
+	if err == nil {
+		return nil
+	}
+	/* Index files saved by older versions of goiardi have a trie and the
+	 * document's terms joined together with newlines instead. */
+	r = bytes.NewBuffer(buf)
+	decoder = gob.NewDecoder(r)
+	var trie *gtrie.Node
+	if terr := decoder.Decode(&trie); terr != nil {
 		return err
 	}
-	return decoder.Decode(&i.docText)
+	var docText string
+	if terr := decoder.Decode(&docText); terr != nil {
+		return terr
+	}
+	i.terms = strings.Split(docText, "\n")
+	return nil
 }
 
 func (i *Index) save(idxFile string) error {
@@ -561,6 +909,11 @@ func (i *Index) load(idxFile string) error {
 		fp.Close()
 		return err
 	}
+	/* Index files saved before a default collection was added won't
+	 * have it. */
+	for _, d := range defaultCollections {
+		i.createCollection(d)
+	}
 	return fp.Close()
 }
 
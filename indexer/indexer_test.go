@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"io/ioutil"
+	"strings"
 )
 
 type testObj struct {
@@ -50,6 +51,39 @@ func TestIndexObj(t *testing.T){
 	IndexObj(obj)
 }
 
+type sizeObj struct {
+	testObj
+}
+
+func (so *sizeObj) Index() string {
+	return "size_test"
+}
+
+func TestCollectionSizes(t *testing.T) {
+	for _, n := range []string{ "a", "b", "c" } {
+		indexMap.saveIndex(&sizeObj{ testObj{ Name: n } })
+	}
+	sizes := CollectionSizes()
+	if sizes["size_test"] != 3 {
+		t.Errorf("expected 3 documents in size_test, got %d", sizes["size_test"])
+	}
+	if _, found := sizes["node"]; !found {
+		t.Errorf("the default node collection wasn't listed: %v", sizes)
+	}
+	DeleteCollection("size_test")
+}
+
+func TestWaitForIndexing(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		IndexObj(&sizeObj{ testObj{ Name: fmt.Sprintf("w%d", i) } })
+	}
+	WaitForIndexing()
+	if n := CollectionSizes()["size_test"]; n != 50 {
+		t.Errorf("expected 50 documents to be indexed after waiting, got %d", n)
+	}
+	DeleteCollection("size_test")
+}
+
 var idxTmpDir = idxTmpGen()
 
 func idxTmpGen() string {
@@ -100,8 +134,211 @@ func TestSearchObjLoad(t *testing.T) {
 	}
 }
 
+func TestInvertedIndex(t *testing.T) {
+	ic := newIdxCollection()
+	ic.addDoc(&testObj{ Name: "web1", UrlType: "node", RunList: []string{ "recipe[nginx::default]" } })
+	ic.addDoc(&testObj{ Name: "web2", UrlType: "node" })
+	ic.addDoc(&testObj{ Name: "db1", UrlType: "node" })
+
+	tests := []struct{
+		term string
+		notop bool
+		expected int
+	}{
+		{ "name:web1", false, 1 },
+		{ "name:web*", false, 2 },
+		{ "name:*1", false, 2 },
+		{ "name:w?b2", false, 1 },
+		{ "name:eb*", false, 0 },
+		{ "name:web1", true, 2 },
+		{ "url_type:node", false, 3 },
+		{ "nope:web1", false, 0 },
+	}
+	for _, x := range tests {
+		res, err := ic.searchCollection(x.term, x.notop)
+		if err != nil {
+			t.Errorf("searching for %s gave an error: %s", x.term, err)
+		} else if len(res) != x.expected {
+			t.Errorf("searching for %s (notop %v) found %d documents, expected %d", x.term, x.notop, len(res), x.expected)
+		}
+	}
+
+	res, _ := ic.searchTextCollection("default", false)
+	if _, found := res["web1"]; !found || len(res) != 1 {
+		t.Errorf("text search for 'default' found %v", res)
+	}
+	res, _ = ic.searchRange("name", "db1", "web1", true)
+	if len(res) != 2 {
+		t.Errorf("inclusive range search found %d documents, expected 2", len(res))
+	}
+	res, _ = ic.searchRange("name", "db1", "web2", false)
+	if _, found := res["web1"]; !found || len(res) != 1 {
+		t.Errorf("exclusive range search found %v", res)
+	}
+
+	/* Updating and deleting documents has to take their old terms out of
+	 * the index. */
+	ic.addDoc(&testObj{ Name: "web1", UrlType: "client" })
+	res, _ = ic.searchCollection("url_type:node", false)
+	if len(res) != 2 {
+		t.Errorf("updated document still matched its old terms")
+	}
+	ic.delDoc("web2")
+	res, _ = ic.searchCollection("name:web*", false)
+	if _, found := res["web2"]; found || len(res) != 1 {
+		t.Errorf("deleted document still found: %v", res)
+	}
+	if _, found := ic.fields["run_list"]; found {
+		t.Errorf("empty field was not removed from the index")
+	}
+}
+
+func TestTermDictSorting(t *testing.T) {
+	td := newTermDict()
+	for _, term := range []string{ "pear", "apple", "fig", "banana" } {
+		td.add(term, "doc1")
+	}
+	td.add("apple", "doc2")
+	if got := strings.Join(td.sortedTerms(), " "); got != "apple banana fig pear" {
+		t.Errorf("expected sorted terms 'apple banana fig pear', got '%s'", got)
+	}
+	/* Removed terms drop out, and a term that's removed and added again
+	 * between searches is only there once. */
+	td.remove("fig", "doc1")
+	td.remove("pear", "doc1")
+	td.add("pear", "doc3")
+	td.add("cherry", "doc3")
+	td.remove("apple", "doc1")
+	if got := strings.Join(td.sortedTerms(), " "); got != "apple banana cherry pear" {
+		t.Errorf("expected sorted terms 'apple banana cherry pear', got '%s'", got)
+	}
+
+	for _, term := range []string{ "10", "2", "2048kB", "1.5", "512kB" } {
+		td.add(term, "doc4")
+	}
+	td.remove("2", "doc4")
+	td.add("2", "doc5")
+	nums := make([]string, 0)
+	for _, nt := range td.sortedNums() {
+		nums = append(nums, nt.term)
+	}
+	if got := strings.Join(nums, " "); got != "1.5 2 10 512kB 2048kB" {
+		t.Errorf("expected sorted numeric terms '1.5 2 10 512kB 2048kB', got '%s'", got)
+	}
+}
+
+func TestNumericRange(t *testing.T) {
+	ic := newIdxCollection()
+	ic.addDoc(&testObj{ Name: "a", Normal: map[string]interface{}{ "cpu_total": 2.0, "memory_total": "2048000kB", "uptime": "2014-03-01" } })
+	ic.addDoc(&testObj{ Name: "b", Normal: map[string]interface{}{ "cpu_total": 4.0, "memory_total": "8192000kB", "uptime": "2014-05-01" } })
+	ic.addDoc(&testObj{ Name: "c", Normal: map[string]interface{}{ "cpu_total": 16.0, "memory_total": "512MB", "uptime": "unknown" } })
+
+	tests := []struct{
+		field string
+		start string
+		end string
+		inclusive bool
+		expected []string
+	}{
+		{ "cpu_total", "2", "8", true, []string{ "a", "b" } },
+		{ "cpu_total", "2", "16", false, []string{ "b" } },
+		{ "cpu_total", "3", "*", true, []string{ "b", "c" } },
+		{ "cpu_total", "*", "4", true, []string{ "a", "b" } },
+		{ "cpu_total", "1.5", "2.5", true, []string{ "a" } },
+		{ "memory_total", "2000000kB", "9000000kB", true, []string{ "a", "b" } },
+		{ "memory_total", "1000000kB", "*", true, []string{ "a", "b" } },
+		{ "uptime", "2014-04-01", "*", true, []string{ "b" } },
+		/* Not numbers, so these are compared as strings. */
+		{ "uptime", "a", "z", true, []string{ "c" } },
+		{ "cpu_total", "1", "2x", true, []string{ "a", "c" } },
+	}
+	for _, x := range tests {
+		res, err := ic.searchRange(x.field, x.start, x.end, x.inclusive)
+		if err != nil {
+			t.Errorf("range search on %s gave an error: %s", x.field, err)
+			continue
+		}
+		ok := len(res) == len(x.expected)
+		for _, e := range x.expected {
+			if _, found := res[e]; !found {
+				ok = false
+			}
+		}
+		if !ok {
+			t.Errorf("range search on %s from %s to %s found %v, expected %v", x.field, x.start, x.end, res, x.expected)
+		}
+	}
+	ic.delDoc("a")
+	res, _ := ic.searchRange("cpu_total", "*", "10", true)
+	if len(res) != 1 {
+		t.Errorf("deleted document still found in a numeric range search: %v", res)
+	}
+}
+
 // clean up
 
+func TestFuzzyPhrase(t *testing.T) {
+	ic := newIdxCollection()
+	ic.addDoc(&testObj{ Name: "a", Normal: map[string]interface{}{ "host": "webserver01", "motd": "the quick brown fox" } })
+	ic.addDoc(&testObj{ Name: "b", Normal: map[string]interface{}{ "host": "webserver02", "motd": "brown dogs are quick" } })
+	ic.addDoc(&testObj{ Name: "c", Normal: map[string]interface{}{ "host": "dbserver01", "motd": "quick" } })
+
+	fuzzy := []struct{
+		field string
+		term string
+		fuzz float64
+		expected []string
+	}{
+		{ "host", "webserver01", 1, []string{ "a", "b" } },
+		{ "host", "webserv", 0.4, []string{ "a", "b" } },
+		{ "host", "webserv", 0.5, []string{} },
+		{ "host", "webserver01", 0, []string{ "a" } },
+		{ "host", "webserver0", 0, []string{} },
+		{ "host", "webserver03", 0.95, []string{} },
+		{ "host", "dbsrever01", 2, []string{ "c" } },
+		{ "", "dbserver10", 2, []string{ "c" } },
+		{ "nope", "webserver01", 2, []string{} },
+	}
+	for _, x := range fuzzy {
+		res := ic.searchFuzzy(x.field, x.term, x.fuzz)
+		if !sameDocs(res, x.expected) {
+			t.Errorf("fuzzy search on %s for %s~%v found %v, expected %v", x.field, x.term, x.fuzz, res, x.expected)
+		}
+	}
+
+	phrase := []struct{
+		phrase string
+		slop int
+		expected []string
+	}{
+		{ "quick brown", 0, []string{ "a" } },
+		{ "quick fox", 0, []string{} },
+		{ "quick fox", 1, []string{ "a" } },
+		{ "brown quick", 1, []string{} },
+		{ "brown quick", 2, []string{ "a", "b" } },
+		{ "dogs quick", 1, []string{ "b" } },
+		{ "quick cat", 10, []string{} },
+	}
+	for _, x := range phrase {
+		res := ic.searchPhrase("motd", x.phrase, x.slop)
+		if !sameDocs(res, x.expected) {
+			t.Errorf("proximity search for \"%s\"~%d found %v, expected %v", x.phrase, x.slop, res, x.expected)
+		}
+	}
+}
+
+func sameDocs(res map[string]*IdxDoc, expected []string) bool {
+	if len(res) != len(expected) {
+		return false
+	}
+	for _, e := range expected {
+		if _, found := res[e]; !found {
+			return false
+		}
+	}
+	return true
+}
+
 func TestCleanup(t *testing.T) {
 	os.RemoveAll(idxTmpDir)
 }
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package indexer
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/* The numeric value of a term, for range searches. Numbers may have a unit
+ * after them, like ohai's "2048kB", and are only compared with numbers that
+ * have the same unit. Dates are compared by their Unix time, and have the
+ * unit "date" so they aren't compared with plain numbers. */
+type numTerm struct {
+	unit string
+	val float64
+	term string
+}
+
+const dateUnit = "date"
+
+var numericTerm = regexp.MustCompile(`^([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)([A-Za-z%]*)$`)
+
+var dateFormats = []string{ time.RFC3339, "2006-01-02" }
+
+func parseNumTerm(term string) (numTerm, bool) {
+	if m := numericTerm.FindStringSubmatch(term); m != nil {
+		val, err := strconv.ParseFloat(m[1], 64)
+		if err == nil {
+			return numTerm{ unit: m[2], val: val, term: term }, true
+		}
+	}
+	for _, f := range dateFormats {
+		if t, err := time.Parse(f, term); err == nil {
+			val := float64(t.UnixNano()) / float64(time.Second)
+			return numTerm{ unit: dateUnit, val: val, term: term }, true
+		}
+	}
+	return numTerm{}, false
+}
+
+/* Compare a numeric term with a unit and value, ignoring the term itself. */
+func (nt numTerm) cmp(unit string, val float64) int {
+	switch {
+		case nt.unit < unit:
+			return -1
+		case nt.unit > unit:
+			return 1
+		case nt.val < val:
+			return -1
+		case nt.val > val:
+			return 1
+	}
+	return 0
+}
+
+func (nt numTerm) less(o numTerm) bool {
+	c := nt.cmp(o.unit, o.val)
+	return c < 0 || (c == 0 && nt.term < o.term)
+}
+
+type byNumTerm []numTerm
+
+func (n byNumTerm) Len() int { return len(n) }
+func (n byNumTerm) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n byNumTerm) Less(i, j int) bool { return n[i].less(n[j]) }
+
+/* The numeric terms in the dictionary, sorted by unit, then value. Like the
+ * string terms, new numeric terms are sorted and merged in when they're
+ * needed rather than as they're added. */
+func (td *termDict) sortedNums() []numTerm {
+	td.sortLock.Lock()
+	defer td.sortLock.Unlock()
+	if len(td.addedNums) == 0 && !td.removedNums {
+		return td.nums
+	}
+	sort.Sort(byNumTerm(td.addedNums))
+	merged := make([]numTerm, 0, len(td.nums) + len(td.addedNums))
+	i, j := 0, 0
+	for i < len(td.nums) || j < len(td.addedNums) {
+		var nt numTerm
+		if j == len(td.addedNums) || (i < len(td.nums) && !td.addedNums[j].less(td.nums[i])) {
+			nt = td.nums[i]
+			i++
+		} else {
+			nt = td.addedNums[j]
+			j++
+		}
+		if _, found := td.postings[nt.term]; found && (len(merged) == 0 || merged[len(merged) - 1].term != nt.term) {
+			merged = append(merged, nt)
+		}
+	}
+	td.nums = merged
+	td.addedNums = make([]numTerm, 0)
+	td.removedNums = false
+	return td.nums
+}
+
+/* Search the numeric terms for a range. Returns false if the range's bounds
+ * aren't numbers with the same unit, in which case the range has to be
+ * searched as strings. */
+func (td *termDict) searchNumRange(start string, end string, inclusive bool) (map[string]bool, bool) {
+	var s, e numTerm
+	var ok bool
+	if start != "*" {
+		if s, ok = parseNumTerm(start); !ok {
+			return nil, false
+		}
+	}
+	if end != "*" {
+		if e, ok = parseNumTerm(end); !ok {
+			return nil, false
+		}
+	}
+	unit := s.unit
+	if start == "*" {
+		unit = e.unit
+	} else if end != "*" && s.unit != e.unit {
+		return nil, false
+	}
+
+	nums := td.sortedNums()
+	n := len(nums)
+	var lo, hi int
+	if start == "*" {
+		lo = sort.Search(n, func(i int) bool { return nums[i].unit >= unit })
+	} else if inclusive {
+		lo = sort.Search(n, func(i int) bool { return nums[i].cmp(unit, s.val) >= 0 })
+	} else {
+		lo = sort.Search(n, func(i int) bool { return nums[i].cmp(unit, s.val) > 0 })
+	}
+	if end == "*" {
+		hi = sort.Search(n, func(i int) bool { return nums[i].unit > unit })
+	} else if inclusive {
+		hi = sort.Search(n, func(i int) bool { return nums[i].cmp(unit, e.val) > 0 })
+	} else {
+		hi = sort.Search(n, func(i int) bool { return nums[i].cmp(unit, e.val) >= 0 })
+	}
+
+	matches := make(map[string]bool)
+	for i := lo; i < hi; i++ {
+		for d := range td.postings[nums[i].term] {
+			matches[d] = true
+		}
+	}
+	return matches, true
+}
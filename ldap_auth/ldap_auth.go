@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package ldap_auth checks users' passwords against an LDAP directory. Users
+// are found with a search, using a service account if one's configured, and
+// then their password is checked by binding as them. Members of the configured
+// admin groups are goiardi admins.
+package ldap_auth
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/user"
+	"github.com/go-ldap/ldap/v3"
+	"git.tideland.biz/goas/logger"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A user found in the directory whose password matched.
+type DirUser struct {
+	DN string
+	Name string
+	Email string
+	Admin bool
+	// Whether Admin came from checking the admin groups.
+	checkedGroups bool
+}
+
+// The user wasn't found in the directory.
+var ErrUserNotFound = errors.New("user not found in LDAP directory")
+
+// The user was found in the directory, but the password didn't match.
+var ErrBadPassword = errors.New("LDAP password did not match")
+
+/* How long to wait on the LDAP server. */
+const ldapTimeout = 10 * time.Second
+
+// Check a user's name and password against the LDAP directory. Returns
+// ErrUserNotFound if there's no such user, and ErrBadPassword if the password
+// doesn't match. Other errors mean the directory couldn't be searched.
+func Authenticate(username string, password string) (*DirUser, error) {
+	/* An empty password makes an unauthenticated bind, which succeeds
+	 * without checking anything. */
+	if password == "" {
+		return nil, ErrBadPassword
+	}
+	conf := &config.Get().LDAP
+	conn, err := connect(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = serviceBind(conn, conf); err != nil {
+		return nil, err
+	}
+	filter := strings.Replace(conf.UserFilter, "%s", ldap.EscapeFilter(username), -1)
+	req := ldap.NewSearchRequest(conf.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false, filter, []string{ conf.NameAttr, conf.EmailAttr }, nil)
+	res, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	switch {
+		case res == nil || len(res.Entries) == 0:
+			return nil, ErrUserNotFound
+		case len(res.Entries) > 1:
+			return nil, fmt.Errorf("More than one LDAP entry matched user %s", username)
+	}
+	entry := res.Entries[0]
+
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrBadPassword
+		}
+		return nil, err
+	}
+	u := &DirUser{
+		DN: entry.DN,
+		Name: entry.GetAttributeValue(conf.NameAttr),
+		Email: entry.GetAttributeValue(conf.EmailAttr),
+	}
+
+	if len(conf.AdminGroups) > 0 {
+		/* The user might not be allowed to search for groups, so go
+		 * back to the service account. */
+		if err = serviceBind(conn, conf); err != nil {
+			return nil, err
+		}
+		u.Admin, err = inAdminGroup(conn, conf, entry.DN)
+		if err != nil {
+			return nil, err
+		}
+		u.checkedGroups = true
+	}
+	return u, nil
+}
+
+// Is the user one of the local users, who log in with their goiardi password
+// instead of through LDAP?
+func IsLocalUser(username string) bool {
+	for _, u := range config.Get().LDAP.LocalUsers {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Log a user in with their LDAP password, creating a goiardi user for them the
+// first time they log in. Their name and email are kept up to date from the
+// directory, and so is whether they're an admin if admin groups are
+// configured. Returns the same errors as Authenticate.
+func Login(username string, password string) (*user.User, error) {
+	du, err := Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	u, gerr := user.Get(username)
+	changed := false
+	if gerr != nil {
+		if gerr.Status() != http.StatusNotFound {
+			return nil, gerr
+		}
+		/* Users from LDAP don't get a password or keys here. They
+		 * can get keys by asking for a new private key. */
+		u, gerr = user.New(username)
+		if gerr != nil {
+			return nil, gerr
+		}
+		logger.Infof("Creating user %s from LDAP entry %s", username, du.DN)
+		changed = true
+	}
+	if du.Name != "" && du.Name != u.Name {
+		u.Name = du.Name
+		changed = true
+	}
+	if du.Email != "" && du.Email != u.Email {
+		u.Email = du.Email
+		changed = true
+	}
+	if du.checkedGroups && du.Admin != u.Admin {
+		/* Going through UpdateFromJson won't take admin away from
+		 * the last admin. */
+		if uerr := u.UpdateFromJson(map[string]interface{}{ "name": u.Username, "admin": du.Admin }); uerr != nil {
+			logger.Warningf("Could not change admin status for user %s to match LDAP: %s", u.Username, uerr.Error())
+		} else {
+			changed = true
+		}
+	}
+	if changed {
+		if serr := u.Save(); serr != nil {
+			return nil, serr
+		}
+	}
+	return u, nil
+}
+
+func connect(conf *config.LDAPConf) (*ldap.Conn, error) {
+	addr := net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port))
+	dialer := &net.Dialer{ Timeout: ldapTimeout }
+	var conn *ldap.Conn
+	var err error
+	if conf.UseTLS || conf.StartTLS {
+		tlsConf, terr := tlsConfig(conf)
+		if terr != nil {
+			return nil, terr
+		}
+		if conf.UseTLS {
+			conn, err = ldap.DialURL("ldaps://" + addr, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConf))
+		} else {
+			conn, err = ldap.DialURL("ldap://" + addr, ldap.DialWithDialer(dialer))
+			if err == nil {
+				if err = conn.StartTLS(tlsConf); err != nil {
+					conn.Close()
+				}
+			}
+		}
+	} else {
+		conn, err = ldap.DialURL("ldap://" + addr, ldap.DialWithDialer(dialer))
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	return conn, nil
+}
+
+func tlsConfig(conf *config.LDAPConf) (*tls.Config, error) {
+	tlsConf := &tls.Config{ ServerName: conf.Host, InsecureSkipVerify: conf.InsecureSkipVerify }
+	if conf.CACert != "" {
+		pem, err := ioutil.ReadFile(conf.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in LDAP CA certificate file %s", conf.CACert)
+		}
+		tlsConf.RootCAs = pool
+	}
+	return tlsConf, nil
+}
+
+/* Bind as the service account, if there is one. Otherwise searches are
+ * anonymous. */
+func serviceBind(conn *ldap.Conn, conf *config.LDAPConf) error {
+	if conf.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(conf.BindDN, conf.BindPassword)
+}
+
+func inAdminGroup(conn *ldap.Conn, conf *config.LDAPConf, dn string) (bool, error) {
+	filter := strings.Replace(conf.GroupFilter, "%s", ldap.EscapeFilter(dn), -1)
+	req := ldap.NewSearchRequest(conf.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout.Seconds()), false, filter, []string{ "dn" }, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range res.Entries {
+		for _, a := range conf.AdminGroups {
+			if sameDN(g.DN, a) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+/* DNs are compared without regard to case or the spacing around their
+ * separators. */
+func sameDN(a string, b string) bool {
+	da, err := ldap.ParseDN(a)
+	if err != nil {
+		return strings.EqualFold(a, b)
+	}
+	db, err := ldap.ParseDN(b)
+	if err != nil {
+		return strings.EqualFold(a, b)
+	}
+	return da.EqualFold(db)
+}
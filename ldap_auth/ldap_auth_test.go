@@ -0,0 +1,282 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ldap_auth
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/user"
+	"github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/* Just enough of an LDAP server to test against: simple binds, and searches
+ * with equality, presence, and boolean filters. */
+
+type testEntry struct {
+	dn string
+	password string
+	attrs map[string][]string
+}
+
+type testServer struct {
+	l net.Listener
+	m sync.Mutex
+	entries []*testEntry
+}
+
+func startTestServer(t *testing.T, entries []*testEntry) *testServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s := &testServer{ l: l, entries: entries }
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+			case ldap.ApplicationBindRequest:
+				dn := op.Children[1].Data.String()
+				pw := op.Children[2].Data.String()
+				code := int(ldap.LDAPResultInvalidCredentials)
+				if dn == "" && pw == "" {
+					code = ldap.LDAPResultSuccess
+				}
+				s.m.Lock()
+				for _, e := range s.entries {
+					if e.dn == dn && e.password != "" && e.password == pw {
+						code = ldap.LDAPResultSuccess
+					}
+				}
+				s.m.Unlock()
+				conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+			case ldap.ApplicationSearchRequest:
+				base := strings.ToLower(op.Children[0].Data.String())
+				filter := op.Children[6]
+				s.m.Lock()
+				for _, e := range s.entries {
+					if strings.HasSuffix(strings.ToLower(e.dn), base) && matchFilter(filter, e) {
+						conn.Write(searchEntry(id, e).Bytes())
+					}
+				}
+				s.m.Unlock()
+				conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+			default:
+				return
+		}
+	}
+}
+
+func matchFilter(f *ber.Packet, e *testEntry) bool {
+	switch f.Tag {
+		case ldap.FilterAnd:
+			for _, c := range f.Children {
+				if !matchFilter(c, e) {
+					return false
+				}
+			}
+			return true
+		case ldap.FilterOr:
+			for _, c := range f.Children {
+				if matchFilter(c, e) {
+					return true
+				}
+			}
+			return false
+		case ldap.FilterNot:
+			return !matchFilter(f.Children[0], e)
+		case ldap.FilterEqualityMatch:
+			for _, v := range e.attrs[f.Children[0].Data.String()] {
+				if strings.EqualFold(v, f.Children[1].Data.String()) {
+					return true
+				}
+			}
+		case ldap.FilterPresent:
+			return len(e.attrs[f.Data.String()]) > 0
+	}
+	return false
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	p.AppendChild(op)
+	return p
+}
+
+func ldapResult(id int64, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op)
+}
+
+func searchEntry(id int64, e *testEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for k, vals := range e.attrs {
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range vals {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		a.AppendChild(set)
+		attrs.AppendChild(a)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+const (
+	aliceDN = "uid=alice,ou=people,dc=example,dc=com"
+	bobDN = "uid=bob,ou=people,dc=example,dc=com"
+)
+
+func setupDirectory(t *testing.T) (*testServer, *testEntry) {
+	admins := &testEntry{ dn: "cn=admins,ou=groups,dc=example,dc=com", attrs: map[string][]string{ "cn": { "admins" }, "member": { aliceDN } } }
+	s := startTestServer(t, []*testEntry{
+		{ dn: "cn=svc,dc=example,dc=com", password: "svcpass", attrs: map[string][]string{ "cn": { "svc" } } },
+		{ dn: aliceDN, password: "alicepw", attrs: map[string][]string{ "uid": { "alice" }, "cn": { "Alice Smith" }, "mail": { "alice@example.com" } } },
+		{ dn: bobDN, password: "bobpw", attrs: map[string][]string{ "uid": { "bob" }, "cn": { "Bob Jones" }, "mail": { "bob@example.com" } } },
+		admins,
+		{ dn: "cn=devs,ou=groups,dc=example,dc=com", attrs: map[string][]string{ "cn": { "devs" }, "uniqueMember": { bobDN } } },
+	})
+	config.Get().UseLDAP = true
+	config.Get().LDAP = config.LDAPConf{
+		Host: "127.0.0.1",
+		Port: s.l.Addr().(*net.TCPAddr).Port,
+		BindDN: "cn=svc,dc=example,dc=com",
+		BindPassword: "svcpass",
+		BaseDN: "dc=example,dc=com",
+		UserFilter: "(uid=%s)",
+		EmailAttr: "mail",
+		NameAttr: "cn",
+		GroupBaseDN: "ou=groups,dc=example,dc=com",
+		GroupFilter: "(|(member=%s)(uniqueMember=%s))",
+		AdminGroups: []string{ "CN=Admins, ou=groups, dc=example, dc=com" },
+	}
+	return s, admins
+}
+
+func TestAuthenticate(t *testing.T) {
+	s, _ := setupDirectory(t)
+	defer s.l.Close()
+
+	u, err := Authenticate("alice", "alicepw")
+	if err != nil {
+		t.Fatalf("alice couldn't authenticate: %s", err)
+	}
+	if u.DN != aliceDN || u.Email != "alice@example.com" || u.Name != "Alice Smith" || !u.Admin {
+		t.Errorf("alice's directory entry had unexpected values: %v", u)
+	}
+	if u, err = Authenticate("bob", "bobpw"); err != nil || u.Admin {
+		t.Errorf("bob should have authenticated without being an admin: %v %v", u, err)
+	}
+	if _, err = Authenticate("alice", "bobpw"); err != ErrBadPassword {
+		t.Errorf("alice authenticated with the wrong password: %v", err)
+	}
+	if _, err = Authenticate("alice", ""); err != ErrBadPassword {
+		t.Errorf("alice authenticated with an empty password: %v", err)
+	}
+	if _, err = Authenticate("carol", "carolpw"); err != ErrUserNotFound {
+		t.Errorf("carol isn't in the directory, but got %v", err)
+	}
+	if _, err = Authenticate("*", "alicepw"); err != ErrUserNotFound {
+		t.Errorf("a wildcard in the user name wasn't escaped: %v", err)
+	}
+	config.Get().LDAP.BindPassword = "wrong"
+	if _, err = Authenticate("alice", "alicepw"); err == nil || err == ErrBadPassword {
+		t.Errorf("a bad service account password should have been an error, got %v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s, admins := setupDirectory(t)
+	defer s.l.Close()
+
+	u, err := Login("alice", "alicepw")
+	if err != nil {
+		t.Fatalf("alice couldn't log in: %s", err)
+	}
+	if saved, _ := user.Get("alice"); saved == nil || saved.Email != "alice@example.com" || saved.Name != "Alice Smith" || !saved.Admin {
+		t.Errorf("alice wasn't created as expected: %v", saved)
+	}
+	if _, err = Login("bob", "bobpw"); err != nil {
+		t.Fatalf("bob couldn't log in: %s", err)
+	}
+	if bob, _ := user.Get("bob"); bob == nil || bob.Admin {
+		t.Errorf("bob wasn't created as expected: %v", bob)
+	}
+	if _, err = Login("bob", "alicepw"); err != ErrBadPassword {
+		t.Errorf("bob logged in with the wrong password: %v", err)
+	}
+
+	/* Admin status follows the directory's groups. */
+	s.m.Lock()
+	admins.attrs["member"] = []string{ bobDN }
+	s.m.Unlock()
+	if bob, _ := Login("bob", "bobpw"); bob == nil || !bob.Admin {
+		t.Errorf("bob wasn't made an admin after joining the admin group")
+	}
+	if u, _ = Login("alice", "alicepw"); u == nil || u.Admin {
+		t.Errorf("alice was still an admin after leaving the admin group")
+	}
+	for _, n := range []string{ "alice", "bob" } {
+		if u, _ := user.Get(n); u != nil {
+			u.Admin = false
+			u.Delete()
+		}
+	}
+}
+
+func TestIsLocalUser(t *testing.T) {
+	config.Get().LDAP.LocalUsers = []string{ "admin", "deploy" }
+	for _, n := range []string{ "admin", "deploy" } {
+		if !IsLocalUser(n) {
+			t.Errorf("%s should have been a local user", n)
+		}
+	}
+	for _, n := range []string{ "alice", "Admin", "" } {
+		if IsLocalUser(n) {
+			t.Errorf("%s shouldn't have been a local user", n)
+		}
+	}
+}
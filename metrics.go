@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/indexer"
+	"github.com/ctdk/goiardi/metrics"
+	"github.com/ctdk/goiardi/rate_limit"
+	"git.tideland.biz/goas/logger"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var requestsTotal = metrics.NewCounter("goiardi_http_requests_total", "HTTP requests, by route, method, and status.", "route", "method", "status")
+var requestDuration = metrics.NewHistogram("goiardi_http_request_duration_seconds", "How long HTTP requests took, by route and method.", metrics.DefBuckets, "route", "method")
+var saveDuration = metrics.NewHistogram("goiardi_save_duration_seconds", "How long saving the data store and index to disk took.", metrics.DefBuckets, "what")
+var saveFailures = metrics.NewCounter("goiardi_save_failures_total", "Failed saves of the data store and index to disk.", "what")
+
+/* Keeps the status and size of a response so it can be counted and logged. */
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes int64
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+/* Search results are flushed as they're written, so that has to still work
+ * through the wrapper. */
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+/* Requests are counted by the pattern of the handler that serves them, rather
+ * than their whole path, so there's only one route for all nodes. */
+func requestRoute(r *http.Request) string {
+	_, route := http.DefaultServeMux.Handler(r)
+	if route == "" {
+		route = "other"
+	}
+	return route
+}
+
+func observeRequest(r *http.Request, route string, sw *statusWriter, start time.Time) {
+	requestsTotal.Inc(route, r.Method, strconv.Itoa(sw.status))
+	requestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+}
+
+/* Metrics about things that are kept track of elsewhere, gathered when the
+ * metrics are written. */
+func registerMetrics() {
+	metrics.NewGaugeFunc("goiardi_index_documents", "Documents in each index collection.", []string{ "index" }, func() []metrics.Sample {
+		sizes := indexer.CollectionSizes()
+		samples := make([]metrics.Sample, 0, len(sizes))
+		for k, v := range sizes {
+			samples = append(samples, metrics.Sample{ Labels: []string{ k }, Value: float64(v) })
+		}
+		return samples
+	})
+	metrics.NewGaugeFunc("goiardi_objects", "Objects in the data store, by type.", []string{ "type" }, func() []metrics.Sample {
+		/* Count the objects directly rather than calling each type's
+		 * GetList; that builds every list on every scrape, and the
+		 * MySQL versions exit on a query error. The first name is the
+		 * in-memory data store's type, the second the MySQL table. */
+		kinds := map[string][2]string{
+			"clients": { "client", "clients" },
+			"cookbooks": { "cookbook", "cookbooks" },
+			"data": { "data_bag", "data_bags" },
+			"environments": { "env", "environments" },
+			"file_store": { "filestore", "file_checksums" },
+			"nodes": { "node", "nodes" },
+			"roles": { "role", "roles" },
+			"sandboxes": { "sandbox", "sandboxes" },
+			"saved_searches": { "saved_search", "saved_searches" },
+			"users": { "user", "users" },
+		}
+		use_mysql := config.Get().UseMySQL
+		ds := data_store.New()
+		samples := make([]metrics.Sample, 0, len(kinds))
+		for k, v := range kinds {
+			var count int
+			if use_mysql {
+				var err error
+				count, err = data_store.CountRows(data_store.Dbh, v[1])
+				if err != nil {
+					logger.Errorf("counting %s for metrics: %s", k, err.Error())
+					continue
+				}
+			} else {
+				count = ds.Count(v[0])
+			}
+			samples = append(samples, metrics.Sample{ Labels: []string{ k }, Value: float64(count) })
+		}
+		return samples
+	})
+	metrics.NewCounterFunc("goiardi_rate_limit_exceeded_total", "Requests refused for going over a rate limit, by kind of actor.", []string{ "kind" }, func() []metrics.Sample {
+		ex := rate_limit.Exceeded()
+		samples := make([]metrics.Sample, 0, len(ex))
+		for k, v := range ex {
+			samples = append(samples, metrics.Sample{ Labels: []string{ k }, Value: float64(v) })
+		}
+		return samples
+	})
+
+	if config.Get().UseMySQL {
+		metrics.NewGaugeFunc("goiardi_mysql_connections", "MySQL connections, by state.", []string{ "state" }, func() []metrics.Sample {
+			st := data_store.Dbh.Stats()
+			return []metrics.Sample{ { Labels: []string{ "in_use" }, Value: float64(st.InUse) }, { Labels: []string{ "idle" }, Value: float64(st.Idle) } }
+		})
+		metrics.NewGaugeFunc("goiardi_mysql_max_open_connections", "The most MySQL connections allowed.", nil, func() []metrics.Sample {
+			return []metrics.Sample{ { Value: float64(data_store.Dbh.Stats().MaxOpenConnections) } }
+		})
+		metrics.NewCounterFunc("goiardi_mysql_waits_total", "Times a MySQL connection had to be waited for.", nil, func() []metrics.Sample {
+			return []metrics.Sample{ { Value: float64(data_store.Dbh.Stats().WaitCount) } }
+		})
+		metrics.NewCounterFunc("goiardi_mysql_wait_seconds_total", "Time spent waiting for MySQL connections.", nil, func() []metrics.Sample {
+			return []metrics.Sample{ { Value: data_store.Dbh.Stats().WaitDuration.Seconds() } }
+		})
+	}
+}
+
+/* /metrics on goiardi's own address is only for admins. With metrics-addr
+ * set, metrics are also served on that address to anyone, for Prometheus to
+ * scrape. */
+func metrics_handler(w http.ResponseWriter, r *http.Request){
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		return
+	}
+	if !opUser.IsAdmin() {
+		w.Header().Set("Content-Type", "application/json")
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	write_metrics(w, r)
+}
+
+func write_metrics(w http.ResponseWriter, r *http.Request){
+	if r.Method != "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := metrics.Write(w); err != nil {
+		logger.Errorf("Error writing metrics: %s", err.Error())
+	}
+}
+
+func serveMetrics(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", write_metrics)
+	srv := &http.Server{ Addr: addr, Handler: mux }
+	go func(){
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Criticalf("Serving metrics on %s: %s", addr, err.Error())
+		}
+	}()
+	return srv
+}
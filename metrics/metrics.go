@@ -0,0 +1,322 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metrics keeps counters, gauges, and histograms of what goiardi is
+// doing, and writes them out in the Prometheus text format for /metrics.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Histogram buckets for timing things in seconds, from 5ms to 10s.
+var DefBuckets = []float64{ .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10 }
+
+// A value with the values of its labels, in the same order as the metric's
+// label names. Used by metrics that get their values when they're written.
+type Sample struct {
+	Labels []string
+	Value float64
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var registry = struct {
+	sync.Mutex
+	m map[string]metric
+}{ m: make(map[string]metric) }
+
+/* Metrics are made when their packages are loaded, so registering the same
+ * name twice is a mistake in the code. */
+func register(name string, m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, found := registry.m[name]; found {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	registry.m[name] = m
+}
+
+/* The values of a counter or gauge, kept for each combination of label
+ * values. */
+type valueVec struct {
+	name string
+	help string
+	kind string
+	labels []string
+	m sync.Mutex
+	vals map[string]*Sample
+}
+
+func newValueVec(name string, help string, kind string, labels []string) *valueVec {
+	v := &valueVec{ name: name, help: help, kind: kind, labels: labels, vals: make(map[string]*Sample) }
+	register(name, v)
+	return v
+}
+
+func (v *valueVec) sample(lv []string) *Sample {
+	if len(lv) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labels), len(lv)))
+	}
+	k := strings.Join(lv, "\xff")
+	s, found := v.vals[k]
+	if !found {
+		s = &Sample{ Labels: append([]string(nil), lv...) }
+		v.vals[k] = s
+	}
+	return s
+}
+
+func (v *valueVec) write(w *bufio.Writer) {
+	v.m.Lock()
+	samples := make([]Sample, 0, len(v.vals))
+	for _, s := range v.vals {
+		samples = append(samples, *s)
+	}
+	v.m.Unlock()
+	writeSamples(w, v.name, v.help, v.kind, v.labels, samples)
+}
+
+// A value that only goes up, like a count of requests.
+type Counter struct {
+	v *valueVec
+}
+
+// Make a new counter, with the names of its labels, if it has any.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{ newValueVec(name, help, "counter", labels) }
+}
+
+// Add one to the counter with the given label values.
+func (c *Counter) Inc(lv ...string) {
+	c.Add(1, lv...)
+}
+
+// Add to the counter with the given label values.
+func (c *Counter) Add(n float64, lv ...string) {
+	c.v.m.Lock()
+	defer c.v.m.Unlock()
+	c.v.sample(lv).Value += n
+}
+
+// A value that can go up and down, like how long something took last time.
+type Gauge struct {
+	v *valueVec
+}
+
+// Make a new gauge, with the names of its labels, if it has any.
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{ newValueVec(name, help, "gauge", labels) }
+}
+
+// Set the gauge with the given label values.
+func (g *Gauge) Set(n float64, lv ...string) {
+	g.v.m.Lock()
+	defer g.v.m.Unlock()
+	g.v.sample(lv).Value = n
+}
+
+// Counts observed values, like how long requests take, in buckets with upper
+// bounds, with their total count and sum.
+type Histogram struct {
+	name string
+	help string
+	labels []string
+	buckets []float64
+	m sync.Mutex
+	vals map[string]*histSample
+}
+
+type histSample struct {
+	labels []string
+	counts []uint64
+	count uint64
+	sum float64
+}
+
+// Make a new histogram with the given bucket upper bounds, which must be in
+// increasing order, and the names of its labels.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{ name: name, help: help, labels: labels, buckets: buckets, vals: make(map[string]*histSample) }
+	register(name, h)
+	return h
+}
+
+// Count a value with the given label values.
+func (h *Histogram) Observe(n float64, lv ...string) {
+	if len(lv) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", h.name, len(h.labels), len(lv)))
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	k := strings.Join(lv, "\xff")
+	s, found := h.vals[k]
+	if !found {
+		s = &histSample{ labels: append([]string(nil), lv...), counts: make([]uint64, len(h.buckets)) }
+		h.vals[k] = s
+	}
+	for i, b := range h.buckets {
+		if n <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += n
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, escapeHelp(h.help), h.name)
+	samples := make([]*histSample, 0, len(h.vals))
+	for _, s := range h.vals {
+		samples = append(samples, s)
+	}
+	sort.Sort(byHistLabels(samples))
+	for _, s := range samples {
+		names := append(append([]string(nil), h.labels...), "le")
+		for i, b := range h.buckets {
+			writeSample(w, h.name + "_bucket", names, append(append([]string(nil), s.labels...), formatValue(b)), float64(s.counts[i]))
+		}
+		writeSample(w, h.name + "_bucket", names, append(append([]string(nil), s.labels...), "+Inf"), float64(s.count))
+		writeSample(w, h.name + "_sum", h.labels, s.labels, s.sum)
+		writeSample(w, h.name + "_count", h.labels, s.labels, float64(s.count))
+	}
+}
+
+/* A metric whose values come from a function when it's written, for things
+ * goiardi already keeps track of elsewhere. */
+type funcMetric struct {
+	name string
+	help string
+	kind string
+	labels []string
+	f func() []Sample
+}
+
+// Make a gauge whose values are gotten from f whenever the metrics are
+// written.
+func NewGaugeFunc(name string, help string, labels []string, f func() []Sample) {
+	register(name, &funcMetric{ name: name, help: help, kind: "gauge", labels: labels, f: f })
+}
+
+// Make a counter whose values are gotten from f whenever the metrics are
+// written.
+func NewCounterFunc(name string, help string, labels []string, f func() []Sample) {
+	register(name, &funcMetric{ name: name, help: help, kind: "counter", labels: labels, f: f })
+}
+
+func (fm *funcMetric) write(w *bufio.Writer) {
+	writeSamples(w, fm.name, fm.help, fm.kind, fm.labels, fm.f())
+}
+
+// Write all the metrics in the Prometheus text format, sorted by name.
+func Write(w io.Writer) error {
+	registry.Lock()
+	names := make([]string, 0, len(registry.m))
+	for n := range registry.m {
+		names = append(names, n)
+	}
+	ms := make([]metric, len(names))
+	sort.Strings(names)
+	for i, n := range names {
+		ms[i] = registry.m[n]
+	}
+	registry.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range ms {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func writeSamples(w *bufio.Writer, name string, help string, kind string, labels []string, samples []Sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+	sort.Sort(byLabels(samples))
+	for _, s := range samples {
+		writeSample(w, name, labels, s.Labels, s.Value)
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, lv []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			var v string
+			if i < len(lv) {
+				v = lv[i]
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(v))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+func formatValue(v float64) string {
+	switch {
+		case math.IsInf(v, 1):
+			return "+Inf"
+		case math.IsInf(v, -1):
+			return "-Inf"
+		case math.IsNaN(v):
+			return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+type byLabels []Sample
+
+func (s byLabels) Len() int { return len(s) }
+func (s byLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byLabels) Less(i, j int) bool {
+	return strings.Join(s[i].Labels, "\xff") < strings.Join(s[j].Labels, "\xff")
+}
+
+type byHistLabels []*histSample
+
+func (s byHistLabels) Len() int { return len(s) }
+func (s byHistLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byHistLabels) Less(i, j int) bool {
+	return strings.Join(s[i].labels, "\xff") < strings.Join(s[j].labels, "\xff")
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "route", "status")
+	c.Inc("/nodes", "200")
+	c.Inc("/nodes", "200")
+	c.Add(3, "/search", "500")
+	g := NewGauge("test_last_save_seconds", "How long the\nlast save took.")
+	g.Set(1.5)
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{ 0.1, 1 }, "route")
+	h.Observe(0.05, "/nodes")
+	h.Observe(0.5, "/nodes")
+	h.Observe(2, "/nodes")
+	NewGaugeFunc("test_objects", "Objects.", []string{ "type" }, func() []Sample {
+		return []Sample{ { []string{ "roles" }, 2 }, { []string{ "a \"quoted\" type" }, 7 } }
+	})
+
+	expected := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/nodes",le="0.1"} 1
+test_duration_seconds_bucket{route="/nodes",le="1"} 2
+test_duration_seconds_bucket{route="/nodes",le="+Inf"} 3
+test_duration_seconds_sum{route="/nodes"} 2.55
+test_duration_seconds_count{route="/nodes"} 3
+# HELP test_last_save_seconds How long the\nlast save took.
+# TYPE test_last_save_seconds gauge
+test_last_save_seconds 1.5
+# HELP test_objects Objects.
+# TYPE test_objects gauge
+test_objects{type="a \"quoted\" type"} 7
+test_objects{type="roles"} 2
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/nodes",status="200"} 2
+test_requests_total{route="/search",status="500"} 3
+`
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if buf.String() != expected {
+		t.Errorf("metrics written were:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	NewCounter("test_twice_total", "Twice.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a metric name twice didn't panic")
+		}
+	}()
+	NewGauge("test_twice_total", "Twice.")
+}
@@ -18,9 +18,12 @@ package node
 
 import (
 	"github.com/ctdk/goiardi/data_store"
+	"bytes"
 	"fmt"
 	"log"
 	"database/sql"
+	"strings"
+	"time"
 )
 
 func checkForNodeMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
@@ -56,23 +59,23 @@ func (n *Node) fillNodeFromSQL(row data_store.ResRow) error {
 	}
 	n.ChefType = "node"
 	n.JsonClass = "Chef::Node"
-	err = data_store.DecodeBlob(rl, &n.RunList)
+	err = data_store.DecodeFromJSON(rl, &n.RunList)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(aa, &n.Automatic)
+	err = data_store.DecodeFromJSON(aa, &n.Automatic)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(na, &n.Normal)
+	err = data_store.DecodeFromJSON(na, &n.Normal)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(da, &n.Default)
+	err = data_store.DecodeFromJSON(da, &n.Default)
 	if err != nil {
 		return err
 	}
-	err = data_store.DecodeBlob(oa, &n.Override)
+	err = data_store.DecodeFromJSON(oa, &n.Override)
 	if err != nil {
 		return err
 	}
@@ -96,25 +99,152 @@ func getMySQL(node_name string) (*Node, error){
 	return node, nil
 }
 
+/* Load a node with just the parts of its attributes at the given paths, so
+ * partial searches don't need to read all of a node's automatic attributes
+ * from the database. */
+func getPartialMySQL(node_name string, paths [][]string) (*Node, error) {
+	lookups := partialLookups(paths)
+	var query bytes.Buffer
+	query.WriteString("select n.name, chef_environment, n.run_list")
+	args := make([]interface{}, 0, len(lookups) + 1)
+	for _, l := range lookups {
+		fmt.Fprintf(&query, ", JSON_EXTRACT(n.%s, ?)", attrCols[l.attr])
+		args = append(args, jsonPath(l.keys))
+	}
+	query.WriteString(" from nodes n where n.name = ?")
+	args = append(args, node_name)
+
+	stmt, err := data_store.Dbh.Prepare(query.String())
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	node := new(Node)
+	var rl []byte
+	vals := make([][]byte, len(lookups))
+	dest := []interface{}{ &node.Name, &node.ChefEnvironment, &rl }
+	for i := range vals {
+		dest = append(dest, &vals[i])
+	}
+	if err = stmt.QueryRow(args...).Scan(dest...); err != nil {
+		return nil, err
+	}
+	node.ChefType = "node"
+	node.JsonClass = "Chef::Node"
+	if err = data_store.DecodeFromJSON(rl, &node.RunList); err != nil {
+		return nil, err
+	}
+	attrs := []*map[string]interface{}{ &node.Automatic, &node.Normal, &node.Default, &node.Override }
+	for _, a := range attrs {
+		*a = make(map[string]interface{})
+	}
+	for i, l := range lookups {
+		if vals[i] == nil {
+			continue
+		}
+		var val interface{}
+		if err = data_store.DecodeFromJSON(vals[i], &val); err != nil {
+			return nil, err
+		}
+		a := *attrs[l.attr]
+		if len(l.keys) == 0 {
+			/* The whole attribute map was asked for. */
+			if m, ok := val.(map[string]interface{}); ok {
+				for k, v := range m {
+					a[k] = v
+				}
+			}
+			continue
+		}
+		setPath(a, l.keys, val)
+	}
+	data_store.ChkNilArray(node)
+	return node, nil
+}
+
+/* The attribute columns, in the same order as the attribute names below. */
+var attrCols = []string{ "automatic_attr", "normal_attr", "default_attr", "override_attr" }
+var attrNames = []string{ "automatic", "normal", "default", "override" }
+
+/* One JSON_EXTRACT for a partial node: which attribute column to look in, and
+ * the keys to look up in it. */
+type partialLookup struct {
+	attr int
+	keys []string
+}
+
+/* Work out which attribute columns to look in for each path. A path starting
+ * with "automatic", "normal", "default", or "override" only looks in that
+ * column, for the rest of the path. Any other path could be in any of them,
+ * since partial search merges the attributes by precedence. */
+func partialLookups(paths [][]string) []partialLookup {
+	lookups := make([]partialLookup, 0, len(paths) * len(attrCols))
+	for _, p := range paths {
+		explicit := false
+		if len(p) > 0 {
+			for i, a := range attrNames {
+				if p[0] == a {
+					lookups = append(lookups, partialLookup{ i, p[1:] })
+					explicit = true
+					break
+				}
+			}
+		}
+		if explicit || len(p) == 0 {
+			continue
+		}
+		for i := range attrCols {
+			lookups = append(lookups, partialLookup{ i, p })
+		}
+	}
+	return lookups
+}
+
+/* A MySQL JSON path for a list of keys, like $."network"."interfaces". */
+func jsonPath(keys []string) string {
+	var path bytes.Buffer
+	path.WriteString("$")
+	for _, k := range keys {
+		k = strings.Replace(k, `\`, `\\`, -1)
+		k = strings.Replace(k, `"`, `\"`, -1)
+		fmt.Fprintf(&path, `."%s"`, k)
+	}
+	return path.String()
+}
+
+/* Put a value in nested maps at the given path, making the maps along the way
+ * as needed. */
+func setPath(m map[string]interface{}, keys []string, val interface{}) {
+	for _, k := range keys[:len(keys) - 1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[k] = next
+		}
+		m = next
+	}
+	m[keys[len(keys) - 1]] = val
+}
+
 func (n *Node) saveMySQL() error {
 	// prepare the complex structures for saving
-	rlb, rlerr := data_store.EncodeBlob(&n.RunList)
+	rlb, rlerr := data_store.EncodeToJSON(&n.RunList)
 	if rlerr != nil {
 		return rlerr
 	}
-	aab, aaerr := data_store.EncodeBlob(&n.Automatic)
+	aab, aaerr := data_store.EncodeToJSON(&n.Automatic)
 	if aaerr != nil {
 		return aaerr
 	}
-	nab, naerr := data_store.EncodeBlob(&n.Normal)
+	nab, naerr := data_store.EncodeToJSON(&n.Normal)
 	if naerr != nil {
 		return naerr
 	}
-	dab, daerr := data_store.EncodeBlob(&n.Default)
+	dab, daerr := data_store.EncodeToJSON(&n.Default)
 	if daerr != nil {
 		return daerr
 	}
-	oab, oaerr := data_store.EncodeBlob(&n.Override)
+	oab, oaerr := data_store.EncodeToJSON(&n.Override)
 	if oaerr != nil {
 		return oaerr
 	}
@@ -222,3 +352,165 @@ func getNodesInEnvMySQL(env_name string) ([]*Node, error) {
 	}
 	return nodes, nil
 }
+
+func (ns *NodeStatus) saveMySQL() error {
+	rlb, rlerr := data_store.EncodeToJSON(&ns.RunList)
+	if rlerr != nil {
+		return rlerr
+	}
+	var end_time interface{}
+	if ns.EndTime != nil {
+		end_time = ns.EndTime.UTC().Format(data_store.MySQLTimeFormat)
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	if ns.id != 0 {
+		_, err = tx.Exec("UPDATE node_statuses SET run_id = ?, status = ?, run_list = ?, end_time = ? WHERE id = ?", ns.RunId, ns.Status, rlb, end_time, ns.id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+	node_id, err := data_store.CheckForOne(tx, "nodes", ns.NodeName)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	res, err := tx.Exec("INSERT INTO node_statuses (node_id, run_id, status, run_list, start_time, end_time) VALUES (?, ?, ?, ?, ?, ?)", node_id, ns.RunId, ns.Status, rlb, ns.StartTime.UTC().Format(data_store.MySQLTimeFormat), end_time)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	sid, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	/* Trim the older statuses for this node. The derived table is needed
+	 * because MySQL won't use LIMIT in an IN subquery. */
+	_, err = tx.Exec("DELETE FROM node_statuses WHERE node_id = ? AND id NOT IN (SELECT id FROM (SELECT id FROM node_statuses WHERE node_id = ? ORDER BY id DESC LIMIT ?) keep)", node_id, node_id, MaxNodeStatuses)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	ns.id = int32(sid)
+	return nil
+}
+
+func (ns *NodeStatus) fillNodeStatusFromSQL(row data_store.ResRow) error {
+	var (
+		rl []byte
+		st []byte
+		et []byte
+	)
+	err := row.Scan(&ns.id, &ns.NodeName, &ns.RunId, &ns.Status, &rl, &st, &et)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeFromJSON(rl, &ns.RunList)
+	if err != nil {
+		return err
+	}
+	if ns.RunList == nil {
+		ns.RunList = make([]string, 0)
+	}
+	ns.StartTime, err = time.Parse(data_store.MySQLTimeFormat, string(st))
+	if err != nil {
+		return err
+	}
+	if et != nil {
+		end_time, err := time.Parse(data_store.MySQLTimeFormat, string(et))
+		if err != nil {
+			return err
+		}
+		ns.EndTime = &end_time
+	}
+	return nil
+}
+
+func (n *Node) allStatusesMySQL() ([]*NodeStatus, error) {
+	statuses := make([]*NodeStatus, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT s.id, n.name, s.run_id, s.status, s.run_list, s.start_time, s.end_time FROM node_statuses s JOIN nodes n ON s.node_id = n.id WHERE n.name = ? ORDER BY s.id")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, qerr := stmt.Query(n.Name)
+	if qerr != nil {
+		if qerr == sql.ErrNoRows {
+			return statuses, nil
+		}
+		return nil, qerr
+	}
+	for rows.Next() {
+		ns := new(NodeStatus)
+		err = ns.fillNodeStatusFromSQL(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		statuses = append(statuses, ns)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+func (n *Node) latestStatusMySQL() (*NodeStatus, error) {
+	stmt, err := data_store.Dbh.Prepare("SELECT s.id, n.name, s.run_id, s.status, s.run_list, s.start_time, s.end_time FROM node_statuses s JOIN nodes n ON s.node_id = n.id WHERE n.name = ? ORDER BY s.id DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	ns := new(NodeStatus)
+	err = ns.fillNodeStatusFromSQL(stmt.QueryRow(n.Name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ns, nil
+}
+
+func staleNodesMySQL(cutoff time.Time) ([]*StaleNode, error) {
+	stale := make([]*StaleNode, 0)
+	rows, err := data_store.Dbh.Query("SELECT n.name, MAX(s.end_time) FROM nodes n LEFT JOIN node_statuses s ON s.node_id = n.id AND s.status = ? GROUP BY n.name HAVING MAX(s.end_time) IS NULL OR MAX(s.end_time) < ? ORDER BY n.name", RunSuccess, cutoff.UTC().Format(data_store.MySQLTimeFormat))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return stale, nil
+		}
+		return nil, err
+	}
+	for rows.Next() {
+		sn := new(StaleNode)
+		var ls []byte
+		err = rows.Scan(&sn.NodeName, &ls)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if ls != nil {
+			last_success, err := time.Parse(data_store.MySQLTimeFormat, string(ls))
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			sn.LastSuccess = &last_success
+		}
+		stale = append(stale, sn)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package node
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartialLookups(t *testing.T) {
+	paths := [][]string{ []string{ "automatic", "kernel", "machine" }, []string{ "override" }, []string{ "foo", "bar" } }
+	lookups := partialLookups(paths)
+	expected := []partialLookup{
+		partialLookup{ 0, []string{ "kernel", "machine" } },
+		partialLookup{ 3, []string{} },
+		partialLookup{ 0, []string{ "foo", "bar" } },
+		partialLookup{ 1, []string{ "foo", "bar" } },
+		partialLookup{ 2, []string{ "foo", "bar" } },
+		partialLookup{ 3, []string{ "foo", "bar" } },
+	}
+	if !reflect.DeepEqual(lookups, expected) {
+		t.Errorf("partial lookups were wrong, expected %v, got %v", expected, lookups)
+	}
+	if p := jsonPath(lookups[0].keys); p != `$."kernel"."machine"` {
+		t.Errorf("JSON path for %v was wrong: %s", lookups[0].keys, p)
+	}
+	if p := jsonPath(lookups[1].keys); p != "$" {
+		t.Errorf("JSON path for the whole override attributes was wrong: %s", p)
+	}
+}
@@ -44,7 +44,7 @@ type Node struct {
 func New(name string) (*Node, util.Gerror) {
 	/* check for an existing node with this name */
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		// will need redone if orgs ever get implemented
 		var err error
 		found, err = checkForNodeMySQL(data_store.Dbh, name)
@@ -101,7 +101,7 @@ func NewFromJson(json_node map[string]interface{}) (*Node, util.Gerror){
 func Get(node_name string) (*Node, error) {
 	var node *Node
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		node, err = getMySQL(node_name)
 		if err != nil {
@@ -128,6 +128,25 @@ func Get(node_name string) (*Node, error) {
 	return node, nil
 }
 
+// Get a node with only the attributes at the given key paths, for partial
+// search. A path can also start with a top level field, like "name" or
+// "run_list", which are always filled in. With MySQL only the requested parts
+// of the attributes are read from the database; in-memory nodes are already
+// loaded, so the whole node is returned.
+func GetPartial(node_name string, paths [][]string) (*Node, error) {
+	if !config.Get().UseMySQL {
+		return Get(node_name)
+	}
+	node, err := getPartialMySQL(node_name, paths)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("node '%s' not found", node_name)
+		}
+		return nil, err
+	}
+	return node, nil
+}
+
 // Update an existing node with the uploaded JSON.
 func (n *Node) UpdateFromJson(json_node map[string]interface{}) util.Gerror {
 	/* It's actually totally legitimate to save a node with a different
@@ -228,7 +247,7 @@ func (n *Node) UpdateFromJson(json_node map[string]interface{}) util.Gerror {
 }
 
 func (n *Node) Save() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := n.saveMySQL(); err != nil {
 			return err
 		}
@@ -242,7 +261,7 @@ func (n *Node) Save() error {
 }
 
 func (n *Node) Delete() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := n.deleteMySQL(); err != nil {
 			return err
 		}
@@ -250,6 +269,7 @@ func (n *Node) Delete() error {
 		ds := data_store.New()
 		ds.Delete("node", n.Name)
 	}
+	n.deleteStatuses()
 	indexer.DeleteItemFromCollection("node", n.Name)
 	return nil
 }
@@ -257,7 +277,7 @@ func (n *Node) Delete() error {
 // Get a list of the nodes on this server.
 func GetList() []string {
 	var node_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		node_list = getListMySQL()
 	} else {
 		ds := data_store.New()
@@ -267,7 +287,7 @@ func GetList() []string {
 }
 
 func GetFromEnv(env_name string) ([]*Node, error) {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		return getNodesInEnvMySQL(env_name)
 	}
 	env_nodes := make([]*Node, 0)
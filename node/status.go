@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package node
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Possible states of a chef-client run.
+const (
+	RunStarted = "started"
+	RunSuccess = "success"
+	RunFailure = "failure"
+)
+
+// The number of run status records kept for each node. Older records are
+// discarded as new ones come in.
+const MaxNodeStatuses = 50
+
+// The status of one chef-client run on a node. EndTime is nil while the run is
+// still going.
+type NodeStatus struct {
+	NodeName string `json:"node_name"`
+	RunId string `json:"run_id,omitempty"`
+	Status string `json:"status"`
+	RunList []string `json:"run_list"`
+	StartTime time.Time `json:"start_time"`
+	EndTime *time.Time `json:"end_time"`
+	id int32
+}
+
+// A node that has not had a successful chef-client run recently. LastSuccess
+// is nil if the node has never had a successful run.
+type StaleNode struct {
+	NodeName string `json:"node_name"`
+	LastSuccess *time.Time `json:"last_success"`
+}
+
+// The run statuses for a node, as kept in the in-memory data store.
+type StatusHistory struct {
+	Statuses []*NodeStatus
+}
+
+/* In-memory mode keeps the run statuses for each node in one slice, so
+ * updating them needs to be serialized. */
+var statusLock sync.Mutex
+
+// Record a chef-client run status for this node. If a run is ending (the
+// status is "success" or "failure") and the most recent recorded run was
+// started but never finished, or has the same run id, that run is updated
+// instead of a new one being added. The run id is optional. A nil run_list leaves the recorded run list alone when finishing a
+// run, and uses the node's current run list otherwise. Zero times are filled
+// in with the current time.
+func (n *Node) UpdateStatus(status string, run_id string, run_list []string, start_time time.Time, end_time time.Time) (*NodeStatus, util.Gerror) {
+	if status != RunStarted && status != RunSuccess && status != RunFailure {
+		err := util.Errorf("Field 'status' invalid")
+		err.SetStatus(http.StatusBadRequest)
+		return nil, err
+	}
+	now := time.Now().UTC()
+
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	latest, err := n.latestStatus()
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return nil, gerr
+	}
+
+	var ns *NodeStatus
+	updated := status != RunStarted && latest != nil && (latest.Status == RunStarted || run_id != "" && latest.RunId == run_id)
+	if updated {
+		/* The recorded status may be being read elsewhere, so it's
+		 * copied rather than changed in place. */
+		ls := *latest
+		ns = &ls
+		if run_id != "" {
+			ns.RunId = run_id
+		}
+		if run_list != nil {
+			ns.RunList = run_list
+		}
+	} else {
+		ns = &NodeStatus{ NodeName: n.Name, RunId: run_id, RunList: run_list }
+		if ns.RunList == nil {
+			ns.RunList = n.RunList
+		}
+		if start_time.IsZero() {
+			if status == RunStarted || end_time.IsZero() {
+				start_time = now
+			} else {
+				start_time = end_time
+			}
+		}
+		ns.StartTime = start_time.UTC()
+	}
+	ns.Status = status
+	if status != RunStarted {
+		if end_time.IsZero() {
+			end_time = now
+		}
+		et := end_time.UTC()
+		ns.EndTime = &et
+	}
+
+	if config.Get().UseMySQL {
+		err = ns.saveMySQL()
+	} else {
+		err = ns.saveInMem(updated)
+	}
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return nil, gerr
+	}
+	return ns, nil
+}
+
+// Get the recorded chef-client runs for this node, oldest first.
+func (n *Node) AllStatuses() ([]*NodeStatus, error) {
+	if config.Get().UseMySQL {
+		return n.allStatusesMySQL()
+	}
+	statuses := getStatusesInMem(n.Name)
+	all := make([]*NodeStatus, len(statuses))
+	copy(all, statuses)
+	return all, nil
+}
+
+// Get the most recent chef-client run recorded for this node, or nil if there
+// aren't any.
+func (n *Node) LatestStatus() (*NodeStatus, error) {
+	return n.latestStatus()
+}
+
+func (n *Node) latestStatus() (*NodeStatus, error) {
+	if config.Get().UseMySQL {
+		return n.latestStatusMySQL()
+	}
+	statuses := getStatusesInMem(n.Name)
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	return statuses[len(statuses) - 1], nil
+}
+
+func (n *Node) deleteStatuses() {
+	/* MySQL removes them along with the node. */
+	if !config.Get().UseMySQL {
+		ds := data_store.New()
+		ds.Delete("nodestatus", n.Name)
+	}
+}
+
+// Get the nodes whose last successful chef-client run ended more than
+// 'minutes' minutes ago, or that have never had a successful run, sorted by
+// name.
+func StaleNodes(minutes int) ([]*StaleNode, error) {
+	cutoff := time.Now().UTC().Add(-time.Duration(minutes) * time.Minute)
+	if config.Get().UseMySQL {
+		return staleNodesMySQL(cutoff)
+	}
+	stale := make([]*StaleNode, 0)
+	node_list := GetList()
+	sort.Strings(node_list)
+	for _, name := range node_list {
+		var last_success *time.Time
+		statuses := getStatusesInMem(name)
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i].Status == RunSuccess {
+				last_success = statuses[i].EndTime
+				break
+			}
+		}
+		if last_success == nil || last_success.Before(cutoff) {
+			stale = append(stale, &StaleNode{ NodeName: name, LastSuccess: last_success })
+		}
+	}
+	return stale, nil
+}
+
+func getStatusesInMem(node_name string) []*NodeStatus {
+	ds := data_store.New()
+	s, found := ds.Get("nodestatus", node_name)
+	if !found || s == nil {
+		return nil
+	}
+	return s.(*StatusHistory).Statuses
+}
+
+/* The stored slice of statuses may be being read elsewhere, so a new one is
+ * made with the updated status replacing the last one, or the new status added
+ * to the end. */
+func (ns *NodeStatus) saveInMem(updated bool) error {
+	old := getStatusesInMem(ns.NodeName)
+	if updated {
+		old = old[:len(old) - 1]
+	}
+	statuses := make([]*NodeStatus, 0, len(old) + 1)
+	statuses = append(statuses, old...)
+	statuses = append(statuses, ns)
+	if len(statuses) > MaxNodeStatuses {
+		statuses = statuses[len(statuses) - MaxNodeStatuses:]
+	}
+	ds := data_store.New()
+	ds.Set("nodestatus", ns.NodeName, &StatusHistory{ Statuses: statuses })
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	n, _ := New("status1")
+	n.RunList = []string{ "recipe[foo]" }
+	n.Save()
+	if _, err := n.UpdateStatus("bogus", "", nil, time.Time{}, time.Time{}); err == nil {
+		t.Errorf("UpdateStatus() accepted an invalid status")
+	}
+	s, err := n.UpdateStatus(RunStarted, "", nil, time.Time{}, time.Time{})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if s.EndTime != nil || s.RunList[0] != "recipe[foo]" {
+		t.Errorf("Started run had unexpected values: %v", s)
+	}
+	f, err := n.UpdateStatus(RunFailure, "", []string{ "recipe[bar]" }, time.Time{}, time.Time{})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if !f.StartTime.Equal(s.StartTime) || f.EndTime == nil || f.RunList[0] != "recipe[bar]" {
+		t.Errorf("Finishing a run should have updated the started run, got %v", f)
+	}
+	if s.Status != RunStarted || s.EndTime != nil {
+		t.Errorf("Finishing a run changed the status returned when it started: %v", s)
+	}
+	if all, _ := n.AllStatuses(); len(all) != 1 || all[0] != f {
+		t.Errorf("Finishing a run should have replaced the started run, got %v", all)
+	}
+	n.UpdateStatus(RunSuccess, "", nil, time.Time{}, time.Time{})
+	all, _ := n.AllStatuses()
+	if len(all) != 2 {
+		t.Errorf("Expected 2 run statuses, got %d", len(all))
+	}
+	latest, _ := n.LatestStatus()
+	if latest == nil || latest.Status != RunSuccess {
+		t.Errorf("Latest status should have been a success, got %v", latest)
+	}
+	n.Delete()
+	all, _ = n.AllStatuses()
+	if len(all) != 0 {
+		t.Errorf("Run statuses were not deleted with the node")
+	}
+}
+
+func TestUpdateStatusRunId(t *testing.T) {
+	n, _ := New("status3")
+	n.Save()
+	n.UpdateStatus(RunStarted, "abc", nil, time.Time{}, time.Time{})
+	n.UpdateStatus(RunSuccess, "", nil, time.Time{}, time.Time{})
+	f, _ := n.UpdateStatus(RunFailure, "abc", nil, time.Time{}, time.Time{})
+	all, _ := n.AllStatuses()
+	if len(all) != 1 || f.Status != RunFailure || f.RunId != "abc" {
+		t.Errorf("Finishing a run with the same run id should have updated it, got %v", all)
+	}
+	n.Delete()
+}
+
+func TestStatusHistoryLimit(t *testing.T) {
+	n, _ := New("status2")
+	n.Save()
+	for i := 0; i < MaxNodeStatuses + 5; i++ {
+		n.UpdateStatus(RunSuccess, "", nil, time.Time{}, time.Time{})
+	}
+	all, _ := n.AllStatuses()
+	if len(all) != MaxNodeStatuses {
+		t.Errorf("Expected %d run statuses, got %d", MaxNodeStatuses, len(all))
+	}
+	n.Delete()
+}
+
+func TestStaleNodes(t *testing.T) {
+	fresh, _ := New("stale1")
+	fresh.Save()
+	fresh.UpdateStatus(RunSuccess, "", nil, time.Time{}, time.Time{})
+	old, _ := New("stale2")
+	old.Save()
+	old.UpdateStatus(RunSuccess, "", nil, time.Time{}, time.Now().Add(-2 * time.Hour))
+	old.UpdateStatus(RunFailure, "", nil, time.Time{}, time.Time{})
+	never, _ := New("stale3")
+	never.Save()
+
+	stale, err := StaleNodes(60)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if len(stale) != 2 || stale[0].NodeName != "stale2" || stale[1].NodeName != "stale3" {
+		t.Errorf("StaleNodes() returned unexpected nodes: %v", stale)
+	} else if stale[0].LastSuccess == nil || stale[1].LastSuccess != nil {
+		t.Errorf("StaleNodes() returned unexpected last success times")
+	}
+	fresh.Delete()
+	old.Delete()
+	never.Delete()
+}
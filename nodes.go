@@ -22,15 +22,22 @@ import (
 	"net/http"
 	"encoding/json"
 	"github.com/ctdk/goiardi/node"
+	"github.com/ctdk/goiardi/role"
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/client"
+	"git.tideland.biz/goas/logger"
+	"strconv"
+	"time"
 )
 
 func node_handler(w http.ResponseWriter, r *http.Request){
 	w.Header().Set("Content-Type", "application/json")
 	
-	node_name := r.URL.Path[7:]
+	/* Besides /nodes/NAME, there's also /nodes/NAME/status for tracking
+	 * chef-client runs and /nodes/NAME/expanded_run_list. */
+	path_array := SplitPath(r.URL.Path)
+	node_name := path_array[1]
 
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
@@ -38,6 +45,17 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
+	if len(path_array) == 3 && path_array[2] == "status" {
+		node_status_handler(w, r, opUser, node_name)
+		return
+	} else if len(path_array) == 3 && path_array[2] == "expanded_run_list" {
+		node_expanded_run_list_handler(w, r, opUser, node_name)
+		return
+	} else if len(path_array) > 2 {
+		JsonErrorReport(w, r, "not found", http.StatusNotFound)
+		return
+	}
+
 	/* So, what are we doing? Depends on the HTTP method, of course */
 	switch r.Method {
 		case "GET", "DELETE":
@@ -106,6 +124,14 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			/* chef-client saves the node at the end of a successful
+			 * run, so record that run if it's the node's own client
+			 * saving it. */
+			if opUser.IsClient() && opUser.(*client.Client).NodeName == node_name {
+				if _, serr := chef_node.UpdateStatus(node.RunSuccess, "", chef_node.RunList, time.Time{}, time.Time{}); serr != nil {
+					logger.Errorf("Error recording run status for node %s: %s", node_name, serr.Error())
+				}
+			}
 			enc := json.NewEncoder(w)
 			if err = enc.Encode(&chef_node); err != nil {
 				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
@@ -114,3 +140,149 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 			JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
 	}
 }
+
+func node_status_handler(w http.ResponseWriter, r *http.Request, opUser actor.Actor, node_name string){
+	chef_node, err := node.Get(node_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+		case "GET":
+			if opUser.IsValidator() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			statuses, err := chef_node.AllStatuses()
+			if err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			enc := json.NewEncoder(w)
+			if err = enc.Encode(&statuses); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			}
+		case "POST":
+			if !opUser.IsAdmin() && !(opUser.IsClient() && opUser.(*client.Client).NodeName == node_name) {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			status_data, jerr := ParseObjJson(r.Body)
+			if jerr != nil {
+				JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+				return
+			}
+			status, verr := util.ValidateAsString(status_data["status"])
+			if verr != nil {
+				JsonErrorReport(w, r, "Field 'status' missing", http.StatusBadRequest)
+				return
+			}
+			var run_id string
+			if rid, found := status_data["run_id"]; found {
+				run_id, verr = util.ValidateAsString(rid)
+				if verr != nil {
+					JsonErrorReport(w, r, "Field 'run_id' invalid", http.StatusBadRequest)
+					return
+				}
+			}
+			var run_list []string
+			if rl, found := status_data["run_list"]; found {
+				vrl, rerr := util.ValidateRunList(rl)
+				if rerr != nil {
+					JsonErrorReport(w, r, rerr.Error(), http.StatusBadRequest)
+					return
+				}
+				run_list = vrl
+			}
+			var times [2]time.Time
+			for i, t := range []string{ "start_time", "end_time" } {
+				if _, found := status_data[t]; !found {
+					continue
+				}
+				ts, terr := util.ValidateAsString(status_data[t])
+				if terr == nil {
+					times[i], err = time.Parse(time.RFC3339, ts)
+				}
+				if terr != nil || err != nil {
+					JsonErrorReport(w, r, "Field '" + t + "' invalid", http.StatusBadRequest)
+					return
+				}
+			}
+			ns, serr := chef_node.UpdateStatus(status, run_id, run_list, times[0], times[1])
+			if serr != nil {
+				JsonErrorReport(w, r, serr.Error(), serr.Status())
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			enc := json.NewEncoder(w)
+			if err = enc.Encode(&ns); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			}
+		default:
+			JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+	}
+}
+
+/* Expand the node's run list with the run lists for its environment. */
+func node_expanded_run_list_handler(w http.ResponseWriter, r *http.Request, opUser actor.Actor, node_name string){
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	if opUser.IsValidator() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	chef_node, err := node.Get(node_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	exp, gerr := role.Expand(chef_node.RunList, chef_node.ChefEnvironment)
+	if gerr != nil {
+		JsonErrorReport(w, r, gerr.Error(), gerr.Status())
+		return
+	}
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(&exp); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// List the nodes that haven't had a successful chef-client run in the last
+// N minutes, given with the "minutes" query parameter (default 60).
+func stale_nodes_handler(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		return
+	}
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	if opUser.IsValidator() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	minutes := 60
+	if m := r.FormValue("minutes"); m != "" {
+		var err error
+		minutes, err = strconv.Atoi(m)
+		if err != nil || minutes < 0 {
+			JsonErrorReport(w, r, "invalid value for 'minutes'", http.StatusBadRequest)
+			return
+		}
+	}
+	stale, err := node.StaleNodes(minutes)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(&stale); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package rate_limit limits how fast each actor can make requests, so one
+// busy client or script can't keep goiardi from answering everyone else.
+package rate_limit
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Each actor has a token bucket that holds up to the burst size for its kind
+ * of actor, and is refilled at its kind's rate. A request takes its cost out
+ * of the bucket, and is refused if there aren't enough tokens. The limits are
+ * passed in from the config for each request, so buckets pick up new limits as
+ * they change. */
+
+// Kinds of actors, which have separate limits.
+const (
+	Default = "default"
+	Admin = "admin"
+	Validator = "validator"
+)
+
+type bucket struct {
+	kind string
+	tokens float64
+	last time.Time
+}
+
+var buckets = struct {
+	sync.Mutex
+	m map[string]*bucket
+	swept time.Time
+	exceeded map[string]uint64
+}{ m: make(map[string]*bucket), exceeded: make(map[string]uint64) }
+
+/* Lets tests pretend time has passed. */
+var timeNow = time.Now
+
+// Check whether the actor with the given name, of the given kind, can make a
+// request costing cost tokens under the given limits. If it can't, returns how
+// long until it could.
+func Allow(rl *config.RateLimitConf, name string, kind string, cost int) (time.Duration, bool) {
+	return take(rl, name, kind, cost, true)
+}
+
+// Check whether the actor with the given name, of the given kind, has any
+// tokens left under the given limits, without taking any. If it doesn't,
+// returns how long until it will.
+func Check(rl *config.RateLimitConf, name string, kind string) (time.Duration, bool) {
+	return take(rl, name, kind, 1, false)
+}
+
+func take(rl *config.RateLimitConf, name string, kind string, cost int, spend bool) (time.Duration, bool) {
+	rate, burst := limits(rl, kind)
+	if rate <= 0 || cost <= 0 {
+		return 0, true
+	}
+	buckets.Lock()
+	defer buckets.Unlock()
+	now := timeNow()
+	forgetFullBuckets(rl, now)
+
+	b, found := buckets.m[name]
+	if !found || b.kind != kind {
+		b = &bucket{ kind: kind, tokens: burst, last: now }
+		buckets.m[name] = b
+	} else {
+		b.tokens = math.Min(burst, b.tokens + now.Sub(b.last).Seconds() * rate)
+		b.last = now
+	}
+	/* A request costing more than the whole bucket would never get
+	 * through otherwise. */
+	c := math.Min(float64(cost), burst)
+	if b.tokens < c {
+		buckets.exceeded[kind]++
+		wait := time.Duration((c - b.tokens) / rate * float64(time.Second))
+		return wait, false
+	}
+	if spend {
+		b.tokens -= c
+	}
+	return 0, true
+}
+
+// Whether any kind of actor has a rate limit.
+func Enabled(rl *config.RateLimitConf) bool {
+	return rl.Rate > 0 || rl.AdminRate > 0 || rl.ValidatorRate > 0
+}
+
+// How many tokens a request costs. The most specific match in the configured
+// costs wins: method and path, then path, then method.
+func Cost(rl *config.RateLimitConf, method string, path string) int {
+	costs := rl.Costs
+	p := "/" + strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	for _, k := range []string{ method + " " + p, p, method } {
+		if c, found := costs[k]; found {
+			return c
+		}
+	}
+	return 1
+}
+
+// How many requests have been refused for each kind of actor.
+func Exceeded() map[string]uint64 {
+	buckets.Lock()
+	defer buckets.Unlock()
+	ex := make(map[string]uint64, len(buckets.exceeded))
+	for k, v := range buckets.exceeded {
+		ex[k] = v
+	}
+	return ex
+}
+
+func limits(rl *config.RateLimitConf, kind string) (float64, float64) {
+	switch kind {
+		case Admin:
+			return rl.AdminRate, float64(rl.AdminBurst)
+		case Validator:
+			return rl.ValidatorRate, float64(rl.ValidatorBurst)
+		default:
+			return rl.Rate, float64(rl.Burst)
+	}
+}
+
+/* A bucket that's had time to fill up again is the same as a new one, so
+ * there's no need to keep it around. Only look every so often, though. */
+func forgetFullBuckets(rl *config.RateLimitConf, now time.Time) {
+	if now.Sub(buckets.swept) < time.Minute {
+		return
+	}
+	buckets.swept = now
+	for k, b := range buckets.m {
+		rate, burst := limits(rl, b.kind)
+		if rate <= 0 || b.tokens + now.Sub(b.last).Seconds() * rate >= burst {
+			delete(buckets.m, k)
+		}
+	}
+}
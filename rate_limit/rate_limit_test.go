@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rate_limit
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	rl := &config.RateLimitConf{ Rate: 2, Burst: 4, AdminRate: 10, AdminBurst: 20 }
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	for i := 0; i < 4; i++ {
+		if _, ok := Allow(rl, "node1", Default, 1); !ok {
+			t.Fatalf("request %d within the burst was refused", i)
+		}
+	}
+	wait, ok := Allow(rl, "node1", Default, 1)
+	if ok {
+		t.Fatalf("request past the burst was allowed")
+	}
+	if wait != 500 * time.Millisecond {
+		t.Errorf("expected to wait 500ms for another token, got %s", wait)
+	}
+	if _, ok := Allow(rl, "node2", Default, 1); !ok {
+		t.Errorf("another client was refused because node1 used up its tokens")
+	}
+	if _, ok := Allow(rl, "admin", Admin, 5); !ok {
+		t.Errorf("an admin was refused within its own limit")
+	}
+	if _, ok := Allow(rl, "chef-validator", Validator, 100); !ok {
+		t.Errorf("the validator was refused without a limit set")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := Allow(rl, "node1", Default, 2); !ok {
+		t.Errorf("the bucket wasn't refilled after a second")
+	}
+	if _, ok := Allow(rl, "node1", Default, 1); ok {
+		t.Errorf("more tokens than had been refilled were used")
+	}
+
+	/* Requests costing more than the burst wait for a full bucket. */
+	now = now.Add(time.Hour)
+	if _, ok := Allow(rl, "node1", Default, 10); !ok {
+		t.Errorf("an expensive request was refused with a full bucket")
+	}
+	if _, ok := Allow(rl, "node1", Default, 1); ok {
+		t.Errorf("an expensive request didn't empty the bucket")
+	}
+	if ex := Exceeded(); ex[Default] != 3 || ex[Admin] != 0 {
+		t.Errorf("unexpected counts of refused requests: %v", ex)
+	}
+
+	/* Checking a bucket doesn't take anything out of it. */
+	now = now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		if _, ok := Check(rl, "node3", Default); !ok {
+			t.Fatalf("checking node3's bucket used up its tokens")
+		}
+	}
+	for i := 0; i < 4; i++ {
+		Allow(rl, "node3", Default, 1)
+	}
+	if wait, ok := Check(rl, "node3", Default); ok || wait != 500 * time.Millisecond {
+		t.Errorf("checking node3's empty bucket should have said to wait 500ms, got %s %v", wait, ok)
+	}
+}
+
+func TestCost(t *testing.T) {
+	rl := &config.RateLimitConf{ Costs: map[string]int{ "/search": 5, "POST /search": 8, "PUT": 2 } }
+	tests := []struct{
+		method string
+		path string
+		cost int
+	}{
+		{ "GET", "/search/node", 5 },
+		{ "POST", "/search/node", 8 },
+		{ "PUT", "/nodes/foo", 2 },
+		{ "GET", "/nodes/foo", 1 },
+		{ "GET", "/searches", 1 },
+	}
+	for _, c := range tests {
+		if cost := Cost(rl, c.method, c.path); cost != c.cost {
+			t.Errorf("%s %s should cost %d, got %d", c.method, c.path, c.cost, cost)
+		}
+	}
+}
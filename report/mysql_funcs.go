@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package report
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func checkForReportMySQL(dbhandle data_store.Dbhandle, run_id string) (bool, error) {
+	var id int32
+	err := dbhandle.QueryRow("SELECT id FROM reports WHERE run_id = ?", run_id).Scan(&id)
+	if err == nil {
+		return true, nil
+	} else if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return false, err
+}
+
+// Fill in a report from a row returned from the SQL server. The query must
+// select the same columns as the one in getMySQL().
+func (r *Report) fillReportFromSQL(row data_store.ResRow) error {
+	var (
+		st []byte
+		et []byte
+		res []byte
+		dat []byte
+	)
+	err := row.Scan(&r.RunId, &r.NodeName, &st, &et, &r.TotalResCount, &r.Status, &r.RunList, &res, &dat)
+	if err != nil {
+		return err
+	}
+	r.StartTime, err = time.Parse(data_store.MySQLTimeFormat, string(st))
+	if err != nil {
+		return err
+	}
+	if et != nil {
+		end_time, err := time.Parse(data_store.MySQLTimeFormat, string(et))
+		if err != nil {
+			return err
+		}
+		r.EndTime = &end_time
+	}
+	err = data_store.DecodeFromJSON(res, &r.Resources)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeFromJSON(dat, &r.Data)
+	if err != nil {
+		return err
+	}
+	data_store.ChkNilArray(r)
+	return nil
+}
+
+func getMySQL(run_id string) (*Report, error) {
+	r := new(Report)
+	stmt, err := data_store.Dbh.Prepare("SELECT run_id, node_name, start_time, end_time, total_res_count, status, run_list, resources, data FROM reports WHERE run_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(run_id)
+	err = r.fillReportFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Report) saveMySQL() error {
+	resb, reserr := data_store.EncodeToJSON(&r.Resources)
+	if reserr != nil {
+		return reserr
+	}
+	datb, daterr := data_store.EncodeToJSON(&r.Data)
+	if daterr != nil {
+		return daterr
+	}
+	var end_time interface{}
+	if r.EndTime != nil {
+		end_time = r.EndTime.UTC().Format(data_store.MySQLTimeFormat)
+	}
+	start_time := r.StartTime.UTC().Format(data_store.MySQLTimeFormat)
+
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	found, err := checkForReportMySQL(tx, r.RunId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if found {
+		_, err = tx.Exec("UPDATE reports SET start_time = ?, end_time = ?, total_res_count = ?, status = ?, run_list = ?, resources = ?, data = ?, updated_at = NOW() WHERE run_id = ?", start_time, end_time, r.TotalResCount, r.Status, r.RunList, resb, datb, r.RunId)
+	} else {
+		_, err = tx.Exec("INSERT INTO reports (run_id, node_name, start_time, end_time, total_res_count, status, run_list, resources, data, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())", r.RunId, r.NodeName, start_time, end_time, r.TotalResCount, r.Status, r.RunList, resb, datb)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (r *Report) deleteMySQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM reports WHERE run_id = ?", r.RunId)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting report %s had an error '%s', and then rolling back the transaction gave another error '%s'", r.RunId, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func getReportsMySQL(from time.Time, until time.Time, rows int, node_name string, status string) ([]*Report, error) {
+	reports := make([]*Report, 0)
+	where := []string{ "start_time >= ?", "start_time <= ?" }
+	args := []interface{}{ from.UTC().Format(data_store.MySQLTimeFormat), until.UTC().Format(data_store.MySQLTimeFormat) }
+	if node_name != "" {
+		where = append(where, "node_name = ?")
+		args = append(args, node_name)
+	}
+	if status != "" {
+		where = append(where, "status = ?")
+		args = append(args, status)
+	}
+	query := fmt.Sprintf("SELECT run_id, node_name, start_time, end_time, total_res_count, status, run_list, resources, data FROM reports WHERE %s ORDER BY start_time DESC", strings.Join(where, " AND "))
+	if rows != 0 {
+		query = fmt.Sprintf("%s LIMIT %d", query, rows)
+	}
+	res, err := data_store.Dbh.Query(query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return reports, nil
+		}
+		return nil, err
+	}
+	for res.Next() {
+		r := new(Report)
+		err = r.fillReportFromSQL(res)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	res.Close()
+	if err = res.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func deleteBeforeMySQL(before time.Time) (int, error) {
+	res, err := data_store.Dbh.Exec("DELETE FROM reports WHERE start_time < ?", before.UTC().Format(data_store.MySQLTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
@@ -0,0 +1,315 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package report implements the storage side of the Chef reporting API.
+// chef-client's resource reporter sends a report when a run starts, and
+// another when it ends with the resources that were updated during the run.
+package report
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"database/sql"
+)
+
+// A chef-client run report. EndTime is nil while the run is still going.
+type Report struct {
+	RunId string `json:"run_id"`
+	NodeName string `json:"node_name"`
+	StartTime time.Time `json:"start_time"`
+	EndTime *time.Time `json:"end_time"`
+	TotalResCount int `json:"total_res_count"`
+	Status string `json:"status"`
+	RunList string `json:"run_list"`
+	Resources []interface{} `json:"resources"`
+	Data map[string]interface{} `json:"data"`
+}
+
+/* chef-client sends times formatted with ruby's Time#to_s. */
+var timeFormats = []string{ "2006-01-02 15:04:05 -0700", time.RFC3339 }
+
+var validRunId = regexp.MustCompile(`^[A-Za-z0-9-]{1,36}$`)
+
+// Create a new report for a chef-client run that's starting on a node.
+func New(run_id string, node_name string) (*Report, util.Gerror) {
+	if !validRunId.MatchString(run_id) {
+		err := util.Errorf("Field 'run_id' invalid")
+		return nil, err
+	}
+	var found bool
+	if config.Get().UseMySQL {
+		var err error
+		found, err = checkForReportMySQL(data_store.Dbh, run_id)
+		if err != nil {
+			gerr := util.Errorf(err.Error())
+			gerr.SetStatus(http.StatusInternalServerError)
+			return nil, gerr
+		}
+	} else {
+		ds := data_store.New()
+		_, found = ds.Get("report", run_id)
+	}
+	if found {
+		err := util.Errorf("Report %s already exists", run_id)
+		err.SetStatus(http.StatusConflict)
+		return nil, err
+	}
+	rep := &Report{
+		RunId: run_id,
+		NodeName: node_name,
+		StartTime: time.Now().UTC(),
+		Status: "started",
+		Resources: make([]interface{}, 0),
+		Data: make(map[string]interface{}),
+	}
+	return rep, nil
+}
+
+// Create a new report from the JSON chef-client posts when a run starts.
+func NewFromJson(node_name string, json_report map[string]interface{}) (*Report, util.Gerror) {
+	if json_report["action"] != "start" {
+		err := util.Errorf("invalid action %v for a new report", json_report["action"])
+		return nil, err
+	}
+	run_id, verr := util.ValidateAsString(json_report["run_id"])
+	if verr != nil {
+		verr = util.Errorf("Field 'run_id' missing")
+		return nil, verr
+	}
+	rep, err := New(run_id, node_name)
+	if err != nil {
+		return nil, err
+	}
+	if st, found := json_report["start_time"]; found {
+		rep.StartTime, err = parseTime(st)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+func Get(run_id string) (*Report, error) {
+	var rep *Report
+	var found bool
+	if config.Get().UseMySQL {
+		var err error
+		rep, err = getMySQL(run_id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				found = false
+			} else {
+				return nil, err
+			}
+		} else {
+			found = true
+		}
+	} else {
+		ds := data_store.New()
+		var r interface{}
+		r, found = ds.Get("report", run_id)
+		if r != nil {
+			/* The stored report may be being read elsewhere, so a
+			 * copy is returned to be changed and saved. */
+			rc := *r.(*Report)
+			rep = &rc
+		}
+	}
+	if !found {
+		err := fmt.Errorf("Report %s not found", run_id)
+		return nil, err
+	}
+	return rep, nil
+}
+
+// Update the report with the JSON chef-client posts when a run ends.
+func (rep *Report) UpdateFromJson(json_report map[string]interface{}) util.Gerror {
+	/* Work on a copy, so the report's left alone if anything's invalid. */
+	r := *rep
+	if json_report["action"] != "end" {
+		err := util.Errorf("invalid action %v for an existing report", json_report["action"])
+		return err
+	}
+	status, verr := util.ValidateAsString(json_report["status"])
+	if verr != nil || (status != "success" && status != "failure") {
+		err := util.Errorf("Field 'status' invalid")
+		return err
+	}
+	if st, found := json_report["start_time"]; found {
+		start_time, err := parseTime(st)
+		if err != nil {
+			return err
+		}
+		r.StartTime = start_time
+	}
+	end_time := time.Now().UTC()
+	if et, found := json_report["end_time"]; found {
+		var err util.Gerror
+		end_time, err = parseTime(et)
+		if err != nil {
+			return err
+		}
+	}
+	/* total_res_count comes in as a string from chef-client, but
+	 * allow a number too. */
+	switch c := json_report["total_res_count"].(type) {
+		case string:
+			n, err := strconv.Atoi(c)
+			if err != nil {
+				return util.Errorf("Field 'total_res_count' invalid")
+			}
+			r.TotalResCount = n
+		case float64:
+			r.TotalResCount = int(c)
+		case nil:
+			r.TotalResCount = 0
+		default:
+			return util.Errorf("Field 'total_res_count' invalid")
+	}
+	switch rl := json_report["run_list"].(type) {
+		case string:
+			r.RunList = rl
+		case nil:
+			r.RunList = ""
+		default:
+			return util.Errorf("Field 'run_list' invalid")
+	}
+	switch res := json_report["resources"].(type) {
+		case []interface{}:
+			r.Resources = res
+		case nil:
+			r.Resources = make([]interface{}, 0)
+		default:
+			return util.Errorf("Field 'resources' invalid")
+	}
+	switch d := json_report["data"].(type) {
+		case map[string]interface{}:
+			r.Data = d
+		case nil:
+			r.Data = make(map[string]interface{})
+		default:
+			return util.Errorf("Field 'data' invalid")
+	}
+	r.Status = status
+	r.EndTime = &end_time
+	*rep = r
+	return nil
+}
+
+func (r *Report) Save() error {
+	if config.Get().UseMySQL {
+		return r.saveMySQL()
+	}
+	ds := data_store.New()
+	ds.Set("report", r.RunId, r)
+	return nil
+}
+
+func (r *Report) Delete() error {
+	if config.Get().UseMySQL {
+		return r.deleteMySQL()
+	}
+	ds := data_store.New()
+	ds.Delete("report", r.RunId)
+	return nil
+}
+
+// Get the reports for runs that started between from and until, newest first.
+// If node_name or status are given, only reports for that node or with that
+// status are returned. No more than rows reports are returned, unless rows is
+// 0.
+func GetReports(from time.Time, until time.Time, rows int, node_name string, status string) ([]*Report, error) {
+	if config.Get().UseMySQL {
+		return getReportsMySQL(from, until, rows, node_name, status)
+	}
+	reports := make([]*Report, 0)
+	ds := data_store.New()
+	for _, run_id := range ds.GetList("report") {
+		r, _ := Get(run_id)
+		if r == nil {
+			continue
+		}
+		if r.StartTime.Before(from) || r.StartTime.After(until) {
+			continue
+		}
+		if (node_name != "" && r.NodeName != node_name) || (status != "" && r.Status != status) {
+			continue
+		}
+		reports = append(reports, r)
+	}
+	sort.Sort(sort.Reverse(byStartTime(reports)))
+	if rows != 0 && len(reports) > rows {
+		reports = reports[:rows]
+	}
+	return reports, nil
+}
+
+// Delete the reports for runs that started before the given time. Returns the
+// number of reports deleted.
+func DeleteBefore(before time.Time) (int, error) {
+	if config.Get().UseMySQL {
+		return deleteBeforeMySQL(before)
+	}
+	deleted := 0
+	ds := data_store.New()
+	for _, run_id := range ds.GetList("report") {
+		r, _ := Get(run_id)
+		if r != nil && r.StartTime.Before(before) {
+			r.Delete()
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// A shortened version of the report, without the resources and other data,
+// for listing reports.
+func (r *Report) Summary() map[string]interface{} {
+	return map[string]interface{}{
+		"run_id": r.RunId,
+		"node_name": r.NodeName,
+		"status": r.Status,
+		"start_time": r.StartTime,
+		"end_time": r.EndTime,
+		"total_res_count": r.TotalResCount,
+	}
+}
+
+func parseTime(t interface{}) (time.Time, util.Gerror) {
+	ts, verr := util.ValidateAsString(t)
+	if verr != nil {
+		return time.Time{}, util.Errorf("invalid time %v", t)
+	}
+	for _, f := range timeFormats {
+		if pt, err := time.Parse(f, ts); err == nil {
+			return pt.UTC(), nil
+		}
+	}
+	return time.Time{}, util.Errorf("invalid time %s", ts)
+}
+
+type byStartTime []*Report
+
+func (r byStartTime) Len() int { return len(r) }
+func (r byStartTime) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byStartTime) Less(i, j int) bool { return r[i].StartTime.Before(r[j].StartTime) }
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewFromJson(t *testing.T) {
+	start := map[string]interface{}{ "action": "start", "run_id": "b4e7a4c4-52a0-4ec2-9d44-0d3ae1dc6b2f", "start_time": "2014-05-16 14:42:05 -0700" }
+	r, err := NewFromJson("node1", start)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if r.Status != "started" || r.StartTime.Hour() != 21 {
+		t.Errorf("New report had unexpected values: %v", r)
+	}
+	r.Save()
+	if _, err := NewFromJson("node1", start); err == nil {
+		t.Errorf("Created a report with a duplicate run id")
+	}
+	bad := map[string]interface{}{ "action": "end", "run_id": "abc" }
+	if _, err := NewFromJson("node1", bad); err == nil {
+		t.Errorf("Created a report with an end action")
+	}
+	r.Delete()
+}
+
+func TestUpdateFromJson(t *testing.T) {
+	r, _ := New("f00d", "node1")
+	end := map[string]interface{}{ "action": "end", "status": "failure", "total_res_count": "12", "run_list": `["recipe[foo]"]`, "resources": []interface{}{ map[string]interface{}{ "type": "file" } }, "end_time": "2014-05-16 14:43:05 -0700" }
+	err := r.UpdateFromJson(end)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if r.Status != "failure" || r.TotalResCount != 12 || len(r.Resources) != 1 || r.EndTime == nil {
+		t.Errorf("Updated report had unexpected values: %v", r)
+	}
+	end["status"] = "bogus"
+	end["total_res_count"] = "3"
+	if err = r.UpdateFromJson(end); err == nil {
+		t.Errorf("Updated a report with an invalid status")
+	}
+	if r.Status != "failure" || r.TotalResCount != 12 {
+		t.Errorf("A failed update changed the report: %v", r)
+	}
+
+	/* Changing a report from Get doesn't change the stored one until it's
+	 * saved. */
+	r.Save()
+	g, _ := Get("f00d")
+	end["status"] = "success"
+	if err = g.UpdateFromJson(end); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if s, _ := Get("f00d"); s.Status != "failure" || s.TotalResCount != 12 {
+		t.Errorf("Updating a report changed the stored report before it was saved: %v", s)
+	}
+	g.Save()
+	if s, _ := Get("f00d"); s.Status != "success" || s.TotalResCount != 3 {
+		t.Errorf("Saved report had unexpected values: %v", s)
+	}
+	g.Delete()
+}
+
+func TestGetReports(t *testing.T) {
+	now := time.Now()
+	ids := []string{ "a", "b", "c" }
+	for i, s := range []string{ "success", "failure", "success" } {
+		r, _ := New(ids[i], "node2")
+		r.StartTime = now.Add(-time.Duration(i) * time.Hour)
+		r.Status = s
+		r.Save()
+	}
+	reports, _ := GetReports(time.Time{}, now, 0, "", "")
+	if len(reports) != 3 || reports[0].RunId != "a" || reports[2].RunId != "c" {
+		t.Errorf("GetReports() returned unexpected reports: %v", reports)
+	}
+	reports, _ = GetReports(time.Time{}, now, 1, "node2", "success")
+	if len(reports) != 1 || reports[0].RunId != "a" {
+		t.Errorf("GetReports() with rows and status returned unexpected reports: %v", reports)
+	}
+	reports, _ = GetReports(now.Add(-90 * time.Minute), now, 0, "", "")
+	if len(reports) != 2 {
+		t.Errorf("GetReports() with a time range returned %d reports, expected 2", len(reports))
+	}
+	reports, _ = GetReports(time.Time{}, now, 0, "node3", "")
+	if len(reports) != 0 {
+		t.Errorf("GetReports() for another node returned reports: %v", reports)
+	}
+	del, _ := DeleteBefore(now.Add(-30 * time.Minute))
+	if del != 2 {
+		t.Errorf("DeleteBefore() deleted %d reports, expected 2", del)
+	}
+	reports, _ = GetReports(time.Time{}, now, 0, "", "")
+	if len(reports) != 1 {
+		t.Errorf("Expected one report left, got %d", len(reports))
+	}
+}
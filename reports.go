@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/client"
+	"github.com/ctdk/goiardi/node"
+	"github.com/ctdk/goiardi/report"
+	"github.com/ctdk/goiardi/util"
+	"git.tideland.biz/goas/logger"
+)
+
+func report_handler(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		return
+	}
+
+	/* Reports are found under either /reports/nodes/NODE/runs or
+	 * /reports/org/runs, optionally followed by a run id. */
+	path_array := SplitPath(r.URL.Path)
+	var node_name, run_id string
+	switch {
+		case len(path_array) >= 4 && len(path_array) <= 5 && path_array[1] == "nodes" && path_array[3] == "runs":
+			node_name = path_array[2]
+			if len(path_array) == 5 {
+				run_id = path_array[4]
+			}
+		case len(path_array) >= 3 && len(path_array) <= 4 && path_array[1] == "org" && path_array[2] == "runs":
+			if len(path_array) == 4 {
+				run_id = path_array[3]
+			}
+		default:
+			JsonErrorReport(w, r, "not found", http.StatusNotFound)
+			return
+	}
+
+	switch r.Method {
+		case "GET":
+			if opUser.IsValidator() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			if run_id != "" {
+				rep, err := report.Get(run_id)
+				if err != nil || (node_name != "" && rep.NodeName != node_name) {
+					JsonErrorReport(w, r, fmt.Sprintf("Report %s not found", run_id), http.StatusNotFound)
+					return
+				}
+				enc := json.NewEncoder(w)
+				if err = enc.Encode(&rep); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			from, until, rows, status, qerr := reportQueryParams(r)
+			if qerr != nil {
+				JsonErrorReport(w, r, qerr.Error(), http.StatusBadRequest)
+				return
+			}
+			reports, err := report.GetReports(from, until, rows, node_name, status)
+			if err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			run_history := make([]map[string]interface{}, len(reports))
+			for i, rep := range reports {
+				run_history[i] = rep.Summary()
+			}
+			response := map[string]interface{}{ "run_history": run_history }
+			enc := json.NewEncoder(w)
+			if err = enc.Encode(&response); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			}
+		case "POST":
+			if node_name == "" {
+				JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+				return
+			}
+			if !opUser.IsAdmin() && !(opUser.IsClient() && opUser.(*client.Client).NodeName == node_name) {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			report_data, jerr := parseReportJson(r)
+			if jerr != nil {
+				JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+				return
+			}
+			var rep *report.Report
+			if run_id == "" {
+				/* A run is starting */
+				var gerr util.Gerror
+				rep, gerr = report.NewFromJson(node_name, report_data)
+				if gerr != nil {
+					JsonErrorReport(w, r, gerr.Error(), gerr.Status())
+					return
+				}
+			} else {
+				var err error
+				rep, err = report.Get(run_id)
+				if err != nil || rep.NodeName != node_name {
+					JsonErrorReport(w, r, fmt.Sprintf("Report %s not found", run_id), http.StatusNotFound)
+					return
+				}
+				gerr := rep.UpdateFromJson(report_data)
+				if gerr != nil {
+					JsonErrorReport(w, r, gerr.Error(), gerr.Status())
+					return
+				}
+			}
+			if err := rep.Save(); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			updateNodeStatusFromReport(rep)
+			if run_id == "" {
+				w.WriteHeader(http.StatusCreated)
+			}
+			response := map[string]string{ "uri": util.CustomURL(fmt.Sprintf("/reports/nodes/%s/runs/%s", node_name, rep.RunId)) }
+			enc := json.NewEncoder(w)
+			if err := enc.Encode(&response); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			}
+		default:
+			JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+	}
+}
+
+/* chef-client gzips the report it sends at the end of a run. */
+func parseReportJson(r *http.Request) (map[string]interface{}, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	report_data := make(map[string]interface{})
+	dec := json.NewDecoder(body)
+	if err := dec.Decode(&report_data); err != nil {
+		return nil, err
+	}
+	return report_data, nil
+}
+
+/* The time range for report queries is given in seconds since the epoch with
+ * the "from" and "until" parameters. */
+func reportQueryParams(r *http.Request) (from time.Time, until time.Time, rows int, status string, err error) {
+	until = time.Now()
+	rows = 10
+	if f := r.FormValue("from"); f != "" {
+		var fi int64
+		if fi, err = strconv.ParseInt(f, 10, 64); err != nil {
+			err = fmt.Errorf("invalid value for 'from'")
+			return
+		}
+		from = time.Unix(fi, 0)
+	}
+	if u := r.FormValue("until"); u != "" {
+		var ui int64
+		if ui, err = strconv.ParseInt(u, 10, 64); err != nil {
+			err = fmt.Errorf("invalid value for 'until'")
+			return
+		}
+		until = time.Unix(ui, 0)
+	}
+	if ro := r.FormValue("rows"); ro != "" {
+		if rows, err = strconv.Atoi(ro); err != nil || rows < 0 {
+			err = fmt.Errorf("invalid value for 'rows'")
+			return
+		}
+	}
+	status = r.FormValue("status")
+	if status != "" && status != "started" && status != "success" && status != "failure" {
+		err = fmt.Errorf("invalid value for 'status'")
+	}
+	return
+}
+
+/* Keep the node's run status history up to date with the reports chef-client
+ * sends. */
+func updateNodeStatusFromReport(rep *report.Report) {
+	chef_node, err := node.Get(rep.NodeName)
+	if err != nil {
+		return
+	}
+	var serr util.Gerror
+	if rep.EndTime == nil {
+		_, serr = chef_node.UpdateStatus(node.RunStarted, rep.RunId, nil, rep.StartTime, time.Time{})
+	} else {
+		/* The run list comes in the report as a JSON encoded string. */
+		var run_list []string
+		var rl interface{}
+		if json.Unmarshal([]byte(rep.RunList), &rl) == nil {
+			if crl, err := chkRunList(rl); err == nil {
+				run_list, _ = util.ValidateRunList(crl)
+			}
+		}
+		_, serr = chef_node.UpdateStatus(rep.Status, rep.RunId, run_list, rep.StartTime, *rep.EndTime)
+	}
+	if serr != nil {
+		logger.Errorf("Error recording run status for node %s: %s", rep.NodeName, serr.Error())
+	}
+}
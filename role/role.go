@@ -28,10 +28,9 @@ import (
 	"fmt"
 	"net/http"
 	"database/sql"
+	"sort"
 )
 
-/* Need env_run_lists?!!? */
-
 type Role struct {
 	Name string `json:"name"`
 	ChefType string `json:"chef_type"`
@@ -45,7 +44,7 @@ type Role struct {
 
 func New(name string) (*Role, util.Gerror){
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		found, err = checkForRoleMySQL(data_store.Dbh, name)
 		if err != nil {
@@ -123,14 +122,8 @@ func (r *Role) UpdateFromJson(json_role map[string]interface{}) util.Gerror {
 		return verr
 	}
 
-	if _, erl_exists := json_role["env_run_lists"]; erl_exists { 
-		for k, v := range json_role["env_run_lists"].(map[string][]string) {
-			if json_role["env_run_lists"].(map[string][]string)[k], verr = util.ValidateRunList(v); verr != nil {
-				return verr
-			}
-		}
-	} else {
-		json_role["env_run_lists"] = make(map[string][]string)
+	if json_role["env_run_lists"], verr = validateEnvRunLists(json_role["env_run_lists"]); verr != nil {
+		return verr
 	}
 
 	attrs := []string{ "default_attributes", "override_attributes" }
@@ -186,7 +179,7 @@ func (r *Role) UpdateFromJson(json_role map[string]interface{}) util.Gerror {
 func Get(role_name string) (*Role, error){
 	var role *Role
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		role, err = getMySQL(role_name)
 		if err != nil {
@@ -214,9 +207,9 @@ func Get(role_name string) (*Role, error){
 }
 
 func (r *Role) Save() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := r.saveMySQL(); err != nil {
-			return nil
+			return err
 		}
 	} else {
 		ds := data_store.New()
@@ -227,7 +220,7 @@ func (r *Role) Save() error {
 }
 
 func (r *Role) Delete() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := r.deleteMySQL(); err != nil {
 			return err
 		}
@@ -242,7 +235,7 @@ func (r *Role) Delete() error {
 // Get a list of the roles on this server.
 func GetList() []string {
 	var role_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		role_list = getListMySQL()
 	} else {
 		ds := data_store.New()
@@ -251,6 +244,64 @@ func GetList() []string {
 	return role_list
 }
 
+// Get the run list to use for this role in the given environment. If the role
+// doesn't have a run list for that environment, the default run list is used.
+func (r *Role) RunListForEnv(env_name string) []string {
+	if env_name != "_default" {
+		if rl, found := r.EnvRunLists[env_name]; found {
+			return rl
+		}
+	}
+	return r.RunList
+}
+
+// Get the environments this role has run lists for. The list always starts
+// with "_default", which refers to the role's default run list.
+func (r *Role) Environments() []string {
+	role_envs := make([]string, 1, len(r.EnvRunLists) + 1)
+	role_envs[0] = "_default"
+	for k := range r.EnvRunLists {
+		if k != "_default" {
+			role_envs = append(role_envs, k)
+		}
+	}
+	sort.Strings(role_envs[1:])
+	return role_envs
+}
+
+func validateEnvRunLists(erl interface{}) (map[string][]string, util.Gerror) {
+	env_run_lists := make(map[string][]string)
+	switch erl := erl.(type) {
+		case map[string]interface{}:
+			for k, v := range erl {
+				if !util.ValidateEnvName(k) {
+					return nil, util.Errorf("Field 'env_run_lists' invalid")
+				}
+				rl, err := util.ValidateRunList(v)
+				if err != nil {
+					return nil, err
+				}
+				env_run_lists[k] = rl
+			}
+		case map[string][]string:
+			for k, v := range erl {
+				if !util.ValidateEnvName(k) {
+					return nil, util.Errorf("Field 'env_run_lists' invalid")
+				}
+				rl, err := util.ValidateRunList(v)
+				if err != nil {
+					return nil, err
+				}
+				env_run_lists[k] = rl
+			}
+		case nil:
+			/* no env run lists is fine */
+		default:
+			return nil, util.Errorf("Field 'env_run_lists' invalid")
+	}
+	return env_run_lists, nil
+}
+
 func (r *Role) GetName() string {
 	return r.Name
 }
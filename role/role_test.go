@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package role
+
+import (
+	"testing"
+)
+
+func TestEnvRunLists(t *testing.T) {
+	r, _ := New("erl1")
+	json_role := map[string]interface{}{
+		"name": "erl1",
+		"run_list": []string{ "recipe[foo]" },
+		"env_run_lists": map[string]interface{}{
+			"prod": []interface{}{ "bar", "role[baz]" },
+			"dev": []interface{}{},
+		},
+	}
+	if err := r.UpdateFromJson(json_role); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if rl := r.RunListForEnv("prod"); len(rl) != 2 || rl[0] != "recipe[bar]" {
+		t.Errorf("Unexpected run list for prod: %v", rl)
+	}
+	if rl := r.RunListForEnv("dev"); len(rl) != 0 {
+		t.Errorf("Unexpected run list for dev: %v", rl)
+	}
+	if rl := r.RunListForEnv("staging"); len(rl) != 1 || rl[0] != "recipe[foo]" {
+		t.Errorf("Run list for an environment without one should have been the default, got %v", rl)
+	}
+	envs := r.Environments()
+	if len(envs) != 3 || envs[0] != "_default" || envs[1] != "dev" || envs[2] != "prod" {
+		t.Errorf("Unexpected environments: %v", envs)
+	}
+	json_role["env_run_lists"] = map[string]interface{}{ "prod": "recipe[foo]" }
+	if err := r.UpdateFromJson(json_role); err == nil {
+		t.Errorf("Accepted an invalid env_run_lists")
+	}
+}
+
+func TestExpandRunList(t *testing.T) {
+	base, _ := New("base")
+	base.RunList = []string{ "recipe[ntp]", "role[web]" }
+	base.EnvRunLists = map[string][]string{ "prod": []string{ "recipe[ntp]", "recipe[monitoring]", "role[web]" } }
+	base.Save()
+	web, _ := New("web")
+	web.RunList = []string{ "recipe[nginx::server]", "role[base]", "recipe[ntp]" }
+	web.Save()
+
+	recipes, err := ExpandRunList([]string{ "role[base]", "recipe[app@1.0.0]" }, "_default")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{ "ntp", "nginx::server", "app@1.0.0" }
+	if len(recipes) != len(expected) {
+		t.Fatalf("Expanded run list %v, expected %v", recipes, expected)
+	}
+	for i, v := range expected {
+		if recipes[i] != v {
+			t.Errorf("Expanded run list %v, expected %v", recipes, expected)
+			break
+		}
+	}
+	recipes, _ = ExpandRunList([]string{ "role[base]" }, "prod")
+	if len(recipes) != 3 || recipes[1] != "monitoring" {
+		t.Errorf("Expanded run list for prod was %v", recipes)
+	}
+	exp, gerr := Expand([]string{ "ntp", "role[web]" }, "_default")
+	if gerr != nil {
+		t.Fatalf(gerr.Error())
+	}
+	if len(exp.Roles) != 2 || exp.Roles[0] != "web" || exp.Roles[1] != "base" {
+		t.Errorf("Unexpected roles applied: %v", exp.Roles)
+	}
+	if len(exp.RoleLoops) != 1 || len(exp.RoleLoops[0]) != 3 || exp.RoleLoops[0][0] != "web" || exp.RoleLoops[0][2] != "web" {
+		t.Errorf("Role loop not detected: %v", exp.RoleLoops)
+	}
+	ntp := exp.ExpandedRunList[0]
+	if ntp.Recipe != "ntp" || !ntp.Direct || len(ntp.Roles) != 2 {
+		t.Errorf("Unexpected sources for ntp: %v", ntp)
+	}
+	if _, err = ExpandRunList([]string{ "role[nonexistent]" }, "_default"); err == nil {
+		t.Errorf("Expanding a run list with a missing role should have failed")
+	}
+	base.Delete()
+	web.Delete()
+}
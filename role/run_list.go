@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package role
+
+import (
+	"github.com/ctdk/goiardi/util"
+	"net/http"
+	"strings"
+)
+
+// The result of expanding the roles in a run list for an environment.
+type RunListExpansion struct {
+	Environment string `json:"environment"`
+	RunList []string `json:"run_list"`
+	// The recipes in the order they would be run, as bare names like
+	// "foo::bar" or "foo@1.0.0".
+	Recipes []string `json:"recipes"`
+	ExpandedRunList []*ExpandedRecipe `json:"expanded_run_list"`
+	// All the roles that were applied, in the order they were expanded.
+	Roles []string `json:"roles"`
+	// Any loops of roles including each other, each starting and ending
+	// with the same role. The expansion skips the role that closes the
+	// loop, like chef-client does.
+	RoleLoops [][]string `json:"role_loops"`
+}
+
+// A recipe in an expanded run list, along with where it came from. Direct is
+// true if the recipe was in the run list being expanded, and Roles lists the
+// roles whose run lists included it.
+type ExpandedRecipe struct {
+	Recipe string `json:"recipe"`
+	Direct bool `json:"direct"`
+	Roles []string `json:"roles"`
+}
+
+type expander struct {
+	exp *RunListExpansion
+	env_name string
+	recipes map[string]*ExpandedRecipe
+	applied map[string]bool
+	stack []string
+}
+
+// Expand the roles in a run list recursively with the run lists for the given
+// environment. The run list is normalized first, so "foo" and "recipe[foo]"
+// are the same thing. Recipes are only listed once, at the point they would
+// first be run, and a role that's already been applied is not applied again.
+func Expand(run_list []string, env_name string) (*RunListExpansion, util.Gerror) {
+	rl, err := util.NormalizeRunList(append([]string{}, run_list...))
+	if err != nil {
+		return nil, err
+	}
+	e := &expander{
+		exp: &RunListExpansion{
+			Environment: env_name,
+			RunList: rl,
+			Recipes: make([]string, 0),
+			ExpandedRunList: make([]*ExpandedRecipe, 0),
+			Roles: make([]string, 0),
+			RoleLoops: make([][]string, 0),
+		},
+		env_name: env_name,
+		recipes: make(map[string]*ExpandedRecipe),
+		applied: make(map[string]bool),
+	}
+	if err = e.expand(rl); err != nil {
+		return nil, err
+	}
+	return e.exp, nil
+}
+
+// Expand the roles in a run list into the recipes they contain, using the
+// run lists for the given environment, and return the recipes in the order
+// they would be run. See Expand for details.
+func ExpandRunList(run_list []string, env_name string) ([]string, error) {
+	exp, err := Expand(run_list, env_name)
+	if err != nil {
+		return nil, err
+	}
+	return exp.Recipes, nil
+}
+
+func (e *expander) expand(run_list []string) util.Gerror {
+	for _, item := range run_list {
+		if strings.HasPrefix(item, "role[") {
+			role_name := item[5:len(item) - 1]
+			if e.applied[role_name] {
+				for i, r := range e.stack {
+					if r == role_name {
+						loop := append(append([]string{}, e.stack[i:]...), role_name)
+						e.exp.RoleLoops = append(e.exp.RoleLoops, loop)
+						break
+					}
+				}
+				continue
+			}
+			e.applied[role_name] = true
+			e.exp.Roles = append(e.exp.Roles, role_name)
+			r, err := Get(role_name)
+			if err != nil {
+				gerr := util.Errorf("role '%s' not found", role_name)
+				gerr.SetStatus(http.StatusPreconditionFailed)
+				return gerr
+			}
+			/* Role run lists are validated when the role is saved,
+			 * but normalize them again to be sure they're in the
+			 * form expected here. */
+			role_rl, gerr := util.NormalizeRunList(append([]string{}, r.RunListForEnv(e.env_name)...))
+			if gerr != nil {
+				return gerr
+			}
+			e.stack = append(e.stack, role_name)
+			gerr = e.expand(role_rl)
+			e.stack = e.stack[:len(e.stack) - 1]
+			if gerr != nil {
+				return gerr
+			}
+		} else {
+			/* Normalized run list items are either role[...] or
+			 * recipe[...]. */
+			recipe := item[7:len(item) - 1]
+			er, found := e.recipes[recipe]
+			if !found {
+				er = &ExpandedRecipe{ Recipe: recipe, Roles: make([]string, 0) }
+				e.recipes[recipe] = er
+				e.exp.Recipes = append(e.exp.Recipes, recipe)
+				e.exp.ExpandedRunList = append(e.exp.ExpandedRunList, er)
+			}
+			if len(e.stack) == 0 {
+				er.Direct = true
+			} else {
+				/* Each role is only applied once, so it can't
+				 * be listed twice. */
+				er.Roles = append(er.Roles, e.stack[len(e.stack) - 1])
+			}
+		}
+	}
+	return nil
+}
@@ -126,10 +126,11 @@ func role_handler(w http.ResponseWriter, r *http.Request){
 		switch r.Method {
 			case "GET":
 				/* If we have an environment name, return the
-				 * environment specific run_list. Otherwise,
-				 * return the environments we have run lists
-				 * for. Always at least return "_default",
-				 * which refers to run_list. */
+				 * environment specific run_list, falling back
+				 * to the default run_list if there isn't one.
+				 * Otherwise, return the environments we have
+				 * run lists for. Always at least return
+				 * "_default", which refers to run_list. */
 				if opUser.IsValidator() {
 					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
 					return
@@ -137,25 +138,13 @@ func role_handler(w http.ResponseWriter, r *http.Request){
 
 				enc := json.NewEncoder(w)
 				if environment_name != "" {
-					var run_list []string
-					if environment_name == "_default" {
-						run_list = chef_role.RunList
-					} else {
-						run_list = chef_role.EnvRunLists[environment_name]
-					}
 					resp := make(map[string][]string, 1)
-					resp["run_list"] = run_list
+					resp["run_list"] = chef_role.RunListForEnv(environment_name)
 					if err = enc.Encode(&resp); err != nil {
 						JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
 					}
 				} else {
-					role_envs := make([]string, len(chef_role.EnvRunLists) + 1)
-					role_envs[0] = "_default"
-					i := 1
-					for k, _ := range chef_role.EnvRunLists {
-						role_envs[i] = k
-						i++
-					}
+					role_envs := chef_role.Environments()
 					if err = enc.Encode(&role_envs); err != nil {
 						JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
 					}
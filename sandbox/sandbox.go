@@ -115,7 +115,7 @@ func Get(sandbox_id string) (*Sandbox, error){
 	var sandbox *Sandbox
 	var found bool
 
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		sandbox, err = getMySQL(sandbox_id)
 		if err != nil {
@@ -144,7 +144,7 @@ func Get(sandbox_id string) (*Sandbox, error){
 }
 
 func (s *Sandbox) Save() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := s.saveMySQL(); err != nil {
 			return err
 		}
@@ -156,7 +156,7 @@ func (s *Sandbox) Save() error {
 }
 
 func (s *Sandbox) Delete() error {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := s.deleteMySQL(); err != nil {
 			return nil
 		}
@@ -169,7 +169,7 @@ func (s *Sandbox) Delete() error {
 
 func GetList() []string {
 	var sandbox_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		sandbox_list = getListMySQL()
 	} else {
 		ds := data_store.New()
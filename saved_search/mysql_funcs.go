@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package saved_search
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"git.tideland.biz/goas/logger"
+	"database/sql"
+	"fmt"
+)
+
+func checkForSavedSearchMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	var id int32
+	err := dbhandle.QueryRow("SELECT id FROM saved_searches WHERE name = ?", name).Scan(&id)
+	if err == nil {
+		return true, nil
+	} else if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return false, err
+}
+
+func getMySQL(name string) (*SavedSearch, error) {
+	s := new(SavedSearch)
+	stmt, err := data_store.Dbh.Prepare("SELECT name, idx, query, cache_members FROM saved_searches WHERE name = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(name).Scan(&s.Name, &s.Index, &s.Query, &s.CacheMembers)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SavedSearch) saveMySQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	found, err := checkForSavedSearchMySQL(tx, s.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if found {
+		_, err = tx.Exec("UPDATE saved_searches SET idx = ?, query = ?, cache_members = ?, updated_at = NOW() WHERE name = ?", s.Index, s.Query, s.CacheMembers, s.Name)
+	} else {
+		_, err = tx.Exec("INSERT INTO saved_searches (name, idx, query, cache_members, created_at, updated_at) VALUES (?, ?, ?, ?, NOW(), NOW())", s.Name, s.Index, s.Query, s.CacheMembers)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (s *SavedSearch) deleteMySQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM saved_searches WHERE name = ?", s.Name)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting saved search %s had an error '%s', and then rolling back the transaction gave another error '%s'", s.Name, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func getListMySQL() []string {
+	search_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM saved_searches")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Errorf(err.Error())
+		}
+		return search_list
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			logger.Errorf(err.Error())
+			return search_list
+		}
+		search_list = append(search_list, name)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Errorf(err.Error())
+	}
+	return search_list
+}
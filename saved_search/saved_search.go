@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package saved_search stores named search queries, so that groups of objects
+// defined by a search can be found the same way by name from anywhere. A saved
+// search can also keep the objects it matched, searching again only once the
+// objects in its index have changed.
+package saved_search
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/indexer"
+	"github.com/ctdk/goiardi/search"
+	"github.com/ctdk/goiardi/util"
+	"fmt"
+	"net/http"
+	"sync"
+	"database/sql"
+)
+
+// A named search query over one of the search indexes. If CacheMembers is set,
+// the ids of the objects the search matches are kept between runs.
+type SavedSearch struct {
+	Name string `json:"name"`
+	Index string `json:"index"`
+	Query string `json:"query"`
+	CacheMembers bool `json:"cache_members"`
+}
+
+/* The cached results of saved searches, along with the generation of the
+ * index collection when the search was run. The cache is only kept in memory,
+ * like the index itself. */
+type members struct {
+	index string
+	query string
+	gen uint64
+	ids []string
+	scores map[string]float64
+}
+
+var memberCache = struct {
+	sync.Mutex
+	m map[string]*members
+}{ m: make(map[string]*members) }
+
+// Create a new saved search.
+func New(name string, index string, query string) (*SavedSearch, util.Gerror) {
+	if err := checkNewName(name); err != nil {
+		return nil, err
+	}
+	s := &SavedSearch{ Name: name, Index: index, Query: query }
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create a new saved search from uploaded JSON.
+func NewFromJson(json_search map[string]interface{}) (*SavedSearch, util.Gerror) {
+	name, verr := util.ValidateAsString(json_search["name"])
+	if verr != nil {
+		return nil, verr
+	}
+	if err := checkNewName(name); err != nil {
+		return nil, err
+	}
+	s := &SavedSearch{ Name: name }
+	if err := s.UpdateFromJson(json_search); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+/* Make sure a new saved search's name is valid and not already taken. */
+func checkNewName(name string) util.Gerror {
+	if !util.ValidateDBagName(name) {
+		err := util.Errorf("Field 'name' invalid")
+		return err
+	}
+	var found bool
+	if config.Get().UseMySQL {
+		var err error
+		found, err = checkForSavedSearchMySQL(data_store.Dbh, name)
+		if err != nil {
+			gerr := util.Errorf(err.Error())
+			gerr.SetStatus(http.StatusInternalServerError)
+			return gerr
+		}
+	} else {
+		ds := data_store.New()
+		_, found = ds.Get("saved_search", name)
+	}
+	if found {
+		err := util.Errorf("Saved search %s already exists", name)
+		err.SetStatus(http.StatusConflict)
+		return err
+	}
+	return nil
+}
+
+// Update the saved search from uploaded JSON.
+func (s *SavedSearch) UpdateFromJson(json_search map[string]interface{}) util.Gerror {
+	if n, found := json_search["name"]; found && n != s.Name {
+		return util.Errorf("Saved search name mismatch")
+	}
+	index, verr := util.ValidateAsString(json_search["index"])
+	if verr != nil {
+		return util.Errorf("Field 'index' missing or invalid")
+	}
+	query, verr := util.ValidateAsString(json_search["query"])
+	if verr != nil {
+		return util.Errorf("Field 'query' missing or invalid")
+	}
+	cache := false
+	if c, found := json_search["cache_members"]; found {
+		cache, verr = util.ValidateAsBool(c)
+		if verr != nil {
+			return util.Errorf("Field 'cache_members' invalid")
+		}
+	}
+	s.Index = index
+	s.Query = query
+	s.CacheMembers = cache
+	return s.validate()
+}
+
+/* Saved searches need an index that exists and a query that can be parsed. */
+func (s *SavedSearch) validate() util.Gerror {
+	if _, err := indexer.Generation(s.Index); err != nil {
+		return util.Errorf("Field 'index' invalid: %s", err.Error())
+	}
+	if err := search.ValidateQuery(s.Query); err != nil {
+		return util.Errorf("Field 'query' invalid: %s", err.Error())
+	}
+	return nil
+}
+
+func Get(name string) (*SavedSearch, error) {
+	var s *SavedSearch
+	var found bool
+	if config.Get().UseMySQL {
+		var err error
+		s, err = getMySQL(name)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				found = false
+			} else {
+				return nil, err
+			}
+		} else {
+			found = true
+		}
+	} else {
+		ds := data_store.New()
+		var ss interface{}
+		ss, found = ds.Get("saved_search", name)
+		if ss != nil {
+			s = ss.(*SavedSearch)
+		}
+	}
+	if !found {
+		err := fmt.Errorf("Cannot load saved search %s", name)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SavedSearch) Save() error {
+	forgetMembers(s.Name)
+	if config.Get().UseMySQL {
+		return s.saveMySQL()
+	}
+	ds := data_store.New()
+	ds.Set("saved_search", s.Name, s)
+	return nil
+}
+
+func (s *SavedSearch) Delete() error {
+	forgetMembers(s.Name)
+	if config.Get().UseMySQL {
+		return s.deleteMySQL()
+	}
+	ds := data_store.New()
+	ds.Delete("saved_search", s.Name)
+	return nil
+}
+
+// Get a list of the saved searches on this server.
+func GetList() []string {
+	if config.Get().UseMySQL {
+		return getListMySQL()
+	}
+	ds := data_store.New()
+	return ds.GetList("saved_search")
+}
+
+// Run the saved search, returning the ids of the matching objects and their
+// scores like search.SearchIds. A saved search that caches its members only
+// searches the index again if something in the index has changed since it
+// last ran.
+func (s *SavedSearch) Run() ([]string, map[string]float64, error) {
+	if !s.CacheMembers {
+		return search.SearchIds(s.Index, s.Query)
+	}
+	/* Get the generation before searching, so a change made while
+	 * searching makes the next run search again. */
+	gen, err := indexer.Generation(s.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+	memberCache.Lock()
+	m := memberCache.m[s.Name]
+	memberCache.Unlock()
+	if m == nil || m.gen != gen || m.index != s.Index || m.query != s.Query {
+		ids, scores, err := search.SearchIds(s.Index, s.Query)
+		if err != nil {
+			return nil, nil, err
+		}
+		m = &members{ index: s.Index, query: s.Query, gen: gen, ids: ids, scores: scores }
+		memberCache.Lock()
+		memberCache.m[s.Name] = m
+		memberCache.Unlock()
+	}
+	/* Callers are free to sort the ids and change the scores they get
+	 * back. */
+	ids := make([]string, len(m.ids))
+	copy(ids, m.ids)
+	scores := make(map[string]float64, len(m.scores))
+	for k, v := range m.scores {
+		scores[k] = v
+	}
+	return ids, scores, nil
+}
+
+func forgetMembers(name string) {
+	memberCache.Lock()
+	defer memberCache.Unlock()
+	delete(memberCache.m, name)
+}
+
+func (s *SavedSearch) GetName() string {
+	return s.Name
+}
+
+func (s *SavedSearch) URLType() string {
+	return "saved_searches"
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package saved_search
+
+import (
+	"testing"
+	"github.com/ctdk/goiardi/data_bag"
+	"github.com/ctdk/goiardi/indexer"
+	"sort"
+	"strings"
+)
+
+func TestNewFromJson(t *testing.T) {
+	indexer.CreateNewCollection("saved1")
+	good := map[string]interface{}{ "name": "webs", "index": "saved1", "query": "role:web AND env:prod", "cache_members": true }
+	s, err := NewFromJson(good)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if s.Index != "saved1" || !s.CacheMembers {
+		t.Errorf("New saved search had unexpected values: %v", s)
+	}
+	s.Save()
+	if _, err := NewFromJson(good); err == nil {
+		t.Errorf("Created a saved search with a duplicate name")
+	}
+	for _, bad := range []map[string]interface{}{
+		{ "name": "bad name", "index": "saved1", "query": "*:*" },
+		{ "name": "bad1", "index": "nowhere", "query": "*:*" },
+		{ "name": "bad2", "index": "saved1", "query": "role:(web" },
+		{ "name": "bad3", "index": "saved1" },
+		{ "name": "bad4", "index": "saved1", "query": "*:*", "cache_members": "yes" },
+	} {
+		if _, err := NewFromJson(bad); err == nil {
+			t.Errorf("Created a saved search from bad JSON %v", bad)
+		}
+	}
+	s.Delete()
+	if _, err := Get("webs"); err == nil {
+		t.Errorf("Saved search was still there after being deleted")
+	}
+}
+
+func TestRunCached(t *testing.T) {
+	dbag, _ := data_bag.New("saved2")
+	dbag.Save()
+	items := []map[string]interface{}{
+		{ "id": "web1", "role": "web" },
+		{ "id": "web2", "role": "web" },
+		{ "id": "db1", "role": "db" },
+	}
+	objs := make([]indexer.Indexable, 0, len(items))
+	for _, item := range items {
+		dbi, err := dbag.NewDBItem(item)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		objs = append(objs, dbi)
+	}
+	indexer.ReIndex(objs)
+
+	s, err := New("saved_webs", "saved2", "role:web")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s.CacheMembers = true
+	s.Save()
+	checkMembers(t, s, "web1 web2")
+	m := memberCache.m[s.Name]
+	checkMembers(t, s, "web1 web2")
+	if memberCache.m[s.Name] != m {
+		t.Errorf("Saved search searched again without anything in the index changing")
+	}
+	_, scores, _ := s.Run()
+	scores["web1"] = -1
+	if m.scores["web1"] == -1 {
+		t.Errorf("Changing the scores from a saved search changed its cached scores")
+	}
+
+	dbi, _ := dbag.NewDBItem(map[string]interface{}{ "id": "web3", "role": "web" })
+	indexer.ReIndex([]indexer.Indexable{ dbi })
+	checkMembers(t, s, "web1 web2 web3")
+	indexer.DeleteItemFromCollection("saved2", "web1")
+	checkMembers(t, s, "web2 web3")
+
+	s.Query = "role:db"
+	s.Save()
+	checkMembers(t, s, "db1")
+	s.Delete()
+	dbag.Delete()
+}
+
+func checkMembers(t *testing.T, s *SavedSearch, expected string) {
+	ids, _, err := s.Run()
+	if err != nil {
+		t.Errorf("running saved search %s gave an error: %s", s.Name, err)
+		return
+	}
+	sort.Strings(ids)
+	if got := strings.Join(ids, " "); got != expected {
+		t.Errorf("saved search %s found '%s', expected '%s'", s.Name, got, expected)
+	}
+}
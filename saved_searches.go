@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"fmt"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/saved_search"
+	"github.com/ctdk/goiardi/util"
+)
+
+func saved_search_handler(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		return
+	}
+
+	path_array := SplitPath(r.URL.Path)
+	var response interface{}
+
+	if len(path_array) == 1 {
+		search_response := make(map[string]string)
+		switch r.Method {
+			case "GET":
+				if opUser.IsValidator() {
+					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+					return
+				}
+				for _, s := range saved_search.GetList() {
+					search_response[s] = util.CustomURL(fmt.Sprintf("/saved_searches/%s", s))
+				}
+			case "POST":
+				if !opUser.IsAdmin() {
+					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+					return
+				}
+				search_data, jerr := ParseObjJson(r.Body)
+				if jerr != nil {
+					JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+					return
+				}
+				ss, nerr := saved_search.NewFromJson(search_data)
+				if nerr != nil {
+					JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+					return
+				}
+				if err := ss.Save(); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				search_response["uri"] = util.ObjURL(ss)
+				w.WriteHeader(http.StatusCreated)
+			default:
+				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+		}
+		response = search_response
+	} else if len(path_array) == 2 {
+		search_name := path_array[1]
+		ss, err := saved_search.Get(search_name)
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+			case "GET":
+				if opUser.IsValidator() {
+					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+					return
+				}
+			case "DELETE":
+				if !opUser.IsAdmin() {
+					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+					return
+				}
+				if err = ss.Delete(); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			case "PUT":
+				if !opUser.IsAdmin() {
+					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+					return
+				}
+				search_data, jerr := ParseObjJson(r.Body)
+				if jerr != nil {
+					JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+					return
+				}
+				if _, ok := search_data["name"]; !ok {
+					search_data["name"] = search_name
+				}
+				if uerr := ss.UpdateFromJson(search_data); uerr != nil {
+					JsonErrorReport(w, r, uerr.Error(), uerr.Status())
+					return
+				}
+				if err = ss.Save(); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			default:
+				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+		}
+		response = ss
+	} else {
+		JsonErrorReport(w, r, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -24,12 +24,18 @@ import (
 	"github.com/ctdk/goiardi/data_bag"
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/client"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/user"
 	"github.com/ctdk/goiardi/indexer"
 	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/saved_search"
 	"net/http"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
+	"sort"
 	"regexp"
 	"git.tideland.biz/goas/logger"
 )
@@ -54,6 +60,7 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 		paramsRows int
 		sortOrder string
 		start int
+		withScore bool
 	)
 	r.ParseForm()
 	if q, found := r.Form["q"]; found {
@@ -73,15 +80,17 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 	} else {
 		paramsRows = 1000
 	}
-	// TODO: get a default sort in, once sorting is sorted out.
+	/* Without a sort order, results are sorted by relevance. */
 	if s, found := r.Form["sort"]; found {
-		if len(s) > 0 {
+		if len(s) > 0 && s[0] != "" {
 			sortOrder = s[0]
 		} else {
 			sortOrder = "id ASC"
 		}
 	}
-	_ = sortOrder
+	if sc, found := r.Form["score"]; found && len(sc) > 0 {
+		withScore, _ = strconv.ParseBool(sc[0])
+	}
 	if st, found := r.Form["start"]; found {
 		if len(st) > 0 {
 			start, _ = strconv.Atoi(st[0])
@@ -106,7 +115,7 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 				return
 		}
-	} else if path_array_len == 2 {
+	} else if path_array_len == 2 || (path_array_len == 3 && path_array[1] == "_saved") {
 		switch r.Method {
 			case "GET", "POST":
 				if opUser.IsValidator() {
@@ -116,18 +125,48 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 				/* start figuring out what comes in POSTS now,
 				 * so the partial search tests don't complain
 				 * anymore. */
-				var partial_data map[string]interface{}
+				var psearchKeys map[string][]string
 				if r.Method == "POST" {
-					var perr error
-					partial_data, perr = ParseObjJson(r.Body)
+					partial_data, perr := ParseObjJson(r.Body)
+					if perr != nil {
+						JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
+						return
+					}
+					psearchKeys, perr = partialSearchKeys(partial_data)
 					if perr != nil {
 						JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
 						return
 					}
 				}
 
-				idx := path_array[1]
-				rObjs, err := search.Search(idx, paramQuery)
+				/* Saved searches are run by name, with the
+				 * index and query they were saved with. */
+				var ss *saved_search.SavedSearch
+				var idx string
+				if path_array_len == 3 {
+					var serr error
+					ss, serr = saved_search.Get(path_array[2])
+					if serr != nil {
+						JsonErrorReport(w, r, serr.Error(), http.StatusNotFound)
+						return
+					}
+					idx = ss.Index
+				} else {
+					idx = path_array[1]
+				}
+				/* Users' information isn't for everybody. */
+				if idx == "user" && !opUser.IsAdmin() {
+					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+					return
+				}
+				var ids []string
+				var scores map[string]float64
+				var err error
+				if ss != nil {
+					ids, scores, err = ss.Run()
+				} else {
+					ids, scores, err = search.SearchIds(idx, paramQuery)
+				}
 
 				if err != nil {
 					statusCode := http.StatusBadRequest
@@ -138,56 +177,51 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 					JsonErrorReport(w, r, err.Error(), statusCode)
 					return
 				}
-
-				res := make([]map[string]interface{}, len(rObjs))
-				for i, r := range rObjs {
-					switch r := r.(type) {
-						case *client.Client:
-							jc := map[string]interface{}{
-								"name": r.Name,
-								"chef_type": r.ChefType,
-								"json_class": r.JsonClass,
-								"admin": r.Admin,
-								"public_key": r.PublicKey(),
-								"validator": r.Validator,
-							}
-							res[i] = jc
-						default:
-							res[i] = util.MapifyObject(r)
-					}
+				if sortOrder != "" {
+					sortIds(ids, sortOrder)
 				}
 
-				/* If we're doing partial search, tease out the
-				 * fields we want. */
-				if r.Method == "POST" {
-					res, err = partialSearchFormat(res, partial_data)
-					if err != nil {
-						JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
-						return
-					}
-					for x, z := range res {
-						tmpRes := make(map[string]interface{})
-						switch ro := rObjs[x].(type) {
-							case *data_bag.DataBagItem:
-								dbi_url := fmt.Sprintf("/data/%s/%s", ro.DataBagName, ro.RawData["id"].(string))
-								tmpRes["url"] = util.CustomURL(dbi_url)
-							default:
-								tmpRes["url"] = util.ObjURL(rObjs[x].(util.GoiardiObj))
-							}
-						tmpRes["data"] = z
-
-						res[x] = tmpRes
-					}
+				/* Only load the objects for the requested page
+				 * of results. */
+				total := len(ids)
+				if start > total {
+					start = total
 				}
-				
 				end := start + paramsRows
-				if end > len(res) {
-					end = len(res)
+				if end > total {
+					end = total
+				}
+				ids = ids[start:end]
+
+				/* The rows are written out as they're loaded,
+				 * rather than building the whole response
+				 * first. */
+				if err := writeSearchRows(w, idx, ids, total, start, r.Method == "POST", psearchKeys, withScore, scores); err != nil {
+					logger.Debugf("Writing search results for %s failed: %s", r.URL.Path, err.Error())
+				}
+				return
+			default:
+				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+		}
+	} else if path_array_len == 3 && path_array[2] == "_explain" {
+		switch r.Method {
+			case "GET":
+				if !opUser.IsAdmin() {
+					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+					return
+				}
+				explanation, err := search.Explain(path_array[1], paramQuery)
+				if err != nil {
+					statusCode := http.StatusBadRequest
+					re := regexp.MustCompile(`^I don't know how to search for .*? data objects.`)
+					if re.MatchString(err.Error()) {
+						statusCode = http.StatusNotFound
+					}
+					JsonErrorReport(w, r, err.Error(), statusCode)
+					return
 				}
-				res = res[start:end]
-				search_response["total"] = len(res)
-				search_response["start"] = start
-				search_response["rows"] = res
+				search_response = explanation
 			default:
 				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 				return
@@ -257,6 +291,17 @@ func reindexHandler(w http.ResponseWriter, r *http.Request){
 				}
 				reindexObjs = append(reindexObjs, dbis...)
 			}
+			// as do cookbook versions and users
+			for _, cb := range cookbook.AllCookbooks() {
+				for _, cbv := range cb.AllVersions() {
+					reindexObjs = append(reindexObjs, cbv)
+				}
+			}
+			for _, uname := range user.GetList() {
+				if u, _ := user.Get(uname); u != nil {
+					reindexObjs = append(reindexObjs, u)
+				}
+			}
 			indexer.ReIndex(reindexObjs)
 			reindex_response["reindex"] = "OK"
 		default:
@@ -269,8 +314,114 @@ func reindexHandler(w http.ResponseWriter, r *http.Request){
 	}
 }
 
-func partialSearchFormat(results []map[string]interface{}, partialFormat map[string]interface{}) ([]map[string]interface{}, error) {
-	/* regularize partial search keys */
+/* How many search results are loaded at a time while writing them out. */
+const searchBatchSize = 50
+
+/* Write a page of search results, loading and encoding the objects a batch at
+ * a time so the whole page is never held in memory at once. The response is
+ * flushed after each batch, and writing blocks while the client isn't keeping
+ * up, so the next batch isn't loaded until the client's read the last one. */
+func writeSearchRows(w http.ResponseWriter, idx string, ids []string, total int, start int, partial bool, psearchKeys map[string][]string, withScore bool, scores map[string]float64) error {
+	var paths [][]string
+	if partial {
+		paths = make([][]string, 0, len(psearchKeys))
+		for _, p := range psearchKeys {
+			if len(p) > 0 {
+				paths = append(paths, p)
+			}
+		}
+	}
+	if _, err := fmt.Fprintf(w, "{\"total\":%d,\"start\":%d,\"rows\":[", total, start); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	flusher, canFlush := w.(http.Flusher)
+	sep := ""
+	for b := 0; b < len(ids); b += searchBatchSize {
+		e := b + searchBatchSize
+		if e > len(ids) {
+			e = len(ids)
+		}
+		var rObjs []indexer.Indexable
+		if partial {
+			rObjs = search.GetPartialResults(idx, ids[b:e], paths)
+		} else {
+			rObjs = search.GetResults(idx, ids[b:e])
+		}
+		for _, obj := range rObjs {
+			row := searchRow(obj, partial, psearchKeys)
+			if withScore {
+				row["score"] = scores[obj.DocId()]
+			}
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+			if err := enc.Encode(&row); err != nil {
+				return err
+			}
+			sep = ","
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+	}
+	_, err := io.WriteString(w, "]}\n")
+	return err
+}
+
+/* Format an object for search results. If we're doing partial search, tease
+ * out the fields we want. */
+func searchRow(obj indexer.Indexable, partial bool, psearchKeys map[string][]string) map[string]interface{} {
+	var row map[string]interface{}
+	switch o := obj.(type) {
+		case *client.Client:
+			row = map[string]interface{}{
+				"name": o.Name,
+				"chef_type": o.ChefType,
+				"json_class": o.JsonClass,
+				"admin": o.Admin,
+				"public_key": o.PublicKey(),
+				"validator": o.Validator,
+			}
+		case *user.User:
+			row = o.ToJson()
+			row["username"] = o.Username
+			row["email"] = o.Email
+		default:
+			row = util.MapifyObject(o)
+	}
+	if !partial {
+		return row
+	}
+	pRow := make(map[string]interface{})
+	switch o := obj.(type) {
+		case *data_bag.DataBagItem:
+			dbi_url := fmt.Sprintf("/data/%s/%s", o.DataBagName, o.RawData["id"].(string))
+			pRow["url"] = util.CustomURL(dbi_url)
+		case *cookbook.CookbookVersion:
+			cbv_url := fmt.Sprintf("/cookbooks/%s/%s", o.CookbookName, o.Version)
+			pRow["url"] = util.CustomURL(cbv_url)
+		default:
+			pRow["url"] = util.ObjURL(obj.(util.GoiardiObj))
+	}
+	pRow["data"] = partialSearchFormat([]map[string]interface{}{ row }, psearchKeys)[0]
+	return pRow
+}
+
+/* Sort search results by id, in ascending order unless the sort order ends
+ * with DESC. Sorting by other fields isn't supported yet, so they're sorted by
+ * id as well. */
+func sortIds(ids []string, sortOrder string) {
+	if strings.HasSuffix(strings.ToUpper(strings.TrimSpace(sortOrder)), " DESC") {
+		sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	} else {
+		sort.Strings(ids)
+	}
+}
+
+/* Regularize the keys posted for a partial search into the key paths to
+ * return. */
+func partialSearchKeys(partialFormat map[string]interface{}) (map[string][]string, error) {
 	psearchKeys := make(map[string][]string, len(partialFormat))
 	for k, v := range partialFormat {
 		switch v := v.(type) {
@@ -295,6 +446,10 @@ func partialSearchFormat(results []map[string]interface{}, partialFormat map[str
 				return nil, err
 		}
 	}
+	return psearchKeys, nil
+}
+
+func partialSearchFormat(results []map[string]interface{}, psearchKeys map[string][]string) []map[string]interface{} {
 	newResults := make([]map[string]interface{}, len(results))
 
 	for i, j := range results {
@@ -340,7 +495,7 @@ func partialSearchFormat(results []map[string]interface{}, partialFormat map[str
 			newResults[i][key] = pval
 		}
 	}
-	return newResults, nil
+	return newResults
 }
 
 func walk (v interface{}, keys []string) (interface{}) {
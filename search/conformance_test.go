@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"testing"
+	"github.com/ctdk/goiardi/data_bag"
+	"github.com/ctdk/goiardi/indexer"
+	"sort"
+	"strings"
+)
+
+/* Chef style queries, and the items they should find, checked against a data
+ * bag of made up servers. */
+
+var conformanceItems = []map[string]interface{}{
+	{ "id": "web1", "role": "web", "env": "prod", "os": "linux", "cpu": "4" },
+	{ "id": "web2", "role": "web", "env": "dev", "os": "linux", "cpu": "2" },
+	{ "id": "db1", "role": "db", "env": "prod", "os": "linux", "cpu": "16" },
+	{ "id": "db2", "role": "db", "env": "dev", "os": "windows", "cpu": "8" },
+	{ "id": "mon1", "role": "monitoring", "env": "prod", "os": "freebsd", "cpu": "2", "motd": "watch the other servers closely" },
+}
+
+var conformanceQueries = []struct{
+	query string
+	expected string
+}{
+	{ "role:web", "web1 web2" },
+	{ "*:*", "db1 db2 mon1 web1 web2" },
+	{ "role:web AND env:prod", "web1" },
+	{ "role:nope AND env:prod", "" },
+	{ "env:prod AND role:nope", "" },
+	{ "role:web OR role:db", "db1 db2 web1 web2" },
+	{ "role:web role:db", "db1 db2 web1 web2" },
+	{ "role:web && env:prod || role:db", "db1 db2 web1" },
+
+	/* AND binds tighter than OR */
+	{ "role:web AND env:prod OR role:db", "db1 db2 web1" },
+	{ "role:db OR role:web AND env:prod", "db1 db2 web1" },
+	{ "role:db OR role:web AND env:prod AND os:linux", "db1 db2 web1" },
+
+	/* grouping */
+	{ "role:web AND (env:prod OR env:dev)", "web1 web2" },
+	{ "(role:web OR role:db) AND env:dev", "db2 web2" },
+	{ "((role:web))", "web1 web2" },
+	{ "role:web AND (env:prod AND (os:linux OR os:windows))", "web1" },
+	{ "(role:web AND env:dev) OR (role:db AND env:prod)", "db1 web2" },
+
+	/* NOT and prohibited clauses */
+	{ "NOT role:web", "db1 db2 mon1" },
+	{ "-role:web", "db1 db2 mon1" },
+	{ "!role:web", "db1 db2 mon1" },
+	{ "NOT (role:web OR role:db)", "mon1" },
+	{ "-(role:web OR role:db)", "mon1" },
+	{ "env:prod AND NOT role:web", "db1 mon1" },
+	{ "env:prod && !role:web", "db1 mon1" },
+	{ "env:prod -role:web", "db1 mon1" },
+	{ "NOT env:dev AND NOT os:linux", "mon1" },
+	{ "env:prod AND NOT (role:web OR os:freebsd)", "db1" },
+	{ "-cpu:[4 TO 16]", "mon1 web2" },
+
+	/* required clauses */
+	{ "+env:prod role:web", "db1 mon1 web1" },
+	{ "+env:prod +os:linux", "db1 web1" },
+	{ "+env:prod +os:linux -role:db", "web1" },
+	{ "+(role:web OR role:db) +env:dev", "db2 web2" },
+
+	/* field groups */
+	{ "role:(web OR db) AND env:prod", "db1 web1" },
+	{ "role:(web db)", "db1 db2 web1 web2" },
+	{ "os:(linux AND windows)", "" },
+	{ "os:(linux AND NOT windows)", "db1 web1 web2" },
+	{ "role:(+web -db)", "web1 web2" },
+	{ "-role:(web db)", "mon1" },
+
+	/* ranges and wildcards */
+	{ "env:prod AND os:linux AND cpu:[4 TO 16]", "db1 web1" },
+	{ "cpu:{2 TO 16}", "db2 web1" },
+	{ "role:w* OR role:mon*", "mon1 web1 web2" },
+	{ "id:web? AND NOT env:dev", "web1" },
+
+	/* fuzzy and proximity */
+	{ "role:wev~", "web1 web2" },
+	{ "role:wbe~", "" },
+	{ "role:wev~0.9", "" },
+	{ "role:web~0", "web1 web2" },
+	{ "role:wev~0", "" },
+	{ "role:monitorinh~0.5", "mon1" },
+	{ "role:mon~0.5", "" },
+	{ "id:web~1", "web1 web2" },
+	{ "id:dv1~2 AND NOT role:web", "db1 db2" },
+	{ "id:dv1~1", "db1" },
+	{ "role:(wev~ OR monitorin~)", "mon1 web1 web2" },
+	{ "windoes~", "db2" },
+	{ "motd:\"watch servers\"", "" },
+	{ "motd:\"watch servers\"~1", "" },
+	{ "motd:\"watch servers\"~2", "mon1" },
+	{ "motd:\"closely watch\"~5 OR role:db", "db1 db2 mon1" },
+}
+
+func TestQueryConformance(t *testing.T) {
+	dbag, err := data_bag.New("conformance")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	dbag.Save()
+	objs := make([]indexer.Indexable, 0, len(conformanceItems))
+	for _, item := range conformanceItems {
+		dbi, err := dbag.NewDBItem(item)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		objs = append(objs, dbi)
+	}
+	indexer.ReIndex(objs)
+
+	for _, q := range conformanceQueries {
+		res, err := Search("conformance", q.query)
+		if err != nil {
+			t.Errorf("query '%s' gave an error: %s", q.query, err)
+			continue
+		}
+		found := make([]string, 0, len(res))
+		for _, r := range res {
+			found = append(found, r.DocId())
+		}
+		sort.Strings(found)
+		if got := strings.Join(found, " "); got != q.expected {
+			t.Errorf("query '%s' found '%s', expected '%s'", q.query, got, q.expected)
+		}
+	}
+	dbag.Delete()
+}
+
+func TestFuzzParams(t *testing.T) {
+	bad := []string{ "role:web~abc", "role:web~-1", "web~2x", "motd:\"watch servers\"~-1", "(role:web~abc)" }
+	for _, q := range bad {
+		if _, err := parseQuery(q); err == nil {
+			t.Errorf("query '%s' should have given an error", q)
+		}
+	}
+	good := []string{ "role:web~", "role:web~ AND env:prod", "(role:web~1)", "role:web~0.5 env:prod", "motd:\"watch servers\"~2", "role:web\\~abc" }
+	for _, q := range good {
+		if _, err := parseQuery(q); err != nil {
+			t.Errorf("query '%s' gave an error: %s", q, err)
+		}
+	}
+}
+
+var scoringQueries = []struct{
+	query string
+	expected string
+}{
+	/* matching both clauses beats matching one, and a rarer term beats a
+	 * more common one */
+	{ "role:web OR env:prod", "web1 web2 db1 mon1" },
+	{ "role:web OR env:prod^4", "web1 db1 mon1 web2" },
+	{ "role:(web db^3) AND env:prod", "db1 web1" },
+	{ "+env:prod role:monitoring", "mon1 db1 web1" },
+	{ "*:*", "db1 db2 mon1 web1 web2" },
+}
+
+func TestScoring(t *testing.T) {
+	dbag, err := data_bag.New("scoring")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	dbag.Save()
+	objs := make([]indexer.Indexable, 0, len(conformanceItems))
+	for _, item := range conformanceItems {
+		dbi, err := dbag.NewDBItem(item)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		objs = append(objs, dbi)
+	}
+	indexer.ReIndex(objs)
+
+	for _, q := range scoringQueries {
+		res, scores, err := SearchScored("scoring", q.query)
+		if err != nil {
+			t.Errorf("query '%s' gave an error: %s", q.query, err)
+			continue
+		}
+		found := make([]string, 0, len(res))
+		for i, r := range res {
+			found = append(found, r.DocId())
+			if i > 0 && scores[r.DocId()] > scores[res[i-1].DocId()] {
+				t.Errorf("query '%s' results out of order: %v", q.query, scores)
+			}
+		}
+		if got := strings.Join(found, " "); got != q.expected {
+			t.Errorf("query '%s' found '%s' in that order, expected '%s'", q.query, got, q.expected)
+		}
+	}
+	dbag.Delete()
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/ctdk/goiardi/indexer"
+	"github.com/ctdk/goiardi/util"
+	"bytes"
+	"strings"
+	"time"
+)
+
+// Explain how a query is parsed and run against the given index, for working
+// out why a query doesn't find what it should. The explanation has the query
+// chain from the parser, the tree of boolean queries built from it with how
+// many documents each clause matched and how long it took, the terms as they
+// would look in the index, and how long each step took.
+func Explain(idx string, q string) (map[string]interface{}, error) {
+	timings := make(map[string]string)
+	t := time.Now()
+	qchain, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	timings["parse"] = time.Since(t).String()
+
+	t = time.Now()
+	tree, err := buildQueryTree(qchain)
+	if err != nil {
+		return nil, err
+	}
+	timings["build"] = time.Since(t).String()
+
+	t = time.Now()
+	res, err := tree.eval(idx)
+	if err != nil {
+		return nil, err
+	}
+	timings["search"] = time.Since(t).String()
+
+	t = time.Now()
+	scores := make(map[string]float64, len(res))
+	for k := range res {
+		scores[k] = 0
+	}
+	if err := tree.score(idx, scores); err != nil {
+		return nil, err
+	}
+	timings["score"] = time.Since(t).String()
+
+	explanation := map[string]interface{}{
+		"index": idx,
+		"query": q,
+		"chain": explainChain(qchain),
+		"tree": tree.explain(),
+		"total": len(res),
+		"scores": scores,
+		"timings": timings,
+	}
+	return explanation, nil
+}
+
+/* Each link of the query chain, as the parser made it. */
+func explainChain(s Queryable) []map[string]interface{} {
+	chain := make([]map[string]interface{}, 0)
+	for ; s != nil; s = s.Next() {
+		var link map[string]interface{}
+		switch q := s.(type) {
+			case *BasicQuery:
+				link = explainTerm(q.term)
+				link["link"] = "BasicQuery"
+				link["field"] = string(q.field)
+			case *GroupedQuery:
+				terms := make([]map[string]interface{}, len(q.terms))
+				for i, v := range q.terms {
+					terms[i] = explainTerm(v)
+					terms[i]["op"] = v.op.String()
+				}
+				link = map[string]interface{}{ "link": "GroupedQuery", "field": string(q.field), "terms": terms }
+			case *RangeQuery:
+				link = map[string]interface{}{ "link": "RangeQuery", "field": string(q.field), "start": string(q.start), "end": string(q.end), "inclusive": q.inclusive }
+			case *SubQuery:
+				link = map[string]interface{}{ "link": "SubQuery", "start": q.start, "end": q.end }
+		}
+		link["op"] = s.Op().String()
+		chain = append(chain, link)
+	}
+	return chain
+}
+
+func explainTerm(t QueryTerm) map[string]interface{} {
+	term := map[string]interface{}{
+		"term": string(t.term),
+		"normalized": normalizeTerm(string(t.term)),
+		"mod": t.mod.String(),
+	}
+	if t.fuzzboost != OpNotAnOp {
+		term["fuzzboost"] = t.fuzzboost.String()
+		term["param"] = t.fuzzparam
+	}
+	return term
+}
+
+func (b *boolNode) explain() map[string]interface{} {
+	e := map[string]interface{}{
+		"type": "boolean",
+		"must": explainNodes(b.must),
+		"should": explainNodes(b.should),
+		"must_not": explainNodes(b.mustNot),
+	}
+	explainMatches(e, b.res, b.took)
+	return e
+}
+
+func (l *leafNode) explain() map[string]interface{} {
+	var e map[string]interface{}
+	switch q := l.query.(type) {
+		case *BasicQuery:
+			e = explainTerm(q.term)
+			delete(e, "mod")
+			e["field"] = string(q.field)
+			e["type"] = basicQueryType(q)
+		case *RangeQuery:
+			e = map[string]interface{}{
+				"type": "range",
+				"field": string(q.field),
+				"start": string(q.start),
+				"end": string(q.end),
+				"inclusive": q.inclusive,
+			}
+		default:
+			e = map[string]interface{}{ "type": "unknown" }
+	}
+	explainMatches(e, l.res, l.took)
+	return e
+}
+
+/* What kind of search a basic query does. */
+func basicQueryType(q *BasicQuery) string {
+	term := string(q.term.term)
+	switch {
+		case q.field == "*" && term == "*":
+			return "all"
+		case q.term.fuzzboost == OpFuzzy && strings.ContainsAny(term, " \t\n"):
+			return "phrase"
+		case q.term.fuzzboost == OpFuzzy:
+			return "fuzzy"
+		case strings.ContainsAny(term, "*?"):
+			return "wildcard"
+		case q.field == "":
+			return "text"
+	}
+	return "term"
+}
+
+/* How a term would look as an indexed value: with Lucene's backslash escapes
+ * taken out, and brackets and "::" escaped like they are when values are
+ * indexed. This is only shown when explaining a query; searches use the term
+ * as it was given. */
+func normalizeTerm(t string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(t); i++ {
+		if t[i] == '\\' && i + 1 < len(t) {
+			i++
+		}
+		b.WriteByte(t[i])
+	}
+	return util.EscapeStr(b.String())
+}
+
+func explainNodes(nodes []queryNode) []map[string]interface{} {
+	e := make([]map[string]interface{}, len(nodes))
+	for i, n := range nodes {
+		e[i] = n.explain()
+	}
+	return e
+}
+
+/* Clauses that didn't need to be searched, like the rest of an AND after one
+ * that matched nothing, are marked as skipped. The time a boolean clause took
+ * includes the time its own clauses took. */
+func explainMatches(e map[string]interface{}, res map[string]*indexer.IdxDoc, took time.Duration) {
+	if res == nil {
+		e["skipped"] = true
+	} else {
+		e["matches"] = len(res)
+		e["time"] = took.String()
+	}
+}
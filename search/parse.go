@@ -23,6 +23,8 @@ package search
 import (
 	"github.com/ctdk/goiardi/indexer"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 type Op uint8
@@ -38,6 +40,28 @@ func (f Field) String() string {
 	return string(f)
 }
 
+func (o Op) String() string {
+	switch o {
+		case OpNotAnOp:
+			return ""
+		case OpUnaryNot:
+			return "NOT"
+		case OpUnaryReq:
+			return "+"
+		case OpUnaryPro:
+			return "-"
+		case OpBinAnd:
+			return "AND"
+		case OpBinOr:
+			return "OR"
+		case OpBoost:
+			return "^"
+		case OpFuzzy:
+			return "~"
+	}
+	return fmt.Sprintf("Op(%d)", o)
+}
+
 const (
 	OpNotAnOp Op = iota
 	OpUnaryNot
@@ -55,18 +79,24 @@ const (
 	OpEndExcl
 )
 
+// The minimum similarity for a fuzzy search without a fuzz factor, same as
+// Lucene.
+const defaultFuzz = 0.5
+
 // Hold parsed tokens from the solr query
 type Token struct {
 	QueryChain Queryable
 	Latest Queryable
 }
 
-// An individual query term and its operator.
+// An individual query term and its operator. In a grouped query, op is the
+// binary operator joining the term to the next one.
 type QueryTerm struct {
 	term Term
 	mod Op
 	fuzzboost Op
 	fuzzparam string
+	op Op
 }
 
 // The no frills basic query type, without groups or ranges. Can contain regexp
@@ -132,33 +162,59 @@ type Queryable interface{
 	IsIncomplete() bool
 	// Sets the completed flag for this query chain on this link.
 	SetCompleted()
-	// Add fuzz boost to the query. NOTE: doesn't do much.
+	// Make the query's term fuzzy (OpFuzzy) or boosted (OpBoost).
 	AddFuzzBoost(Op)
-	// Add a fuzz param to the query. NOTE: doesn't do much.
+	// Add the fuzz factor, proximity, or boost for the query's term.
 	AddFuzzParam(string)
 }
 
-type GroupQueryHolder struct {
-	op Op
-	res map[string]*indexer.IdxDoc
-}
-
+// Search the index for the query's term. Any NOT, +, or - operator on the term
+// is not applied here, but by the boolean query the term is part of.
 func (q *BasicQuery) SearchIndex(idxName string) (map[string]*indexer.IdxDoc, error) {
-	notop := false
-	if (q.term.mod == OpUnaryNot) || (q.term.mod == OpUnaryPro) {
-		notop = true
+	if q.term.fuzzboost == OpFuzzy {
+		return q.searchFuzzy(idxName)
 	}
 	if q.field == "" {
-		res, err := indexer.SearchText(idxName, string(q.term.term), notop)
+		res, err := indexer.SearchText(idxName, string(q.term.term), false)
 		return res, err
 	} else {
 		searchTerm := fmt.Sprintf("%s:%s", q.field, q.term.term)
-		res, err := indexer.SearchIndex(idxName, searchTerm, notop)
+		res, err := indexer.SearchIndex(idxName, searchTerm, false)
 
 		return res, err
 	}
 }
 
+/* A fuzzy search for a single word, or a proximity search for a phrase. A
+ * fuzzy search's parameter is either the minimum similarity, if it's less than
+ * 1, or the maximum edit distance. A proximity search's parameter is how many
+ * positions out of place the words of the phrase can be. */
+func (q *BasicQuery) searchFuzzy(idxName string) (map[string]*indexer.IdxDoc, error) {
+	term := string(q.term.term)
+	if strings.ContainsAny(term, " \t\n") {
+		slop := 0
+		if q.term.fuzzparam != "" {
+			var err error
+			slop, err = strconv.Atoi(q.term.fuzzparam)
+			if err != nil {
+				err := fmt.Errorf("Proximity for phrase %s must be a whole number, not %s", term, q.term.fuzzparam)
+				return nil, err
+			}
+		}
+		return indexer.SearchPhrase(idxName, string(q.field), term, slop)
+	}
+	fuzz := defaultFuzz
+	if q.term.fuzzparam != "" {
+		var err error
+		fuzz, err = strconv.ParseFloat(q.term.fuzzparam, 64)
+		if err != nil || fuzz < 0 {
+			err := fmt.Errorf("Fuzz factor for %s must be a number that isn't negative, not %s", term, q.term.fuzzparam)
+			return nil, err
+		}
+	}
+	return indexer.SearchFuzzy(idxName, string(q.field), term, fuzz)
+}
+
 func (q *BasicQuery) AddOp(o Op) {
 	q.op = o
 }
@@ -204,7 +260,12 @@ func (q *BasicQuery) AddFuzzParam(s string){
 }
 
 func (q *GroupedQuery) AddOp(o Op) {
-	q.op = o
+	/* Operators inside the group go between its terms. */
+	if q.IsIncomplete() && len(q.terms) > 0 {
+		q.terms[len(q.terms) - 1].op = o
+	} else {
+		q.op = o
+	}
 }
 
 func (q *GroupedQuery) Op() Op {
@@ -307,48 +368,21 @@ func (q *RangeQuery) AddFuzzParam(s string) {
 	;
 }
 
+// Search the index for the terms in the group, combining the results the same
+// way as the clauses in a query.
 func (q *GroupedQuery) SearchIndex(idxName string) (map[string]*indexer.IdxDoc, error) {
-	tmpRes := make([]GroupQueryHolder, len(q.terms))
+	return q.queryTree().eval(idxName)
+}
+
+/* The group's terms as a boolean query of basic queries on the group's
+ * field. */
+func (q *GroupedQuery) queryTree() queryNode {
+	clauses := make([]*clause, len(q.terms))
 	for i, v := range q.terms {
-		tmpRes[i].op = v.mod
-		notop := false
-		if v.mod == OpUnaryNot || v.mod == OpUnaryPro {
-			notop = true
-		}
-		searchTerm := fmt.Sprintf("%s:%s", q.field, v.term)
-		r, err := indexer.SearchIndex(idxName, searchTerm, notop)
-		if err != nil {
-			return nil, err
-		}
-		tmpRes[i].res = r
-	}
-	reqOp := false
-	res := make(map[string]*indexer.IdxDoc)
-	var req map[string]*indexer.IdxDoc
-
-	// Merge the results, taking into account any + operators lurking about
-	for _, t := range tmpRes {
-		if t.op == OpUnaryReq {
-			reqOp = true
-			if req == nil {
-				req = t.res
-			} else {
-				for k := range req {
-					if _, found := t.res[k]; !found {
-						delete(req, k)
-					}
-				}
-			}
-		} else if !reqOp{
-			for k, v := range t.res {
-				res[k] = v
-			}
-		}
+		bq := &BasicQuery{ field: q.field, term: QueryTerm{ term: v.term, fuzzboost: v.fuzzboost, fuzzparam: v.fuzzparam }, complete: true }
+		clauses[i] = &clause{ node: &leafNode{ query: bq }, mod: v.mod, op: v.op }
 	}
-	if reqOp {
-		req = res
-	}
-	return res, nil
+	return buildBoolean(clauses)
 }
 
 func (q *RangeQuery) SearchIndex(idxName string) (map[string]*indexer.IdxDoc, error) {
@@ -425,6 +459,14 @@ func (z *Token) AddTermOp(o Op) {
 	z.Latest.AddTermOp(o)
 }
 
+func (z *Token) AddFuzzBoost(o Op) {
+	z.Latest.AddFuzzBoost(o)
+}
+
+func (z *Token) AddFuzzParam(s string) {
+	z.Latest.AddFuzzParam(s)
+}
+
 func (z *Token) AddRange(s string) {
 	z.Latest.AddTerm(Term(s))
 }
@@ -458,7 +500,7 @@ func (z *Token) StartRange(inclusive bool) {
 }
 
 func (z *Token) StartGrouped() {
-	if z.Latest == nil || (z.Latest != nil && !z.Latest.IsIncomplete()) {
+	if z.Latest == nil || (z.Latest != nil && !z.Latest.IsIncomplete()) || pendingOp(z.Latest) {
 		gn := new(GroupedQuery)
 		gn.op = OpBinOr
 		gn.terms = make([]QueryTerm, 0)
@@ -479,10 +521,13 @@ func (z *Token) SetCompleted() {
 
 func (z *Token) StartSubQuery(){
 	// we don't want to start a subquery if we're in a field group query
-	if z.Latest == nil || (z.Latest != nil && !z.Latest.IsIncomplete()){
+	if z.Latest == nil || (z.Latest != nil && !z.Latest.IsIncomplete()) || pendingOp(z.Latest) {
 		sq := new(SubQuery)
 		sq.start = true
 		sq.complete = true
+		if z.QueryChain == nil {
+			z.QueryChain = sq
+		}
 		if z.Latest != nil {
 			z.Latest.SetNext(sq)
 		}
@@ -503,6 +548,14 @@ func (z *Token) EndSubQuery(){
 	}
 }
 
+/* A basic query with an operator but no field or term is a placeholder for a
+ * NOT, +, or - in front of a group or range, which get their own links in the
+ * query chain. */
+func pendingOp(q Queryable) bool {
+	b, ok := q.(*BasicQuery)
+	return ok && b.field == "" && b.term.term == "" && b.term.mod != OpNotAnOp
+}
+
 func (z *Token) Evaluate() Queryable {
 	return z.QueryChain
 }
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/ctdk/goiardi/indexer"
+	"fmt"
+	"time"
+)
+
+/* The query chain from the parser is turned into a tree of boolean queries
+ * before searching, so operator precedence and the Lucene clause types work
+ * the way they do with Solr. */
+
+// A node in the tree of a parsed query.
+type queryNode interface {
+	eval(idxName string) (map[string]*indexer.IdxDoc, error)
+	// Add the node's score for each document it matched to the scores of
+	// the documents in the search results. Has to be called after eval.
+	score(idxName string, scores map[string]float64) error
+	// Describe the node, and what it matched, for explaining a query.
+	explain() map[string]interface{}
+}
+
+// A basic or range query from the query chain. The documents it matched are
+// kept for scoring, and how long it took for explaining the query.
+type leafNode struct {
+	query Queryable
+	res map[string]*indexer.IdxDoc
+	took time.Duration
+}
+
+// A boolean query. Matching documents have to match all of the MUST clauses
+// and none of the MUST_NOT clauses. If there aren't any MUST clauses they have
+// to match at least one of the SHOULD clauses, and if there are no SHOULD
+// clauses either (like a query of just "NOT foo:bar"), every document that
+// doesn't match the MUST_NOT clauses matches.
+type boolNode struct {
+	must []queryNode
+	should []queryNode
+	mustNot []queryNode
+	res map[string]*indexer.IdxDoc
+	took time.Duration
+}
+
+/* One clause of a boolean query, with its unary operator and the binary
+ * operator joining it to the next clause. */
+type clause struct {
+	node queryNode
+	mod Op
+	op Op
+}
+
+func (l *leafNode) eval(idxName string) (map[string]*indexer.IdxDoc, error) {
+	t := time.Now()
+	res, err := l.query.SearchIndex(idxName)
+	if err != nil {
+		return nil, err
+	}
+	l.res = res
+	l.took = time.Since(t)
+	return res, nil
+}
+
+func (b *boolNode) eval(idxName string) (map[string]*indexer.IdxDoc, error) {
+	t := time.Now()
+	res, err := b.search(idxName)
+	if err != nil {
+		return nil, err
+	}
+	b.res = res
+	b.took = time.Since(t)
+	return res, nil
+}
+
+func (b *boolNode) search(idxName string) (map[string]*indexer.IdxDoc, error) {
+	var res map[string]*indexer.IdxDoc
+	if len(b.must) > 0 {
+		for _, n := range b.must {
+			r, err := n.eval(idxName)
+			if err != nil {
+				return nil, err
+			}
+			if res == nil {
+				res = r
+			} else {
+				res = intersect(res, r)
+			}
+			if len(res) == 0 {
+				return res, nil
+			}
+		}
+	} else if len(b.should) > 0 {
+		res = make(map[string]*indexer.IdxDoc)
+		for _, n := range b.should {
+			r, err := n.eval(idxName)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range r {
+				res[k] = v
+			}
+		}
+	} else if len(b.mustNot) > 0 {
+		var err error
+		res, err = indexer.SearchIndex(idxName, "*:*", false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if res == nil {
+		res = make(map[string]*indexer.IdxDoc)
+	}
+	for _, n := range b.mustNot {
+		if len(res) == 0 {
+			break
+		}
+		r, err := n.eval(idxName)
+		if err != nil {
+			return nil, err
+		}
+		for k := range r {
+			delete(res, k)
+		}
+	}
+	return res, nil
+}
+
+func intersect(a map[string]*indexer.IdxDoc, b map[string]*indexer.IdxDoc) map[string]*indexer.IdxDoc {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	res := make(map[string]*indexer.IdxDoc, len(a))
+	for k, v := range a {
+		if _, found := b[k]; found {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// Build the query tree from a query chain made by the parser.
+func buildQueryTree(chain Queryable) (queryNode, error) {
+	tree, end, err := buildSubTree(chain)
+	if err != nil {
+		return nil, err
+	}
+	if end != nil {
+		err := fmt.Errorf("Unbalanced parentheses in query")
+		return nil, err
+	}
+	return tree, nil
+}
+
+/* Build the tree for the query chain up to the end of the current subquery.
+ * Returns the subquery end marker, or nil if the chain ran out first. */
+func buildSubTree(s Queryable) (queryNode, *SubQuery, error) {
+	clauses := make([]*clause, 0)
+	pending := OpNotAnOp
+	for s != nil {
+		var c *clause
+		switch q := s.(type) {
+			case *SubQuery:
+				if !q.start {
+					return buildBoolean(clauses), q, nil
+				}
+				sub, end, err := buildSubTree(q.Next())
+				if err != nil {
+					return nil, nil, err
+				}
+				if end == nil {
+					err := fmt.Errorf("Unbalanced parentheses in query")
+					return nil, nil, err
+				}
+				c = &clause{ node: sub, op: end.Op() }
+				s = end
+			case *BasicQuery:
+				if pendingOp(q) {
+					pending = q.term.mod
+					s = s.Next()
+					continue
+				}
+				c = &clause{ node: &leafNode{ query: q }, mod: q.term.mod, op: q.Op() }
+			case *GroupedQuery:
+				c = &clause{ node: q.queryTree(), op: q.Op() }
+			default:
+				c = &clause{ node: &leafNode{ query: q }, op: q.Op() }
+		}
+		if c.mod == OpNotAnOp {
+			c.mod = pending
+		}
+		pending = OpNotAnOp
+		clauses = append(clauses, c)
+		s = s.Next()
+	}
+	return buildBoolean(clauses), nil, nil
+}
+
+/* Combine the clauses of a query, with AND binding tighter than OR. Runs of
+ * clauses joined by AND become a boolean query where each clause is required,
+ * or prohibited if it has a NOT or - in front of it. Those, and the clauses
+ * joined by OR (or nothing, which is the same thing), are then the clauses of
+ * the top boolean query: required with +, prohibited with NOT or -, and
+ * optional otherwise. */
+func buildBoolean(clauses []*clause) queryNode {
+	top := make([]*clause, 0, len(clauses))
+	var conj []*clause
+	for i, c := range clauses {
+		conj = append(conj, c)
+		if c.op == OpBinAnd && i < len(clauses) - 1 {
+			continue
+		}
+		if len(conj) == 1 {
+			top = append(top, conj[0])
+		} else {
+			and := new(boolNode)
+			for _, a := range conj {
+				if a.mod == OpUnaryNot || a.mod == OpUnaryPro {
+					and.mustNot = append(and.mustNot, a.node)
+				} else {
+					and.must = append(and.must, a.node)
+				}
+			}
+			top = append(top, &clause{ node: and })
+		}
+		conj = nil
+	}
+
+	if len(top) == 1 && (top[0].mod == OpNotAnOp || top[0].mod == OpUnaryReq) {
+		return top[0].node
+	}
+	b := new(boolNode)
+	for _, c := range top {
+		switch c.mod {
+			case OpUnaryReq:
+				b.must = append(b.must, c.node)
+			case OpUnaryNot, OpUnaryPro:
+				b.mustNot = append(b.mustNot, c.node)
+			default:
+				b.should = append(b.should, c.node)
+		}
+	}
+	return b
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/ctdk/goiardi/indexer"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* Search results are scored with BM25, over the flattened fields of the
+ * indexed objects. A document's score is the sum of the scores of the
+ * required and optional clauses it matched, like Lucene's boolean queries.
+ * Prohibited clauses don't add anything. Each term in a field is usually a
+ * different value, so a term query matches a field once and the term
+ * frequency is taken to be 1. Wildcard, range, and match everything queries
+ * are scored as a constant, like Lucene does. */
+
+const (
+	bm25K1 = 1.2
+	bm25B = 0.75
+)
+
+func (b *boolNode) score(idxName string, scores map[string]float64) error {
+	for _, n := range b.must {
+		if err := n.score(idxName, scores); err != nil {
+			return err
+		}
+	}
+	for _, n := range b.should {
+		/* Optional clauses don't affect which documents match when
+		 * there are required clauses, so they weren't searched, but
+		 * they still add to the score. */
+		if len(b.must) > 0 {
+			if _, err := n.eval(idxName); err != nil {
+				return err
+			}
+		}
+		if err := n.score(idxName, scores); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *leafNode) score(idxName string, scores map[string]float64) error {
+	if len(l.res) == 0 {
+		return nil
+	}
+	field, boost, constant, err := scoreParams(l.query)
+	if err != nil {
+		return err
+	}
+	if constant {
+		for k := range l.res {
+			if _, found := scores[k]; found {
+				scores[k] += boost
+			}
+		}
+		return nil
+	}
+	stats, err := indexer.GetFieldStats(idxName, field)
+	if err != nil {
+		return err
+	}
+	n := float64(len(l.res))
+	idf := math.Log(1 + (float64(stats.NumDocs) - n + 0.5) / (n + 0.5))
+	for k, doc := range l.res {
+		if _, found := scores[k]; !found {
+			continue
+		}
+		norm := 1.0
+		if stats.AvgLen > 0 {
+			norm = 1 - bm25B + bm25B * float64(doc.FieldLen(field)) / stats.AvgLen
+		}
+		scores[k] += boost * idf * (bm25K1 + 1) / (1 + bm25K1 * norm)
+	}
+	return nil
+}
+
+/* The field a query searches, its boost, and whether it gets a constant
+ * score instead of a BM25 score. */
+func scoreParams(q Queryable) (string, float64, bool, error) {
+	switch q := q.(type) {
+		case *BasicQuery:
+			boost := 1.0
+			if q.term.fuzzboost == OpBoost && q.term.fuzzparam != "" {
+				var err error
+				boost, err = strconv.ParseFloat(q.term.fuzzparam, 64)
+				if err != nil {
+					return "", 0, false, err
+				}
+			}
+			term := string(q.term.term)
+			constant := (q.field == "*" && term == "*") || (q.term.fuzzboost != OpFuzzy && strings.ContainsAny(term, "*?"))
+			return string(q.field), boost, constant, nil
+		default:
+			return "", 1.0, true, nil
+	}
+}
@@ -765,11 +765,11 @@ func (p *Tokenizer) Execute() {
 		case RuleAction16:
 			p.AddTermOp(OpUnaryPro)
 		case RuleAction17:
-			p.AddOp(OpBoost)
+			p.AddFuzzBoost(OpBoost)
 		case RuleAction18:
-			p.AddOp(OpFuzzy)
+			p.AddFuzzBoost(OpFuzzy)
 		case RuleAction19:
-			p.AddTerm(buffer[begin:end])
+			p.AddFuzzParam(buffer[begin:end])
 		case RuleAction20:
 			p.AddTerm(buffer[begin:end])
 
@@ -889,6 +889,24 @@ func (p *Tokenizer) Init() {
 											depth++
 											{
 												position16, tokenIndex16, depth16 := position, tokenIndex, depth
+												if !rules[Ruleunary_op]() {
+													goto l17u
+												}
+												goto l16
+											l17u:
+												position, tokenIndex, depth = position16, tokenIndex16, depth16
+												if !rules[Rulefuzzy_op]() {
+													goto l17z
+												}
+												goto l16
+											l17z:
+												position, tokenIndex, depth = position16, tokenIndex16, depth16
+												if !rules[Ruleboost_op]() {
+													goto l17b
+												}
+												goto l16
+											l17b:
+												position, tokenIndex, depth = position16, tokenIndex16, depth16
 												if !rules[Rulegroup]() {
 													goto l17
 												}
@@ -1025,354 +1043,20 @@ func (p *Tokenizer) Init() {
 										goto l13
 									l14:
 										position, tokenIndex, depth = position13, tokenIndex13, depth13
-										{
-											position36 := position
-											depth++
-											{
-												switch buffer[position] {
-												case '-':
-													{
-														position38 := position
-														depth++
-														{
-															position39, tokenIndex39, depth39 := position, tokenIndex, depth
-															if !rules[Rulevalid_letter]() {
-																goto l39
-															}
-															goto l35
-														l39:
-															position, tokenIndex, depth = position39, tokenIndex39, depth39
-														}
-														{
-															position40 := position
-															depth++
-															if buffer[position] != rune('-') {
-																goto l35
-															}
-															position++
-															{
-																add(RuleAction16, position)
-															}
-															depth--
-															add(Ruleprohibited_operator, position40)
-														}
-														{
-															position42, tokenIndex42, depth42 := position, tokenIndex, depth
-															if !rules[Rulefield]() {
-																goto l43
-															}
-															goto l42
-														l43:
-															position, tokenIndex, depth = position42, tokenIndex42, depth42
-															if !rules[Rulefield_range]() {
-																goto l44
-															}
-															goto l42
-														l44:
-															position, tokenIndex, depth = position42, tokenIndex42, depth42
-															if !rules[Ruleterm]() {
-																goto l45
-															}
-															goto l42
-														l45:
-															position, tokenIndex, depth = position42, tokenIndex42, depth42
-															if !rules[Rulestring]() {
-																goto l35
-															}
-														}
-													l42:
-														depth--
-														add(Ruleprohibited_op, position38)
-													}
-													break
-												case '+':
-													{
-														position46 := position
-														depth++
-														{
-															position47, tokenIndex47, depth47 := position, tokenIndex, depth
-															{
-																position49, tokenIndex49, depth49 := position, tokenIndex, depth
-																if !rules[Rulevalid_letter]() {
-																	goto l49
-																}
-																goto l48
-															l49:
-																position, tokenIndex, depth = position49, tokenIndex49, depth49
-															}
-															if !rules[Rulerequired_operator]() {
-																goto l48
-															}
-															{
-																position50, tokenIndex50, depth50 := position, tokenIndex, depth
-																if !rules[Ruleterm]() {
-																	goto l51
-																}
-																goto l50
-															l51:
-																position, tokenIndex, depth = position50, tokenIndex50, depth50
-																if !rules[Rulestring]() {
-																	goto l48
-																}
-															}
-														l50:
-															goto l47
-														l48:
-															position, tokenIndex, depth = position47, tokenIndex47, depth47
-															if !rules[Rulerequired_operator]() {
-																goto l35
-															}
-															{
-																position52, tokenIndex52, depth52 := position, tokenIndex, depth
-																if !rules[Ruleterm]() {
-																	goto l53
-																}
-																goto l52
-															l53:
-																position, tokenIndex, depth = position52, tokenIndex52, depth52
-																if !rules[Rulestring]() {
-																	goto l35
-																}
-															}
-														l52:
-														}
-													l47:
-														depth--
-														add(Rulerequired_op, position46)
-													}
-													break
-												default:
-													{
-														add(RuleAction10, position)
-													}
-													{
-														position55 := position
-														depth++
-														{
-															position56, tokenIndex56, depth56 := position, tokenIndex, depth
-															{
-																position58 := position
-																depth++
-																if buffer[position] != rune('N') {
-																	goto l57
-																}
-																position++
-																if buffer[position] != rune('O') {
-																	goto l57
-																}
-																position++
-																if buffer[position] != rune('T') {
-																	goto l57
-																}
-																position++
-																{
-																	add(RuleAction13, position)
-																}
-																depth--
-																add(Rulenot_operator, position58)
-															}
-															if !rules[Rulespace]() {
-																goto l57
-															}
-															{
-																position60, tokenIndex60, depth60 := position, tokenIndex, depth
-																if !rules[Rulefield]() {
-																	goto l61
-																}
-																goto l60
-															l61:
-																position, tokenIndex, depth = position60, tokenIndex60, depth60
-																if !rules[Rulefield_range]() {
-																	goto l62
-																}
-																goto l60
-															l62:
-																position, tokenIndex, depth = position60, tokenIndex60, depth60
-																{
-																	switch buffer[position] {
-																	case '"':
-																		if !rules[Rulestring]() {
-																			goto l57
-																		}
-																		break
-																	case '\t', '\n', '\r', ' ', '(':
-																		if !rules[Rulegroup]() {
-																			goto l57
-																		}
-																		break
-																	default:
-																		if !rules[Ruleterm]() {
-																			goto l57
-																		}
-																		break
-																	}
-																}
-
-															}
-														l60:
-															goto l56
-														l57:
-															position, tokenIndex, depth = position56, tokenIndex56, depth56
-															{
-																position64 := position
-																depth++
-																if buffer[position] != rune('!') {
-																	goto l35
-																}
-																position++
-																{
-																	add(RuleAction14, position)
-																}
-																depth--
-																add(Rulebang_operator, position64)
-															}
-															{
-																position66, tokenIndex66, depth66 := position, tokenIndex, depth
-																if !rules[Rulespace]() {
-																	goto l66
-																}
-																goto l67
-															l66:
-																position, tokenIndex, depth = position66, tokenIndex66, depth66
-															}
-														l67:
-															{
-																position68, tokenIndex68, depth68 := position, tokenIndex, depth
-																if !rules[Rulefield]() {
-																	goto l69
-																}
-																goto l68
-															l69:
-																position, tokenIndex, depth = position68, tokenIndex68, depth68
-																if !rules[Rulefield_range]() {
-																	goto l70
-																}
-																goto l68
-															l70:
-																position, tokenIndex, depth = position68, tokenIndex68, depth68
-																{
-																	switch buffer[position] {
-																	case '"':
-																		if !rules[Rulestring]() {
-																			goto l35
-																		}
-																		break
-																	case '\t', '\n', '\r', ' ', '(':
-																		if !rules[Rulegroup]() {
-																			goto l35
-																		}
-																		break
-																	default:
-																		if !rules[Ruleterm]() {
-																			goto l35
-																		}
-																		break
-																	}
-																}
-
-															}
-														l68:
-														}
-													l56:
-														depth--
-														add(Rulenot_op, position55)
-													}
-													break
-												}
-											}
-
-											depth--
-											add(Ruleunary_op, position36)
+										if !rules[Ruleunary_op]() {
+											goto l35
 										}
 										goto l13
 									l35:
 										position, tokenIndex, depth = position13, tokenIndex13, depth13
-										{
-											position73 := position
-											depth++
-											{
-												position74, tokenIndex74, depth74 := position, tokenIndex, depth
-												if !rules[Ruleterm]() {
-													goto l75
-												}
-												goto l74
-											l75:
-												position, tokenIndex, depth = position74, tokenIndex74, depth74
-												if !rules[Rulestring]() {
-													goto l72
-												}
-											}
-										l74:
-											if buffer[position] != rune('~') {
-												goto l72
-											}
-											position++
-											{
-												add(RuleAction18, position)
-											}
-											{
-												position77, tokenIndex77, depth77 := position, tokenIndex, depth
-												if !rules[Rulefuzzy_param]() {
-													goto l77
-												}
-												goto l78
-											l77:
-												position, tokenIndex, depth = position77, tokenIndex77, depth77
-											}
-										l78:
-											{
-												position79, tokenIndex79, depth79 := position, tokenIndex, depth
-												if !rules[Rulespace]() {
-													goto l80
-												}
-												goto l79
-											l80:
-												position, tokenIndex, depth = position79, tokenIndex79, depth79
-												{
-													position81, tokenIndex81, depth81 := position, tokenIndex, depth
-													if !rules[Rulevalid_letter]() {
-														goto l81
-													}
-													goto l72
-												l81:
-													position, tokenIndex, depth = position81, tokenIndex81, depth81
-												}
-											}
-										l79:
-											depth--
-											add(Rulefuzzy_op, position73)
+										if !rules[Rulefuzzy_op]() {
+											goto l72
 										}
 										goto l13
 									l72:
 										position, tokenIndex, depth = position13, tokenIndex13, depth13
-										{
-											position82 := position
-											depth++
-											{
-												position83, tokenIndex83, depth83 := position, tokenIndex, depth
-												if !rules[Ruleterm]() {
-													goto l84
-												}
-												goto l83
-											l84:
-												position, tokenIndex, depth = position83, tokenIndex83, depth83
-												if !rules[Rulestring]() {
-													goto l11
-												}
-											}
-										l83:
-											if buffer[position] != rune('^') {
-												goto l11
-											}
-											position++
-											{
-												add(RuleAction17, position)
-											}
-											if !rules[Rulefuzzy_param]() {
-												goto l11
-											}
-											depth--
-											add(Ruleboost_op, position82)
+										if !rules[Ruleboost_op]() {
+											goto l11
 										}
 									}
 								l13:
@@ -1540,6 +1224,47 @@ func (p *Tokenizer) Init() {
 							}
 						}
 					l106:
+						{
+							position109, tokenIndex109, depth109 := position, tokenIndex, depth
+							{
+								position110, tokenIndex110, depth110 := position, tokenIndex, depth
+								if buffer[position] != rune('~') {
+									goto l111
+								}
+								position++
+								{
+									add(RuleAction18, position)
+								}
+								{
+									position112, tokenIndex112, depth112 := position, tokenIndex, depth
+									if !rules[Rulefuzzy_param]() {
+										goto l112
+									}
+									goto l113
+								l112:
+									position, tokenIndex, depth = position112, tokenIndex112, depth112
+								}
+							l113:
+								goto l110
+							l111:
+								position, tokenIndex, depth = position110, tokenIndex110, depth110
+								if buffer[position] != rune('^') {
+									goto l109
+								}
+								position++
+								{
+									add(RuleAction17, position)
+								}
+								if !rules[Rulefuzzy_param]() {
+									goto l109
+								}
+							}
+						l110:
+							goto l114
+						l109:
+							position, tokenIndex, depth = position109, tokenIndex109, depth109
+						}
+					l114:
 						depth--
 						add(Rulefield_norm, position104)
 					}
@@ -1578,7 +1303,7 @@ func (p *Tokenizer) Init() {
 			position, tokenIndex, depth = position100, tokenIndex100, depth100
 			return false
 		},
-		/* 5 field_norm <- <(Action1 field_name ':' (term / string))> */
+		/* 5 field_norm <- <(Action1 field_name ':' (term / string) (('~' Action18 fuzzy_param?) / ('^' Action17 fuzzy_param))?)> */
 		nil,
 		/* 6 field_group <- <(Action2 field_name ':' group Action3)> */
 		nil,
@@ -1881,8 +1606,315 @@ func (p *Tokenizer) Init() {
 		/* 13 operation <- <(binary_op / unary_op / fuzzy_op / boost_op)> */
 		nil,
 		/* 14 unary_op <- <((&('-') prohibited_op) | (&('+') required_op) | (&('!' | 'N') (Action10 not_op)))> */
-		nil,
-		/* 15 binary_op <- <((group / field / field_range / term) space? boolean_operator space+ body)> */
+		func() bool {
+			position35, tokenIndex35, depth35 := position, tokenIndex, depth
+			{
+				position36 := position
+				depth++
+				{
+					switch buffer[position] {
+					case '-':
+						{
+							position38 := position
+							depth++
+							{
+								position39, tokenIndex39, depth39 := position, tokenIndex, depth
+								if !rules[Rulevalid_letter]() {
+									goto l39
+								}
+								goto l35
+							l39:
+								position, tokenIndex, depth = position39, tokenIndex39, depth39
+							}
+							{
+								position40 := position
+								depth++
+								if buffer[position] != rune('-') {
+									goto l35
+								}
+								position++
+								{
+									add(RuleAction16, position)
+								}
+								depth--
+								add(Ruleprohibited_operator, position40)
+							}
+							{
+								position42, tokenIndex42, depth42 := position, tokenIndex, depth
+								if !rules[Rulegroup]() {
+									goto l43g
+								}
+								goto l42
+							l43g:
+								position, tokenIndex, depth = position42, tokenIndex42, depth42
+								if !rules[Rulefield]() {
+									goto l43
+								}
+								goto l42
+							l43:
+								position, tokenIndex, depth = position42, tokenIndex42, depth42
+								if !rules[Rulefield_range]() {
+									goto l44
+								}
+								goto l42
+							l44:
+								position, tokenIndex, depth = position42, tokenIndex42, depth42
+								if !rules[Ruleterm]() {
+									goto l45
+								}
+								goto l42
+							l45:
+								position, tokenIndex, depth = position42, tokenIndex42, depth42
+								if !rules[Rulestring]() {
+									goto l35
+								}
+							}
+						l42:
+							depth--
+							add(Ruleprohibited_op, position38)
+						}
+						break
+					case '+':
+						{
+							position46 := position
+							depth++
+							{
+								position47, tokenIndex47, depth47 := position, tokenIndex, depth
+								{
+									position49, tokenIndex49, depth49 := position, tokenIndex, depth
+									if !rules[Rulevalid_letter]() {
+										goto l49
+									}
+									goto l48
+								l49:
+									position, tokenIndex, depth = position49, tokenIndex49, depth49
+								}
+								if !rules[Rulerequired_operator]() {
+									goto l48
+								}
+								{
+									position50, tokenIndex50, depth50 := position, tokenIndex, depth
+									if !rules[Rulegroup]() {
+										goto l51g
+									}
+									goto l50
+								l51g:
+									position, tokenIndex, depth = position50, tokenIndex50, depth50
+									if !rules[Rulefield]() {
+										goto l51f
+									}
+									goto l50
+								l51f:
+									position, tokenIndex, depth = position50, tokenIndex50, depth50
+									if !rules[Rulefield_range]() {
+										goto l51r
+									}
+									goto l50
+								l51r:
+									position, tokenIndex, depth = position50, tokenIndex50, depth50
+									if !rules[Ruleterm]() {
+										goto l51t
+									}
+									goto l50
+								l51t:
+									position, tokenIndex, depth = position50, tokenIndex50, depth50
+									if !rules[Rulestring]() {
+										goto l48
+									}
+								}
+							l50:
+								goto l47
+							l48:
+								position, tokenIndex, depth = position47, tokenIndex47, depth47
+								if !rules[Rulerequired_operator]() {
+									goto l35
+								}
+								{
+									position52, tokenIndex52, depth52 := position, tokenIndex, depth
+									if !rules[Rulegroup]() {
+										goto l53g
+									}
+									goto l52
+								l53g:
+									position, tokenIndex, depth = position52, tokenIndex52, depth52
+									if !rules[Rulefield]() {
+										goto l53f
+									}
+									goto l52
+								l53f:
+									position, tokenIndex, depth = position52, tokenIndex52, depth52
+									if !rules[Rulefield_range]() {
+										goto l53r
+									}
+									goto l52
+								l53r:
+									position, tokenIndex, depth = position52, tokenIndex52, depth52
+									if !rules[Ruleterm]() {
+										goto l53t
+									}
+									goto l52
+								l53t:
+									position, tokenIndex, depth = position52, tokenIndex52, depth52
+									if !rules[Rulestring]() {
+										goto l35
+									}
+								}
+							l52:
+							}
+						l47:
+							depth--
+							add(Rulerequired_op, position46)
+						}
+						break
+					default:
+						{
+							add(RuleAction10, position)
+						}
+						{
+							position55 := position
+							depth++
+							{
+								position56, tokenIndex56, depth56 := position, tokenIndex, depth
+								{
+									position58 := position
+									depth++
+									if buffer[position] != rune('N') {
+										goto l57
+									}
+									position++
+									if buffer[position] != rune('O') {
+										goto l57
+									}
+									position++
+									if buffer[position] != rune('T') {
+										goto l57
+									}
+									position++
+									{
+										add(RuleAction13, position)
+									}
+									depth--
+									add(Rulenot_operator, position58)
+								}
+								if !rules[Rulespace]() {
+									goto l57
+								}
+								{
+									position60, tokenIndex60, depth60 := position, tokenIndex, depth
+									if !rules[Rulefield]() {
+										goto l61
+									}
+									goto l60
+								l61:
+									position, tokenIndex, depth = position60, tokenIndex60, depth60
+									if !rules[Rulefield_range]() {
+										goto l62
+									}
+									goto l60
+								l62:
+									position, tokenIndex, depth = position60, tokenIndex60, depth60
+									{
+										switch buffer[position] {
+										case '"':
+											if !rules[Rulestring]() {
+												goto l57
+											}
+											break
+										case '\t', '\n', '\r', ' ', '(':
+											if !rules[Rulegroup]() {
+												goto l57
+											}
+											break
+										default:
+											if !rules[Ruleterm]() {
+												goto l57
+											}
+											break
+										}
+									}
+
+								}
+							l60:
+								goto l56
+							l57:
+								position, tokenIndex, depth = position56, tokenIndex56, depth56
+								{
+									position64 := position
+									depth++
+									if buffer[position] != rune('!') {
+										goto l35
+									}
+									position++
+									{
+										add(RuleAction14, position)
+									}
+									depth--
+									add(Rulebang_operator, position64)
+								}
+								{
+									position66, tokenIndex66, depth66 := position, tokenIndex, depth
+									if !rules[Rulespace]() {
+										goto l66
+									}
+									goto l67
+								l66:
+									position, tokenIndex, depth = position66, tokenIndex66, depth66
+								}
+							l67:
+								{
+									position68, tokenIndex68, depth68 := position, tokenIndex, depth
+									if !rules[Rulefield]() {
+										goto l69
+									}
+									goto l68
+								l69:
+									position, tokenIndex, depth = position68, tokenIndex68, depth68
+									if !rules[Rulefield_range]() {
+										goto l70
+									}
+									goto l68
+								l70:
+									position, tokenIndex, depth = position68, tokenIndex68, depth68
+									{
+										switch buffer[position] {
+										case '"':
+											if !rules[Rulestring]() {
+												goto l35
+											}
+											break
+										case '\t', '\n', '\r', ' ', '(':
+											if !rules[Rulegroup]() {
+												goto l35
+											}
+											break
+										default:
+											if !rules[Ruleterm]() {
+												goto l35
+											}
+											break
+										}
+									}
+
+								}
+							l68:
+							}
+						l56:
+							depth--
+							add(Rulenot_op, position55)
+						}
+						break
+					}
+				}
+
+				depth--
+				add(Ruleunary_op, position36)
+			}
+			return true
+		l35:
+			position, tokenIndex, depth = position35, tokenIndex35, depth35
+			return false
+		},
+		/* 15 binary_op <- <((unary_op / fuzzy_op / boost_op / group / field / field_range / term) space? boolean_operator space+ body)> */
 		nil,
 		/* 16 boolean_operator <- <((or_operator Action11) / (and_operator Action12))> */
 		nil,
@@ -1896,7 +1928,7 @@ func (p *Tokenizer) Init() {
 		nil,
 		/* 21 bang_operator <- <('!' Action14)> */
 		nil,
-		/* 22 required_op <- <((!valid_letter required_operator (term / string)) / (required_operator (term / string)))> */
+		/* 22 required_op <- <((!valid_letter required_operator (group / field / field_range / term / string)) / (required_operator (group / field / field_range / term / string)))> */
 		nil,
 		/* 23 required_operator <- <('+' Action15)> */
 		func() bool {
@@ -1919,15 +1951,111 @@ func (p *Tokenizer) Init() {
 			position, tokenIndex, depth = position162, tokenIndex162, depth162
 			return false
 		},
-		/* 24 prohibited_op <- <(!valid_letter prohibited_operator (field / field_range / term / string))> */
+		/* 24 prohibited_op <- <(!valid_letter prohibited_operator (group / field / field_range / term / string))> */
 		nil,
 		/* 25 prohibited_operator <- <('-' Action16)> */
 		nil,
 		/* 26 boost_op <- <((term / string) '^' Action17 fuzzy_param)> */
-		nil,
+		func() bool {
+			position82, tokenIndex82, depth82 := position, tokenIndex, depth
+			{
+				position82 := position
+				depth++
+				{
+					position83, tokenIndex83, depth83 := position, tokenIndex, depth
+					if !rules[Ruleterm]() {
+						goto l84
+					}
+					goto l83
+				l84:
+					position, tokenIndex, depth = position83, tokenIndex83, depth83
+					if !rules[Rulestring]() {
+						goto l82
+					}
+				}
+			l83:
+				if buffer[position] != rune('^') {
+					goto l82
+				}
+				position++
+				{
+					add(RuleAction17, position)
+				}
+				if !rules[Rulefuzzy_param]() {
+					goto l82
+				}
+				depth--
+				add(Ruleboost_op, position82)
+			}
+			return true
+		l82:
+			position, tokenIndex, depth = position82, tokenIndex82, depth82
+			return false
+		},
 		/* 27 fuzzy_op <- <((term / string) '~' Action18 fuzzy_param? (space / !valid_letter))> */
-		nil,
-		/* 28 fuzzy_param <- <(<(([0-9] ('.' '?') [0-9]) / [0-9]+)> Action19)> */
+		func() bool {
+			position72, tokenIndex72, depth72 := position, tokenIndex, depth
+			{
+				position73 := position
+				depth++
+				{
+					position74, tokenIndex74, depth74 := position, tokenIndex, depth
+					if !rules[Ruleterm]() {
+						goto l75
+					}
+					goto l74
+				l75:
+					position, tokenIndex, depth = position74, tokenIndex74, depth74
+					if !rules[Rulestring]() {
+						goto l72
+					}
+				}
+			l74:
+				if buffer[position] != rune('~') {
+					goto l72
+				}
+				position++
+				{
+					add(RuleAction18, position)
+				}
+				{
+					position77, tokenIndex77, depth77 := position, tokenIndex, depth
+					if !rules[Rulefuzzy_param]() {
+						goto l77
+					}
+					goto l78
+				l77:
+					position, tokenIndex, depth = position77, tokenIndex77, depth77
+				}
+			l78:
+				{
+					position79, tokenIndex79, depth79 := position, tokenIndex, depth
+					if !rules[Rulespace]() {
+						goto l80
+					}
+					goto l79
+				l80:
+					position, tokenIndex, depth = position79, tokenIndex79, depth79
+					{
+						position81, tokenIndex81, depth81 := position, tokenIndex, depth
+						if !rules[Rulevalid_letter]() {
+							goto l81
+						}
+						goto l72
+					l81:
+						position, tokenIndex, depth = position81, tokenIndex81, depth81
+					}
+				}
+			l79:
+				depth--
+				add(Rulefuzzy_op, position73)
+			}
+			return true
+		l72:
+			position, tokenIndex, depth = position72, tokenIndex72, depth72
+			return false
+		},
+		/* 28 fuzzy_param <- <(<([0-9]+ ('.' [0-9]+)?)> Action19)> */
 		func() bool {
 			position169, tokenIndex169, depth169 := position, tokenIndex, depth
 			{
@@ -1936,44 +2064,47 @@ func (p *Tokenizer) Init() {
 				{
 					position171 := position
 					depth++
+					if c := buffer[position]; c < rune('0') || c > rune('9') {
+						goto l169
+					}
+					position++
+				l174:
 					{
-						position172, tokenIndex172, depth172 := position, tokenIndex, depth
+						position175, tokenIndex175, depth175 := position, tokenIndex, depth
 						if c := buffer[position]; c < rune('0') || c > rune('9') {
-							goto l173
+							goto l175
 						}
 						position++
+						goto l174
+					l175:
+						position, tokenIndex, depth = position175, tokenIndex175, depth175
+					}
+					{
+						position172, tokenIndex172, depth172 := position, tokenIndex, depth
 						if buffer[position] != rune('.') {
-							goto l173
-						}
-						position++
-						if buffer[position] != rune('?') {
-							goto l173
+							goto l172
 						}
 						position++
 						if c := buffer[position]; c < rune('0') || c > rune('9') {
-							goto l173
-						}
-						position++
-						goto l172
-					l173:
-						position, tokenIndex, depth = position172, tokenIndex172, depth172
-						if c := buffer[position]; c < rune('0') || c > rune('9') {
-							goto l169
+							goto l172
 						}
 						position++
-					l174:
+					l176:
 						{
-							position175, tokenIndex175, depth175 := position, tokenIndex, depth
+							position177, tokenIndex177, depth177 := position, tokenIndex, depth
 							if c := buffer[position]; c < rune('0') || c > rune('9') {
-								goto l175
+								goto l177
 							}
 							position++
-							goto l174
-						l175:
-							position, tokenIndex, depth = position175, tokenIndex175, depth175
+							goto l176
+						l177:
+							position, tokenIndex, depth = position177, tokenIndex177, depth177
 						}
+						goto l173
+					l172:
+						position, tokenIndex, depth = position172, tokenIndex172, depth172
 					}
-				l172:
+				l173:
 					depth--
 					add(RulePegText, position171)
 				}
@@ -1988,7 +2119,7 @@ func (p *Tokenizer) Init() {
 			position, tokenIndex, depth = position169, tokenIndex169, depth169
 			return false
 		},
-		/* 29 string <- <('"' <(term (space term)*)> '"' Action20)> */
+		/* 29 string <- <('"' <(valid_letter (space valid_letter)*)> '"' Action20)> */
 		func() bool {
 			position177, tokenIndex177, depth177 := position, tokenIndex, depth
 			{
@@ -2001,7 +2132,7 @@ func (p *Tokenizer) Init() {
 				{
 					position179 := position
 					depth++
-					if !rules[Ruleterm]() {
+					if !rules[Rulevalid_letter]() {
 						goto l177
 					}
 				l180:
@@ -2010,7 +2141,7 @@ func (p *Tokenizer) Init() {
 						if !rules[Rulespace]() {
 							goto l181
 						}
-						if !rules[Ruleterm]() {
+						if !rules[Rulevalid_letter]() {
 							goto l181
 						}
 						goto l180
@@ -2557,11 +2688,11 @@ func (p *Tokenizer) Init() {
 		nil,
 		/* 60 Action16 <- <{ p.AddTermOp(OpUnaryPro) }> */
 		nil,
-		/* 61 Action17 <- <{ p.AddOp(OpBoost) }> */
+		/* 61 Action17 <- <{ p.AddFuzzBoost(OpBoost) }> */
 		nil,
-		/* 62 Action18 <- <{ p.AddOp(OpFuzzy) }> */
+		/* 62 Action18 <- <{ p.AddFuzzBoost(OpFuzzy) }> */
 		nil,
-		/* 63 Action19 <- <{ p.AddTerm(buffer[begin:end]) }> */
+		/* 63 Action19 <- <{ p.AddFuzzParam(buffer[begin:end]) }> */
 		nil,
 		/* 64 Action20 <- <{ p.AddTerm(buffer[begin:end]) }> */
 		nil,
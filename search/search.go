@@ -24,121 +24,143 @@ import (
 	"github.com/ctdk/goiardi/environment"
 	"github.com/ctdk/goiardi/client"
 	"github.com/ctdk/goiardi/data_bag"
-	"net/url"
-	"fmt"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/user"
+	"github.com/ctdk/goiardi/metrics"
 	"git.tideland.biz/goas/logger"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
 )
 
+var searchDuration = metrics.NewHistogram("goiardi_search_duration_seconds", "How long searches took, by index.", metrics.DefBuckets, "index")
+var searchResults = metrics.NewHistogram("goiardi_search_results", "How many objects searches found, by index.", []float64{ 0, 1, 10, 100, 1000, 10000 }, "index")
+
 // Holds a parsed query and query chain to run against the index.
 type SolrQuery struct {
 	queryChain Queryable
 	idxName string
 	docs map[string]*indexer.IdxDoc
+	scores map[string]float64
 }
 
 // Parse the given query string and search the given index for any matching
-// results.
+// results. The results are in order of their relevance score, highest first.
 func Search(idx string, q string) ([]indexer.Indexable, error) {
-	/* Eventually we'll want more prep. To start, look right in the index */
-	query, qerr := url.QueryUnescape(q)
-	if qerr != nil {
-		return nil, qerr
+	objs, _, err := SearchScored(idx, q)
+	return objs, err
+}
+
+// Search the given index like Search, also returning the relevance scores of
+// the results, keyed by their document ids.
+func SearchScored(idx string, q string) ([]indexer.Indexable, map[string]float64, error) {
+	results, scores, err := SearchIds(idx, q)
+	if err != nil {
+		return nil, nil, err
 	}
-	qq := &Tokenizer{ Buffer: query }
-	qq.Init()
-	if err := qq.Parse(); err != nil {
-		return nil, err
+	objs := GetResults(idx, results)
+	return objs, scores, nil
+}
+
+// Search the given index, returning the ids of the matching objects in order
+// of their relevance score, highest first, and the scores keyed by id. The
+// objects aren't loaded, so the results can be sorted and paginated before
+// loading only the ones that are needed with GetResults.
+func SearchIds(idx string, q string) ([]string, map[string]float64, error) {
+	t := time.Now()
+	qchain, err := parseQuery(q)
+	if err != nil {
+		return nil, nil, err
 	}
-	qq.Execute()
-	qchain := qq.Evaluate()
 	d := make(map[string]*indexer.IdxDoc)
 	solrQ := &SolrQuery{ queryChain: qchain, idxName: idx, docs: d, }
 
-	_, err := solrQ.execute()
+	_, err = solrQ.execute()
+	if err != nil {
+		return nil, nil, err
+	}
+	res := solrQ.results()
+	searchDuration.Observe(time.Since(t).Seconds(), idx)
+	searchResults.Observe(float64(len(res)), idx)
+	return res, solrQ.scores, nil
+}
+
+// Check that a query can be parsed and run, without searching anything with it.
+func ValidateQuery(q string) error {
+	qchain, err := parseQuery(q)
 	if err != nil {
+		return err
+	}
+	_, err = buildQueryTree(qchain)
+	return err
+}
+
+/* Parse a query into a query chain. The query's already been decoded from the
+ * request, so it isn't unescaped again here. Doing so would turn a '+' into a
+ * space. */
+func parseQuery(q string) (Queryable, error) {
+	if err := checkFuzzParams(q); err != nil {
+		return nil, err
+	}
+	qq := &Tokenizer{ Buffer: q }
+	qq.Init()
+	if err := qq.Parse(); err != nil {
 		return nil, err
 	}
-	results := solrQ.results()
-	objs := getResults(idx, results)
-	return objs, nil
+	qq.Execute()
+	return qq.Evaluate(), nil
 }
 
-func (sq *SolrQuery) execute() (map[string]*indexer.IdxDoc, error) {
-	s := sq.queryChain
-	curOp := OpNotAnOp
-	for s != nil {
-		var r map[string]*indexer.IdxDoc
-		var err error
-		switch c := s.(type){
-			case *SubQuery:
-				_ = c
-				newq, nend, nerr := extractSubQuery(s)
-				if nerr != nil {
-					return nil, err
+var fuzzParam = regexp.MustCompile(`^(\d+(\.\d+)?)?([\s)\]}]|$)`)
+
+/* The parser takes anything after a '~' that isn't a number as the next term,
+ * so "web~abc" would be a fuzzy search for "web" and a search for "abc", and
+ * "web~-1" would leave out anything with "1". Those are mistakes, so they're
+ * caught before parsing. */
+func checkFuzzParams(q string) error {
+	quoted := false
+	for i := 0; i < len(q); i++ {
+		switch q[i] {
+			case '\\':
+				i++
+			case '"':
+				quoted = !quoted
+			case '~':
+				if quoted || fuzzParam.MatchString(q[i+1:]) {
+					continue
 				}
-				s = nend
-				d := make(map[string]*indexer.IdxDoc)
-				nsq := &SolrQuery{ queryChain: newq, idxName: sq.idxName, docs: d }
-				r, err = nsq.execute()
-			default:
-				r, err = s.SearchIndex(sq.idxName)
-		}
-		if err != nil {
-			return nil, err
-		}
-		if len(sq.docs) == 0 { // nothing in place yet
-			sq.docs = r
-		} else if curOp == OpBinOr {
-			for k, v := range r {
-				sq.docs[k] = v
-			}
-		} else if curOp == OpBinAnd {
-			newRes := make(map[string]*indexer.IdxDoc, len(sq.docs) + len(r))
-			for k, v := range sq.docs {
-				if _, found := r[k]; found {
-					newRes[k] = v
+				if i + 1 < len(q) && q[i+1] == '-' {
+					return fmt.Errorf("The fuzz factor or proximity after '~' can't be negative, in '%s'", q)
 				}
-			}
-			sq.docs = newRes
-		} else {
-			logger.Debugf("Somehow we got to what should have been an impossible state with search")
+				return fmt.Errorf("The fuzz factor or proximity after '~' must be a number, in '%s'", q)
 		}
-
-		curOp = s.Op()
-		s = s.Next()
 	}
-	return sq.docs, nil
+	return nil
 }
 
-func extractSubQuery(s Queryable) (Queryable, Queryable, error) {
-	n := 1
-	prev := s
-	s = s.Next()
-	top := s
-	for {
-		switch q := s.(type) {
-			case *SubQuery:
-				if q.start {
-					n++
-				} else {
-					n--
-				}
-		}
-		if n == 0 {
-			// we've followed this subquery chain to its end
-			prev.SetNext(nil) // snip this chain off at the end
-			return top, s, nil
-		}
-		prev = s
-		s = s.Next()
-		if s == nil {
-			break
-		}
+func (sq *SolrQuery) execute() (map[string]*indexer.IdxDoc, error) {
+	tree, err := buildQueryTree(sq.queryChain)
+	if err != nil {
+		return nil, err
 	}
-	err := fmt.Errorf("Yikes! Somehow we weren't able to finish the subquery.")
-	return nil, nil, err
+	res, err := tree.eval(sq.idxName)
+	if err != nil {
+		return nil, err
+	}
+	sq.docs = res
+	sq.scores = make(map[string]float64, len(res))
+	for k := range res {
+		sq.scores[k] = 0
+	}
+	if err := tree.score(sq.idxName, sq.scores); err != nil {
+		return nil, err
+	}
+	return sq.docs, nil
 }
 
+/* The ids of the matching documents, highest score first. Documents with the
+ * same score are sorted by id so the order is stable. */
 func (sq *SolrQuery) results() ([]string) {
 	results := make([]string, len(sq.docs))
 	n := 0
@@ -146,6 +168,39 @@ func (sq *SolrQuery) results() ([]string) {
 		results[n] = k
 		n++
 	}
+	sort.Sort(&byScore{ ids: results, scores: sq.scores })
+	return results
+}
+
+type byScore struct {
+	ids []string
+	scores map[string]float64
+}
+
+func (s *byScore) Len() int { return len(s.ids) }
+func (s *byScore) Swap(i, j int) { s.ids[i], s.ids[j] = s.ids[j], s.ids[i] }
+func (s *byScore) Less(i, j int) bool {
+	a, b := s.scores[s.ids[i]], s.scores[s.ids[j]]
+	if a != b {
+		return a > b
+	}
+	return s.ids[i] < s.ids[j]
+}
+
+// Load the objects with the given ids from the given index for a partial
+// search, which only needs the values at the given key paths. Nodes, which can
+// have a great many attributes, are loaded with only the attributes at those
+// paths. Other objects are loaded in full.
+func GetPartialResults(variety string, toGet []string, paths [][]string) []indexer.Indexable {
+	if variety != "node" {
+		return GetResults(variety, toGet)
+	}
+	results := make([]indexer.Indexable, 0, len(toGet))
+	for _, n := range toGet {
+		if node, _ := node.GetPartial(n, paths); node != nil {
+			results = append(results, node)
+		}
+	}
 	return results
 }
 
@@ -155,7 +210,8 @@ func GetEndpoints() []string {
 	return endpoints
 }
 
-func getResults(variety string, toGet []string) []indexer.Indexable {
+// Load the objects with the given ids from the given index, in the same order.
+func GetResults(variety string, toGet []string) []indexer.Indexable {
 	results := make([]indexer.Indexable, 0)
 	switch variety {
 		case "node":
@@ -182,6 +238,18 @@ func getResults(variety string, toGet []string) []indexer.Indexable {
 					results = append(results, environment)
 				}
 			}
+		case "cookbook":
+			for _, c := range toGet {
+				if cbv, _ := cookbook.GetVersionByName(c); cbv != nil {
+					results = append(results, cbv)
+				}
+			}
+		case "user":
+			for _, u := range toGet {
+				if user, _ := user.Get(u); user != nil {
+					results = append(results, user)
+				}
+			}
 		default: // It's a data bag
 			/* These may require further processing later. */
 			dbag, _ := data_bag.Get(variety)
@@ -192,6 +260,7 @@ func getResults(variety string, toGet []string) []indexer.Indexable {
 						// at least log the error for
 						// now
 						logger.Errorf(err.Error())
+						continue
 					}
 					results = append(results, dbi)
 				}
@@ -21,8 +21,11 @@ import (
 	"github.com/ctdk/goiardi/node"
 	"github.com/ctdk/goiardi/role"
 	"github.com/ctdk/goiardi/environment"
-	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/client"
 	"github.com/ctdk/goiardi/data_bag"
+	"github.com/ctdk/goiardi/indexer"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/user"
 	"fmt"
 )
 
@@ -41,10 +44,10 @@ var env1 *environment.ChefEnvironment
 var env2 *environment.ChefEnvironment
 var env3 *environment.ChefEnvironment
 var env4 *environment.ChefEnvironment
-var client1 *actor.Actor
-var client2 *actor.Actor
-var client3 *actor.Actor
-var client4 *actor.Actor
+var client1 *client.Client
+var client2 *client.Client
+var client3 *client.Client
+var client4 *client.Client
 var dbag1 *data_bag.DataBag
 var dbag2 *data_bag.DataBag
 var dbag3 *data_bag.DataBag
@@ -55,8 +58,9 @@ func makeSearchItems() int{
 	nodes := make([]*node.Node, 4)
 	roles := make([]*role.Role, 4)
 	envs := make([]*environment.ChefEnvironment, 4)
-	clients := make([]*actor.Actor, 4)
+	clients := make([]*client.Client, 4)
 	dbags := make([]*data_bag.DataBag, 4)
+	objs := make([]indexer.Indexable, 0, 20)
 
 	for i := 0; i < 4; i++ {
 		nodes[i], _ = node.New(fmt.Sprintf("node%d",i))
@@ -65,15 +69,19 @@ func makeSearchItems() int{
 		roles[i].Save()
 		envs[i], _ = environment.New(fmt.Sprintf("env%d",i))
 		envs[i].Save()
-		clients[i], _ = actor.New(fmt.Sprintf("client%d",i), "client")
+		clients[i], _ = client.New(fmt.Sprintf("client%d",i))
 		clients[i].Save()
 		dbags[i], _ = data_bag.New(fmt.Sprintf("data_bag%d",i))
 		dbags[i].Save()
 		dbi := make(map[string]interface{})
 		dbi["id"] = fmt.Sprintf("dbi%d", i)
 		dbi["foo"] = fmt.Sprintf("dbag_item_%d", i)
-		dbags[i].NewDBItem(dbi)
+		dbitem, _ := dbags[i].NewDBItem(dbi)
+		objs = append(objs, nodes[i], roles[i], envs[i], clients[i], dbitem)
 	}
+	/* Saving the objects indexes them in the background, so index them
+	 * again here to be sure they're there before searching. */
+	indexer.ReIndex(objs)
 	node1 = nodes[0]
 	node2 = nodes[1]
 	node3 = nodes[2]
@@ -154,7 +162,7 @@ func TestSearchEnvAll(t *testing.T){
 
 func TestSearchClient(t *testing.T){
 	c, _ := Search("client", "name:client1")
-	if c[0].(*actor.Actor).Name != "client1" {
+	if c[0].(*client.Client).Name != "client1" {
 		t.Errorf("nothing returned from search")
 	}
 }
@@ -179,3 +187,122 @@ func TestSearchDbagAll(t *testing.T){
 		t.Errorf("Incorrect number of items returned, expected 1, got %d", len(d))
 	}
 }
+
+func TestExplain(t *testing.T){
+	e, err := Explain("node", "name:node1 OR (name:node2 AND name:nope)")
+	if err != nil {
+		t.Fatalf("explain gave an error: %s", err)
+	}
+	if e["total"].(int) != 1 {
+		t.Errorf("explain found %v results, expected 1", e["total"])
+	}
+	tree := e["tree"].(map[string]interface{})
+	should := tree["should"].([]map[string]interface{})
+	if len(should) != 2 || should[0]["matches"].(int) != 1 {
+		t.Errorf("explain tree was wrong: %v", tree)
+	}
+	must := should[1]["must"].([]map[string]interface{})
+	if len(must) != 2 || must[0]["matches"].(int) != 1 || must[1]["matches"].(int) != 0 {
+		t.Errorf("explain tree for the AND clause was wrong: %v", should[1])
+	}
+	if len(e["chain"].([]map[string]interface{})) != 5 {
+		t.Errorf("explain chain was wrong: %v", e["chain"])
+	}
+	for _, c := range []map[string]interface{}{ tree, should[0], should[1], must[0], must[1] } {
+		if _, ok := c["time"].(string); !ok {
+			t.Errorf("explain clause didn't say how long it took: %v", c)
+		}
+	}
+
+	e, err = Explain("node", "name:node\\-1^2")
+	if err != nil {
+		t.Fatalf("explain gave an error: %s", err)
+	}
+	leaf := e["tree"].(map[string]interface{})
+	if leaf["term"] != "node\\-1" || leaf["normalized"] != "node-1" || leaf["fuzzboost"] != "^" || leaf["param"] != "2" {
+		t.Errorf("explain term was wrong: %v", leaf)
+	}
+	e, err = Explain("node", "recipes:foo\\:\\:bar\\[1\\]")
+	if err != nil {
+		t.Fatalf("explain gave an error: %s", err)
+	}
+	leaf = e["tree"].(map[string]interface{})
+	if leaf["normalized"] != "foo\\:\\:bar\\[1\\]" {
+		t.Errorf("explain didn't escape the term like the index does: %v", leaf)
+	}
+}
+
+func TestSearchIds(t *testing.T){
+	ids, scores, err := SearchIds("node", "name:node1 OR name:node2")
+	if err != nil {
+		t.Fatalf("search gave an error: %s", err)
+	}
+	if len(ids) != 2 || len(scores) != 2 {
+		t.Errorf("Incorrect number of ids returned, expected 2, got %v", ids)
+	}
+	n := GetPartialResults("node", ids[:1], [][]string{ []string{ "name" } })
+	if len(n) != 1 || n[0].DocId() != ids[0] {
+		t.Errorf("partial results for %v were wrong: %v", ids[:1], n)
+	}
+}
+
+func TestSearchCookbook(t *testing.T){
+	cb, _ := cookbook.New("searchcb")
+	cbvData := func() map[string]interface{} { return map[string]interface{}{
+		"cookbook_name": "searchcb",
+		"name": "searchcb-1.0.0",
+		"version": "1.0.0",
+		"recipes": []interface{}{},
+		"frozen?": false,
+		"metadata": map[string]interface{}{
+			"name": "searchcb",
+			"version": "1.0.0",
+			"maintainer": "ops",
+			"dependencies": map[string]interface{}{ "apache2": ">= 1.0.0" },
+			"platforms": map[string]interface{}{ "ubuntu": ">= 12.04" },
+			"recipes": map[string]interface{}{ "searchcb::web": "web servers" },
+		},
+	} }
+	cbv, err := cb.NewVersion("1.0.0", cbvData())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	indexer.ReIndex([]indexer.Indexable{ cbv })
+	for _, q := range []string{ "maintainer:ops", "dependencies:apache2", "platforms:ubuntu", "recipes:searchcb\\:\\:web", "cookbook_name:searchcb AND version:1.0.0" } {
+		c, err := Search("cookbook", q)
+		if err != nil {
+			t.Errorf("search for %s gave an error: %s", q, err)
+		} else if len(c) != 1 || c[0].DocId() != "searchcb-1.0.0" {
+			t.Errorf("search for %s found %v, expected searchcb-1.0.0", q, c)
+		}
+	}
+	cb.DeleteVersion("1.0.0")
+	if c, _ := Search("cookbook", "maintainer:ops"); len(c) != 0 {
+		t.Errorf("deleted cookbook version still found: %v", c)
+	}
+
+	cbv, err = cb.NewVersion("1.0.0", cbvData())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	indexer.ReIndex([]indexer.Indexable{ cbv })
+	cb.Delete()
+	if c, _ := Search("cookbook", "maintainer:ops"); len(c) != 0 {
+		t.Errorf("version of deleted cookbook still found: %v", c)
+	}
+}
+
+func TestSearchUser(t *testing.T){
+	u, _ := user.New("searchuser")
+	u.Email = "searchuser@example.com"
+	u.Admin = true
+	u.Save()
+	indexer.ReIndex([]indexer.Indexable{ u })
+	n, err := Search("user", "email:searchuser@example.com AND admin:true")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(n) != 1 || n[0].DocId() != "searchuser" {
+		t.Errorf("search for users found %v, expected searchuser", n)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/indexer"
+	"git.tideland.biz/goas/logger"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Requests that change anything hold dataLock for reading while they run, and
+ * saving the data store and index holds it for writing. That way a save can't
+ * land between a request changing the data store and the index being updated
+ * to match. */
+var dataLock sync.RWMutex
+
+/* Could the request change anything? GETs and HEADs don't, and neither do the
+ * POSTs that look things up: searches, and solving cookbook dependencies or
+ * expanding run lists for an environment. */
+func changesData(r *http.Request) bool {
+	switch r.Method {
+		case "GET", "HEAD":
+			return false
+		case "POST":
+			p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			switch {
+				case p[0] == "search":
+					return len(p) > 1 && p[1] == "reindex"
+				case p[0] == "environments" && len(p) == 3:
+					return p[2] != "cookbook_versions" && p[2] != "run_list_expand"
+			}
+	}
+	return true
+}
+
+/* Closed once goiardi has finished shutting down. */
+var shutdownDone = make(chan struct{})
+
+/* Stop accepting connections, give requests that are running until the
+ * shutdown timeout to finish, then save the data store and index one last
+ * time and close the database. If requests changing data are still running
+ * at the timeout, the final save is skipped; the files from the last save
+ * before then at least match each other. */
+func shutdown(servers []*http.Server) {
+	timeout := config.Get().ShutdownTimeoutDur
+	logger.Infof("Shutting down, waiting up to %s for requests to finish...", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Warningf("Requests to %s were still running after %s: %s", srv.Addr, timeout, err.Error())
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	/* Don't save over the files with whatever's been loaded so far if
+	 * goiardi's still starting up. */
+	if config.Get().FreezeData && isReady() {
+		deadline, _ := ctx.Deadline()
+		if lockData(deadline.Sub(time.Now())) {
+			indexer.WaitForIndexing()
+			writeData()
+		} else {
+			logger.Errorf("Requests changing data were still running after %s. Not saving the data store and index, since they may not match; changes since the last save are lost.", timeout)
+		}
+	}
+	if config.Get().UseMySQL {
+		data_store.Dbh.Close()
+	}
+	logger.Infof("Shut down.")
+	close(shutdownDone)
+}
+
+/* Try to lock dataLock for writing, giving up after the timeout. If it gives
+ * up, the lock is still taken whenever it's free, which keeps any more changes
+ * from being made while goiardi exits. The lock is never released either way,
+ * since goiardi's about to exit. */
+func lockData(timeout time.Duration) bool {
+	locked := make(chan struct{})
+	go func() {
+		dataLock.Lock()
+		close(locked)
+	}()
+	if timeout < 0 {
+		timeout = 0
+	}
+	select {
+		case <-locked:
+			return true
+		case <-time.After(timeout):
+			return false
+	}
+}
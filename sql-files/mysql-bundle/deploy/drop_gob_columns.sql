@@ -0,0 +1,67 @@
+-- Deploy drop_gob_columns
+
+-- Remove the gob encoded columns left behind by json_columns. This change is
+-- in its own plan, drop_gob_columns.plan, so it isn't deployed along with the
+-- rest of the bundle: goiardi converts the old data to JSON when it starts up,
+-- so start goiardi at least once after deploying json_columns first. This
+-- refuses to run while any row still has gob encoded data.
+
+DROP PROCEDURE IF EXISTS goiardi_check_gob_columns;
+
+DELIMITER //
+CREATE PROCEDURE goiardi_check_gob_columns()
+BEGIN
+	IF EXISTS (SELECT 1 FROM nodes WHERE run_list_gob IS NOT NULL OR automatic_attr_gob IS NOT NULL OR normal_attr_gob IS NOT NULL OR default_attr_gob IS NOT NULL OR override_attr_gob IS NOT NULL)
+	OR EXISTS (SELECT 1 FROM roles WHERE run_list_gob IS NOT NULL OR env_run_lists_gob IS NOT NULL OR default_attr_gob IS NOT NULL OR override_attr_gob IS NOT NULL)
+	OR EXISTS (SELECT 1 FROM environments WHERE default_attr_gob IS NOT NULL OR override_attr_gob IS NOT NULL OR cookbook_vers_gob IS NOT NULL)
+	OR EXISTS (SELECT 1 FROM data_bag_items WHERE raw_data_gob IS NOT NULL)
+	OR EXISTS (SELECT 1 FROM sandboxes WHERE checksums_gob IS NOT NULL)
+	OR EXISTS (SELECT 1 FROM cookbook_versions WHERE metadata_gob IS NOT NULL OR definitions_gob IS NOT NULL OR libraries_gob IS NOT NULL OR attributes_gob IS NOT NULL OR recipes_gob IS NOT NULL OR providers_gob IS NOT NULL OR resources_gob IS NOT NULL OR templates_gob IS NOT NULL OR root_files_gob IS NOT NULL OR files_gob IS NOT NULL)
+	THEN
+		SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Some rows still have gob encoded data. Start goiardi to convert it to JSON before dropping the gob columns.';
+	END IF;
+END//
+DELIMITER ;
+
+CALL goiardi_check_gob_columns();
+DROP PROCEDURE goiardi_check_gob_columns;
+
+BEGIN;
+
+ALTER TABLE nodes
+	DROP COLUMN run_list_gob,
+	DROP COLUMN automatic_attr_gob,
+	DROP COLUMN normal_attr_gob,
+	DROP COLUMN default_attr_gob,
+	DROP COLUMN override_attr_gob;
+
+ALTER TABLE roles
+	DROP COLUMN run_list_gob,
+	DROP COLUMN env_run_lists_gob,
+	DROP COLUMN default_attr_gob,
+	DROP COLUMN override_attr_gob;
+
+ALTER TABLE environments
+	DROP COLUMN default_attr_gob,
+	DROP COLUMN override_attr_gob,
+	DROP COLUMN cookbook_vers_gob;
+
+ALTER TABLE data_bag_items
+	DROP COLUMN raw_data_gob;
+
+ALTER TABLE sandboxes
+	DROP COLUMN checksums_gob;
+
+ALTER TABLE cookbook_versions
+	DROP COLUMN metadata_gob,
+	DROP COLUMN definitions_gob,
+	DROP COLUMN libraries_gob,
+	DROP COLUMN attributes_gob,
+	DROP COLUMN recipes_gob,
+	DROP COLUMN providers_gob,
+	DROP COLUMN resources_gob,
+	DROP COLUMN templates_gob,
+	DROP COLUMN root_files_gob,
+	DROP COLUMN files_gob;
+
+COMMIT;
@@ -0,0 +1,71 @@
+-- Deploy json_columns
+
+-- Store run lists, attributes, and the like as JSON instead of gob encoded
+-- blobs. The old columns are renamed to <column>_gob; goiardi converts the
+-- data in them to JSON when it starts up and then clears them out. Once
+-- that's done, deploy drop_gob_columns.plan to remove them. Requires MySQL
+-- 5.7.8 or later.
+
+BEGIN;
+
+ALTER TABLE nodes
+	CHANGE run_list run_list_gob blob,
+	CHANGE automatic_attr automatic_attr_gob blob,
+	CHANGE normal_attr normal_attr_gob blob,
+	CHANGE default_attr default_attr_gob blob,
+	CHANGE override_attr override_attr_gob blob,
+	ADD COLUMN run_list json AFTER run_list_gob,
+	ADD COLUMN automatic_attr json AFTER automatic_attr_gob,
+	ADD COLUMN normal_attr json AFTER normal_attr_gob,
+	ADD COLUMN default_attr json AFTER default_attr_gob,
+	ADD COLUMN override_attr json AFTER override_attr_gob;
+
+ALTER TABLE roles
+	CHANGE run_list run_list_gob blob,
+	CHANGE env_run_lists env_run_lists_gob blob,
+	CHANGE default_attr default_attr_gob blob,
+	CHANGE override_attr override_attr_gob blob,
+	ADD COLUMN run_list json AFTER run_list_gob,
+	ADD COLUMN env_run_lists json AFTER env_run_lists_gob,
+	ADD COLUMN default_attr json AFTER default_attr_gob,
+	ADD COLUMN override_attr json AFTER override_attr_gob;
+
+ALTER TABLE environments
+	CHANGE default_attr default_attr_gob blob,
+	CHANGE override_attr override_attr_gob blob,
+	CHANGE cookbook_vers cookbook_vers_gob blob,
+	ADD COLUMN default_attr json AFTER default_attr_gob,
+	ADD COLUMN override_attr json AFTER override_attr_gob,
+	ADD COLUMN cookbook_vers json AFTER cookbook_vers_gob;
+
+ALTER TABLE data_bag_items
+	CHANGE raw_data raw_data_gob blob,
+	ADD COLUMN raw_data json AFTER raw_data_gob;
+
+ALTER TABLE sandboxes
+	CHANGE checksums checksums_gob blob,
+	ADD COLUMN checksums json AFTER checksums_gob;
+
+ALTER TABLE cookbook_versions
+	CHANGE metadata metadata_gob blob,
+	CHANGE definitions definitions_gob blob,
+	CHANGE libraries libraries_gob blob,
+	CHANGE attributes attributes_gob blob,
+	CHANGE recipes recipes_gob blob,
+	CHANGE providers providers_gob blob,
+	CHANGE resources resources_gob blob,
+	CHANGE templates templates_gob blob,
+	CHANGE root_files root_files_gob blob,
+	CHANGE files files_gob blob,
+	ADD COLUMN metadata json AFTER metadata_gob,
+	ADD COLUMN definitions json AFTER definitions_gob,
+	ADD COLUMN libraries json AFTER libraries_gob,
+	ADD COLUMN attributes json AFTER attributes_gob,
+	ADD COLUMN recipes json AFTER recipes_gob,
+	ADD COLUMN providers json AFTER providers_gob,
+	ADD COLUMN resources json AFTER resources_gob,
+	ADD COLUMN templates json AFTER templates_gob,
+	ADD COLUMN root_files json AFTER root_files_gob,
+	ADD COLUMN files json AFTER files_gob;
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- Deploy node_statuses
+
+BEGIN;
+
+CREATE TABLE node_statuses (
+	id int not null auto_increment,
+	node_id int not null,
+	run_id varchar(36) not null default '',
+	status enum('started', 'success', 'failure') not null,
+	run_list json,
+	start_time datetime not null,
+	end_time datetime,
+	primary key(id),
+	FOREIGN KEY(node_id)
+		REFERENCES nodes(id)
+		ON DELETE CASCADE,
+	index(status, end_time)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 ROW_FORMAT=COMPRESSED;
+
+COMMIT;
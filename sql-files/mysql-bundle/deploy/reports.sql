@@ -0,0 +1,25 @@
+-- Deploy reports
+
+BEGIN;
+
+CREATE TABLE reports (
+	id int not null auto_increment,
+	run_id varchar(36) not null,
+	node_name varchar(255) not null,
+	start_time datetime not null,
+	end_time datetime,
+	total_res_count int default 0,
+	status enum('started', 'success', 'failure') not null,
+	run_list text,
+	resources json,
+	data json,
+	created_at datetime not null,
+	updated_at datetime not null,
+	primary key(id),
+	unique key(run_id),
+	index(node_name),
+	index(start_time),
+	index(status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 ROW_FORMAT=COMPRESSED;
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Deploy saved_searches
+
+BEGIN;
+
+CREATE TABLE saved_searches (
+	id int not null auto_increment,
+	name varchar(255) not null,
+	idx varchar(255) not null,
+	query text not null,
+	cache_members tinyint(1) not null default 0,
+	created_at datetime not null,
+	updated_at datetime not null,
+	primary key(id),
+	unique key(name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+COMMIT;
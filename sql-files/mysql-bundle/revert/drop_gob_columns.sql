@@ -0,0 +1,41 @@
+-- Revert drop_gob_columns
+
+BEGIN;
+
+ALTER TABLE nodes
+	ADD COLUMN run_list_gob blob,
+	ADD COLUMN automatic_attr_gob blob,
+	ADD COLUMN normal_attr_gob blob,
+	ADD COLUMN default_attr_gob blob,
+	ADD COLUMN override_attr_gob blob;
+
+ALTER TABLE roles
+	ADD COLUMN run_list_gob blob,
+	ADD COLUMN env_run_lists_gob blob,
+	ADD COLUMN default_attr_gob blob,
+	ADD COLUMN override_attr_gob blob;
+
+ALTER TABLE environments
+	ADD COLUMN default_attr_gob blob,
+	ADD COLUMN override_attr_gob blob,
+	ADD COLUMN cookbook_vers_gob blob;
+
+ALTER TABLE data_bag_items
+	ADD COLUMN raw_data_gob blob;
+
+ALTER TABLE sandboxes
+	ADD COLUMN checksums_gob blob;
+
+ALTER TABLE cookbook_versions
+	ADD COLUMN metadata_gob blob,
+	ADD COLUMN definitions_gob blob,
+	ADD COLUMN libraries_gob blob,
+	ADD COLUMN attributes_gob blob,
+	ADD COLUMN recipes_gob blob,
+	ADD COLUMN providers_gob blob,
+	ADD COLUMN resources_gob blob,
+	ADD COLUMN templates_gob blob,
+	ADD COLUMN root_files_gob blob,
+	ADD COLUMN files_gob blob;
+
+COMMIT;
@@ -0,0 +1,68 @@
+-- Revert json_columns
+
+-- Data that was saved as JSON after this change was deployed is lost; only
+-- the gob data that has not been converted yet can be restored.
+
+BEGIN;
+
+ALTER TABLE nodes
+	DROP COLUMN run_list,
+	DROP COLUMN automatic_attr,
+	DROP COLUMN normal_attr,
+	DROP COLUMN default_attr,
+	DROP COLUMN override_attr,
+	CHANGE run_list_gob run_list blob,
+	CHANGE automatic_attr_gob automatic_attr blob,
+	CHANGE normal_attr_gob normal_attr blob,
+	CHANGE default_attr_gob default_attr blob,
+	CHANGE override_attr_gob override_attr blob;
+
+ALTER TABLE roles
+	DROP COLUMN run_list,
+	DROP COLUMN env_run_lists,
+	DROP COLUMN default_attr,
+	DROP COLUMN override_attr,
+	CHANGE run_list_gob run_list blob,
+	CHANGE env_run_lists_gob env_run_lists blob,
+	CHANGE default_attr_gob default_attr blob,
+	CHANGE override_attr_gob override_attr blob;
+
+ALTER TABLE environments
+	DROP COLUMN default_attr,
+	DROP COLUMN override_attr,
+	DROP COLUMN cookbook_vers,
+	CHANGE default_attr_gob default_attr blob,
+	CHANGE override_attr_gob override_attr blob,
+	CHANGE cookbook_vers_gob cookbook_vers blob;
+
+ALTER TABLE data_bag_items
+	DROP COLUMN raw_data,
+	CHANGE raw_data_gob raw_data blob;
+
+ALTER TABLE sandboxes
+	DROP COLUMN checksums,
+	CHANGE checksums_gob checksums blob;
+
+ALTER TABLE cookbook_versions
+	DROP COLUMN metadata,
+	DROP COLUMN definitions,
+	DROP COLUMN libraries,
+	DROP COLUMN attributes,
+	DROP COLUMN recipes,
+	DROP COLUMN providers,
+	DROP COLUMN resources,
+	DROP COLUMN templates,
+	DROP COLUMN root_files,
+	DROP COLUMN files,
+	CHANGE metadata_gob metadata blob,
+	CHANGE definitions_gob definitions blob,
+	CHANGE libraries_gob libraries blob,
+	CHANGE attributes_gob attributes blob,
+	CHANGE recipes_gob recipes blob,
+	CHANGE providers_gob providers blob,
+	CHANGE resources_gob resources blob,
+	CHANGE templates_gob templates blob,
+	CHANGE root_files_gob root_files blob,
+	CHANGE files_gob files blob;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert node_statuses
+
+BEGIN;
+
+DROP TABLE node_statuses;
+
+COMMIT;
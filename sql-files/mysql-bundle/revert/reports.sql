@@ -0,0 +1,7 @@
+-- Revert reports
+
+BEGIN;
+
+DROP TABLE reports;
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Verify json_columns
+
+BEGIN;
+
+SELECT id, run_list, automatic_attr, normal_attr, default_attr, override_attr FROM nodes WHERE 0;
+SELECT id, run_list, env_run_lists, default_attr, override_attr FROM roles WHERE 0;
+SELECT id, default_attr, override_attr, cookbook_vers FROM environments WHERE 0;
+SELECT id, raw_data FROM data_bag_items WHERE 0;
+SELECT id, checksums FROM sandboxes WHERE 0;
+SELECT id, metadata, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files FROM cookbook_versions WHERE 0;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify node_statuses
+
+BEGIN;
+
+SELECT id, node_id, run_id, status, run_list, start_time, end_time FROM node_statuses WHERE 0;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify reports
+
+BEGIN;
+
+SELECT id, run_id, node_name, start_time, end_time, total_res_count, status, run_list, resources, data, created_at, updated_at FROM reports WHERE 0;
+
+ROLLBACK;
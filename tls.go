@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/ctdk/goiardi/config"
+	"io/ioutil"
+	"sync"
+)
+
+/* The server's certificate is loaded by goiardi rather than by net/http, so a
+ * renewed certificate can be picked up by reloading the config with SIGHUP
+ * instead of restarting. The CA for client certificates and the other TLS
+ * settings are picked up the same way. */
+type certLoader struct {
+	m sync.RWMutex
+	cert *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+var serverCert = new(certLoader)
+
+func (c *certLoader) load(certFile string, keyFile string, clientCAFile string) error {
+	cert, pool, err := readCerts(certFile, keyFile, clientCAFile)
+	if err != nil {
+		return err
+	}
+	c.set(cert, pool)
+	return nil
+}
+
+func (c *certLoader) set(cert *tls.Certificate, clientCAs *x509.CertPool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.cert = cert
+	c.clientCAs = clientCAs
+}
+
+/* Read the server's certificate and key, and the CA for client certificates
+ * if there is one, without putting them in use. */
+func readCerts(certFile string, keyFile string, clientCAFile string) (*tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pool *x509.CertPool
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("No certificates could be read from %s", clientCAFile)
+		}
+	}
+	return &cert, pool, nil
+}
+
+func (c *certLoader) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.cert, nil
+}
+
+/* Each connection gets the TLS settings from the config as it is then. */
+func (c *certLoader) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	conf := config.Get()
+	c.m.RLock()
+	defer c.m.RUnlock()
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{ *c.cert },
+		MinVersion: conf.SslMinVersionId,
+		CipherSuites: conf.SslCipherIds,
+		NextProtos: []string{ "h2", "http/1.1" },
+	}
+	if conf.SslDisableHTTP2 {
+		tc.NextProtos = []string{ "http/1.1" }
+	}
+	if c.clientCAs != nil {
+		tc.ClientCAs = c.clientCAs
+		if conf.SslClientCerts == "optional" {
+			tc.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tc, nil
+}
+
+func tlsConfig() *tls.Config {
+	return &tls.Config{ GetCertificate: serverCert.getCertificate, GetConfigForClient: serverCert.getConfigForClient }
+}
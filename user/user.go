@@ -29,6 +29,7 @@ import (
 	"github.com/ctdk/goiardi/data_store"
 	"fmt"
 	"github.com/ctdk/goiardi/chef_crypto"
+	"github.com/ctdk/goiardi/indexer"
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/config"
 	"net/http"
@@ -42,7 +43,7 @@ type User struct {
 	Name string `json:"name"`
 	Email string `json:"email"`
 	Admin bool `json:"admin"`
-	pubKey string `json:"public_key"`
+	pubKey string
 	passwd string
 	Salt []byte
 }
@@ -53,15 +54,23 @@ type privUser struct {
 	Email *string `json:"email"`
 	Admin *bool `json:"admin"`
 	PublicKey *string `json:"public_key"`
-	Passwd *string `json:"public_key"`
+	Passwd *string `json:"password"`
 	Salt *[]byte `json:"salt"`
 }
 
+/* The parts of a user that are indexed for search. */
+type flatUser struct {
+	Username string `json:"username"`
+	Name string `json:"name"`
+	Email string `json:"email"`
+	Admin bool `json:"admin"`
+}
+
 // Create a new API user.
 func New(name string) (*User, util.Gerror) {
 	var found bool
 	var err util.Gerror
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var uerr error
 		found, uerr = checkForUserMySQL(data_store.Dbh, name)
 		if uerr != nil {
@@ -102,7 +111,7 @@ func New(name string) (*User, util.Gerror) {
 // Gets a user.
 func Get(name string) (*User, util.Gerror){
 	var user *User
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		var err error
 		user, err = getUserMySQL(name)
 		if err != nil {
@@ -121,6 +130,7 @@ func Get(name string) (*User, util.Gerror){
 		u, found := ds.Get("user", name)
 		if !found {
 			err := util.Errorf("User %s not found", name)
+			err.SetStatus(http.StatusNotFound)
 			return nil, err
 		}
 		if u != nil {
@@ -132,7 +142,7 @@ func Get(name string) (*User, util.Gerror){
 
 // Save the user's current state.
 func (u *User) Save() util.Gerror {
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := u.saveMySQL()
 		if err != nil {
 			return err
@@ -146,6 +156,7 @@ func (u *User) Save() util.Gerror {
 		ds := data_store.New()
 		ds.Set("user", u.Username, u)
 	}
+	indexer.IndexObj(u)
 	return nil
 }
 
@@ -156,7 +167,7 @@ func (u *User) Delete() util.Gerror {
 		err := util.Errorf("Cannot delete the last admin")
 		return err
 	}
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		err := u.deleteMySQL()
 		if err != nil {
 			return nil
@@ -165,6 +176,7 @@ func (u *User) Delete() util.Gerror {
 		ds := data_store.New()
 		ds.Delete("user", u.Username)
 	}
+	indexer.DeleteItemFromCollection("user", u.Username)
 	return nil
 }
 
@@ -179,7 +191,7 @@ func (u *User) Rename(new_name string) util.Gerror {
 		err.SetStatus(http.StatusForbidden)
 		return err
 	}
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		if err := u.renameMySQL(new_name); err != nil {
 			return err
 		}
@@ -197,6 +209,7 @@ func (u *User) Rename(new_name string) util.Gerror {
 		}
 		ds.Delete("client", u.Username)
 	}
+	indexer.DeleteItemFromCollection("user", u.Username)
 	u.Username = new_name
 	return nil
 }
@@ -286,7 +299,7 @@ func (u *User)UpdateFromJson(json_user map[string]interface{}) util.Gerror {
 // Returns a list of users.
 func GetList() []string {
 	var user_list []string
-	if config.Config.UseMySQL {
+	if config.Get().UseMySQL {
 		user_list = getListMySQL()
 	} else {
 		ds := data_store.New()
@@ -310,7 +323,7 @@ func (u *User) ToJson() map[string]interface{} {
 func (u *User) isLastAdmin() bool {
 	if u.Admin {
 		numAdmins := 0
-		if config.Config.UseMySQL {
+		if config.Get().UseMySQL {
 			numAdmins = numAdminsMySQL()
 		} else {		
 			user_list := GetList()
@@ -353,7 +366,7 @@ func ValidatePublicKey(publicKey interface{}) (bool, util.Gerror) {
 
 // Is the user an admin? If use-auth is false, this always returns true.
 func (u *User) IsAdmin() bool {
-	if !config.Config.UseAuth {
+	if !config.Get().UseAuth {
 		return true
 	}
 	return u.Admin
@@ -367,7 +380,7 @@ func (u *User) IsValidator() bool {
 
 // Is the actor in question the same client or user as the caller?
 func (u *User) IsSelf(other interface{}) bool {
-	if !config.Config.UseAuth {
+	if !config.Get().UseAuth {
 		return true
 	}
 	if ou, ok := other.(*User); ok {
@@ -427,7 +440,7 @@ func (u *User) SetPasswd(password string) util.Gerror {
 	}
 	/* If those validations pass, set the password */
 	var perr error
-	u.passwd, perr = chef_crypto.HashPasswd(password, u.Salt)
+	u.passwd, perr = chef_crypto.CryptPasswd(password)
 	if perr != nil {
 		err := util.Errorf(perr.Error())
 		return err
@@ -437,19 +450,27 @@ func (u *User) SetPasswd(password string) util.Gerror {
 
 // Check the provided password to see if it matches the stored password hash.
 func (u *User) CheckPasswd(password string) util.Gerror {
-	h, perr := chef_crypto.HashPasswd(password, u.Salt) 
-	if perr != nil {
-		err := util.Errorf(perr.Error())
-		return err
-	}
-	if u.passwd != h {
+	if !chef_crypto.CheckPasswdHash(password, u.passwd, u.Salt) {
 		err := util.Errorf("password did not match")
 		return err
 	}
-	
 	return nil
 }
 
+// Re-hash the user's password if it was hashed with the old SHA512 scheme, or
+// with a weaker bcrypt cost than new passwords get. The password should have
+// already been checked with CheckPasswd. Returns true if the password was
+// re-hashed, in which case the user needs to be saved.
+func (u *User) UpgradePasswd(password string) (bool, util.Gerror) {
+	if !chef_crypto.PasswdHashOutdated(u.passwd) {
+		return false, nil
+	}
+	if err := u.SetPasswd(password); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func validateUserName(name string) util.Gerror {
 	if !util.ValidateUserName(name) {
 		err := util.Errorf("Field 'name' invalid")
@@ -466,6 +487,26 @@ func (u *User) URLType() string {
 	return "users"
 }
 
+/* Functions to support indexing */
+
+func (u *User) DocId() string {
+	return u.Username
+}
+
+func (u *User) Index() string {
+	return "user"
+}
+
+func (u *User) Flatten() []string {
+	flatten := util.FlattenObj(u.flatExport())
+	indexified := util.Indexify(flatten)
+	return indexified
+}
+
+func (u *User) flatExport() *flatUser {
+	return &flatUser{ Username: u.Username, Name: u.Name, Email: u.Email, Admin: u.Admin }
+}
+
 func (u *User) export() *privUser {
 	return &privUser{ Name: &u.Name, Username: &u.Username, PublicKey: &u.pubKey, Admin: &u.Admin, Email: &u.Email, Passwd: &u.passwd, Salt: &u.Salt }
 }
@@ -23,6 +23,8 @@ import (
 	"bytes"
 	"fmt"
 	"encoding/gob"
+	"strings"
+	"github.com/ctdk/goiardi/chef_crypto"
 )
 
 func TestNewUser(t *testing.T) {
@@ -57,6 +59,29 @@ func TestSetPasswd(t *testing.T) {
 	}
 }
 
+func TestUpgradePasswd(t *testing.T) {
+	c, _ := New("foo")
+	pass := "abc123"
+	/* A password hashed the way they were before bcrypt. */
+	c.passwd, _ = chef_crypto.HashPasswd(pass, c.Salt)
+	if err := c.CheckPasswd(pass); err != nil {
+		t.Errorf("%s should have matched the old style password hash, but it didn't", pass)
+	}
+	upgraded, err := c.UpgradePasswd(pass)
+	if err != nil || !upgraded {
+		t.Errorf("old style password hash should have been upgraded, but wasn't")
+	}
+	if !strings.HasPrefix(c.passwd, "$2") {
+		t.Errorf("upgraded password hash %s isn't a bcrypt hash", c.passwd)
+	}
+	if err := c.CheckPasswd(pass); err != nil {
+		t.Errorf("%s should have matched the upgraded password hash, but it didn't", pass)
+	}
+	if upgraded, _ = c.UpgradePasswd(pass); upgraded {
+		t.Errorf("bcrypt password hash was upgraded again")
+	}
+}
+
 func TestGobEncodeDecode(t *testing.T){
 	c, _ := New("footged")
 	saved := new(bytes.Buffer)
@@ -163,12 +163,12 @@ func Indexify(flattened map[string]interface{}) []string {
 	for k, v := range flattened {
 		switch v := v.(type) {
 			case string:
-				v = escapeStr(v)
+				v = EscapeStr(v)
 				line := fmt.Sprintf("%s:%s", k, v)
 				readyToIndex = append(readyToIndex, line)
 			case []string:
 				for _, w := range v {
-					w = escapeStr(w)
+					w = EscapeStr(w)
 					line := fmt.Sprintf("%s:%s", k, w)
 					readyToIndex = append(readyToIndex, line)
 				}
@@ -181,7 +181,9 @@ func Indexify(flattened map[string]interface{}) []string {
 	return readyToIndex
 }
 
-func escapeStr(s string) string {
+// Escape brackets and double colons in a value the way they're escaped when
+// the value is indexed.
+func EscapeStr(s string) string {
 	s = strings.Replace(s, "[", "\\[", -1)
 	s = strings.Replace(s, "]", "\\]", -1)
 	s = strings.Replace(s, "::", "\\:\\:", -1)
@@ -335,33 +335,27 @@ func ValidateAsConstraint(t interface{}) (bool, Gerror) {
 func ValidateRunList(rl interface{}) ([]string, Gerror) {
 	switch rl := rl.(type) {
 		case []string:
-			for i, r := range rl {
-				if j, err := validateRLItem(r); err != nil {
-					return nil, err
-				} else {
-					if j == "" {
-						err := Errorf("Field 'run_list' is not a valid run list")
-						return nil, err
-					} 
-					rl[i] = j
-				}
-			}
-
-			/* Remove dupes */
-			rl_hash := make(map[string]string, len(rl))
-			for _, u := range rl {
-				rl_hash[u] = u
-			}
-			rl = make([]string, len(rl_hash))
-			z := 0
-			for k, _ := range rl_hash {
-				rl[z] = k
-				z++
+			rl, err := NormalizeRunList(rl)
+			if err != nil {
+				return nil, err
 			}
 
 			// TODO: needs a more accurate sort
 			sort.Strings(rl)
 			return rl, nil
+		case []interface{}:
+			/* Run lists nested in other JSON, like a role's
+			 * env_run_lists, come in this way. */
+			str_rl := make([]string, len(rl))
+			for i, r := range rl {
+				str_r, ok := r.(string)
+				if !ok {
+					err := Errorf("Field 'run_list' is not a valid run list")
+					return nil, err
+				}
+				str_rl[i] = str_r
+			}
+			return ValidateRunList(str_rl)
 		case nil:
 			/* separate to do more validations above */
 			nil_rl := make([]string, 0)
@@ -372,6 +366,34 @@ func ValidateRunList(rl interface{}) ([]string, Gerror) {
 	}
 }
 
+// Validate the items in a run list, turn bare recipe names into
+// "recipe[name]", and remove duplicates. Unlike ValidateRunList, the order of
+// the run list is kept. The slice passed in is modified.
+func NormalizeRunList(rl []string) ([]string, Gerror) {
+	for i, r := range rl {
+		if j, err := validateRLItem(r); err != nil {
+			return nil, err
+		} else {
+			if j == "" {
+				err := Errorf("Field 'run_list' is not a valid run list")
+				return nil, err
+			} 
+			rl[i] = j
+		}
+	}
+
+	/* Remove dupes */
+	seen := make(map[string]bool, len(rl))
+	normalized := make([]string, 0, len(rl))
+	for _, u := range rl {
+		if !seen[u] {
+			seen[u] = true
+			normalized = append(normalized, u)
+		}
+	}
+	return normalized, nil
+}
+
 func validateRLItem(item string) (string, Gerror){
 	/* There's a few places this might be used. */
 	err := Errorf("Field 'run_list' is not a valid run list")